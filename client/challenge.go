@@ -0,0 +1,155 @@
+package client
+
+import (
+  "errors"
+  "fmt"
+
+  "github.com/MikhailMS/go-radius/protocol"
+  "github.com/MikhailMS/go-radius/tools"
+)
+
+// MAX_CHALLENGE_ROUNDS is the maximum number of Access-Challenges Client follows in single
+// conversation
+const MAX_CHALLENGE_ROUNDS = 16
+
+// IDs of attributes, that are used to authenticate user; they are looked up by ID, as their
+// names differ between dictionaries
+const (
+  userNameID     uint8 = 1
+  userPasswordID uint8 = 2
+  chapPasswordID uint8 = 3
+  replyMessageID uint8 = 18
+  stateID        uint8 = 24
+  promptID       uint8 = 76
+)
+
+// Challenge is Access-Challenge received from RADIUS Server during conversation
+type Challenge struct {
+  reply protocol.RadiusPacket
+}
+
+// Packet returns Access-Challenge packet
+func (challenge *Challenge) Packet() protocol.RadiusPacket {
+  return challenge.reply
+}
+
+// ReplyMessage returns text of Access-Challenge to be displayed to the user; multiple Reply-Message
+// attributes are concatenated
+func (challenge *Challenge) ReplyMessage() string {
+  return replyMessage(&challenge.reply)
+}
+
+// Echo reports if user's response should be echoed as it is typed (Prompt attribute, RFC 2869);
+// without Prompt attribute response is not echoed
+func (challenge *Challenge) Echo() bool {
+  prompt    := challenge.reply.AttributeByID(promptID)
+  value, ok := tools.BytesToInteger(prompt.Value())
+  return ok && value == 1
+}
+
+// replyMessage returns concatenated text of Reply-Message attributes of the packet
+func replyMessage(packet *protocol.RadiusPacket) string {
+  var message []uint8
+
+  for _, attr := range packet.Attributes() {
+    if attr.ID() == replyMessageID {
+      message = append(message, attr.Value()...)
+    }
+  }
+  return string(message)
+}
+
+// ChallengeHandler is called for every Access-Challenge and returns user's response, which is sent
+// as User-Password of the next Access-Request
+type ChallengeHandler func(challenge *Challenge) ([]uint8, error)
+
+// Converse sends Access-Request and follows Access-Challenges, passing each of them to handler,
+// till Access-Accept or Access-Reject is received and returned
+//
+// Each next Access-Request carries attributes of the initial one, except User-Password,
+// CHAP-Password & State: State is copied from Access-Challenge and response of handler is sent as
+// User-Password. Message-Authenticator is generated, if initial Access-Request has one
+func (client *Client) Converse(request *protocol.RadiusPacket, handler ChallengeHandler) (protocol.RadiusPacket, error) {
+  packet := *request
+
+  for round := 0; round <= MAX_CHALLENGE_ROUNDS; round++ {
+    replyBytes, err := client.SendAndReceivePacket(&packet)
+    if err != nil {
+      return protocol.RadiusPacket{}, err
+    }
+
+    if ok, err := client.VerifyReply(&packet, &replyBytes); !ok {
+      return protocol.RadiusPacket{}, err
+    }
+
+    reply, err := client.InitialiseRadiusPacketFromBytes(&replyBytes)
+    if err != nil {
+      return protocol.RadiusPacket{}, err
+    }
+
+    switch reply.Code() {
+      case protocol.AccessAccept, protocol.AccessReject:
+        return reply, nil
+      case protocol.AccessChallenge:
+      default:
+        return protocol.RadiusPacket{}, errors.New(fmt.Sprintf("unexpected reply code %d to Access-Request", reply.Code()))
+    }
+
+    response, err := handler(&Challenge { reply })
+    if err != nil {
+      return protocol.RadiusPacket{}, err
+    }
+
+    packet, err = client.challengeResponse(request, &reply, response)
+    if err != nil {
+      return protocol.RadiusPacket{}, err
+    }
+  }
+
+  return protocol.RadiusPacket{}, errors.New("conversation is not finished within allowed number of Access-Challenges")
+}
+
+// challengeResponse creates Access-Request, that answers Access-Challenge with given response
+func (client *Client) challengeResponse(request, challenge *protocol.RadiusPacket, response []uint8) (protocol.RadiusPacket, error) {
+  packet        := client.CreateAuthRadiusPacket()
+  authenticator := packet.Authenticator()
+  secret        := []uint8(client.secret)
+
+  var attributes []protocol.RadiusAttribute
+  hasMsgAuth := false
+
+  for _, attr := range request.Attributes() {
+    switch attr.ID() {
+      case userPasswordID, chapPasswordID, stateID:
+        continue
+      case protocol.MESSAGE_AUTHENTICATOR_ID:
+        hasMsgAuth = true
+    }
+    attributes = append(attributes, attr)
+  }
+
+  password          := tools.EncryptData(&response, &authenticator, &secret)
+  passwordAttr, err := client.CreateAttributeByID(userPasswordID, &password)
+  if err != nil {
+    return protocol.RadiusPacket{}, err
+  }
+  attributes = append(attributes, passwordAttr)
+
+  if state := challenge.AttributeByID(stateID); len(state.Value()) > 0 {
+    stateValue     := append([]uint8{}, state.Value()...)
+    stateAttr, err := client.CreateAttributeByID(stateID, &stateValue)
+    if err != nil {
+      return protocol.RadiusPacket{}, err
+    }
+    attributes = append(attributes, stateAttr)
+  }
+
+  packet.SetAttributes(attributes)
+
+  if hasMsgAuth {
+    if err := packet.GenerateMessageAuthenticator(client.secret); err != nil {
+      return protocol.RadiusPacket{}, err
+    }
+  }
+  return packet, nil
+}
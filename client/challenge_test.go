@@ -0,0 +1,143 @@
+package client
+
+import (
+  "bytes"
+  "errors"
+  "net"
+  "testing"
+
+  "github.com/stretchr/testify/assert"
+
+  "github.com/MikhailMS/go-radius/protocol"
+  "github.com/MikhailMS/go-radius/server"
+  "github.com/MikhailMS/go-radius/tools"
+)
+
+// startTestChallengeServer starts RADIUS Server, that challenges Access-Request with password
+// "password" for OTP "123456" and accepts Access-Request with password "no-otp" straight away
+func startTestChallengeServer(t *testing.T, dictionary protocol.Dictionary) uint16 {
+  radServer := server.InitialiseServer(dictionary, map[string]string { "127.0.0.1": "secret" }, "127.0.0.1", 1, 2)
+  runtime   := server.InitialiseRuntime(&radServer)
+
+  runtime.SetHandler(protocol.AUTH, func(request *server.Request) (protocol.TypeCode, []protocol.RadiusAttribute, error) {
+    packet := request.Packet()
+
+    // Request could carry only one Message-Authenticator (RFC 3579, section 3.2)
+    msgAuthCount := 0
+    for _, attr := range packet.Attributes() {
+      if attr.ID() == protocol.MESSAGE_AUTHENTICATOR_ID {
+        msgAuthCount++
+      }
+    }
+    if msgAuthCount > 1 {
+      return protocol.AccessReject, nil, nil
+    }
+
+    password      := packet.AttributeByID(userPasswordID)
+    value         := password.Value()
+    authenticator := packet.Authenticator()
+    secret        := []uint8(request.Secret())
+    cleartext     := bytes.TrimRight(tools.DecryptData(&value, &authenticator, &secret), "\x00")
+
+    state := packet.AttributeByID(stateID)
+    if len(state.Value()) == 0 {
+      if string(cleartext) == "no-otp" {
+        return protocol.AccessAccept, nil, nil
+      }
+      if string(cleartext) != "password" {
+        message        := []uint8("Wrong password")
+        messageAttr, _ := radServer.CreateAttributeByID(replyMessageID, &message)
+        return protocol.AccessReject, []protocol.RadiusAttribute { messageAttr }, nil
+      }
+
+      message        := []uint8("Enter OTP")
+      stateValue     := []uint8("otp-state")
+      prompt         := tools.IntegerToBytes(1)
+      messageAttr, _ := radServer.CreateAttributeByID(replyMessageID, &message)
+      stateAttr, _   := radServer.CreateAttributeByID(stateID, &stateValue)
+      promptAttr, _  := radServer.CreateAttributeByID(promptID, &prompt)
+
+      return protocol.AccessChallenge, []protocol.RadiusAttribute { messageAttr, stateAttr, promptAttr }, nil
+    }
+
+    if string(state.Value()) == "otp-state" && string(cleartext) == "123456" {
+      return protocol.AccessAccept, nil, nil
+    }
+    return protocol.AccessReject, nil, nil
+  })
+
+  conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+  if err != nil {
+    t.Fatal(err)
+  }
+  go runtime.ServePacketConn(conn, protocol.AUTH)
+  t.Cleanup(func() { runtime.Close() })
+
+  return uint16(conn.LocalAddr().(*net.UDPAddr).Port)
+}
+
+func TestConverse(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+  port          := startTestChallengeServer(t, dictionary)
+
+  for _, testCase := range []struct {
+    password     string
+    otp          string
+    challenges   int
+    expectedCode protocol.TypeCode
+  } {
+    { "password", "123456", 1, protocol.AccessAccept },
+    { "password", "654321", 1, protocol.AccessReject },
+    { "wrong",    "123456", 0, protocol.AccessReject },
+  } {
+    client := InitialiseClient(dictionary, "127.0.0.1", "secret", 1, 2)
+    client.SetPort(protocol.AUTH, port)
+
+    request       := client.CreateAuthRadiusPacket()
+    authenticator := request.Authenticator()
+    secret        := []uint8("secret")
+    userName      := []uint8("testing")
+    password      := []uint8(testCase.password)
+    encrypted     := tools.EncryptData(&password, &authenticator, &secret)
+
+    userNameAttr, _ := client.CreateAttributeByName("User-Name", &userName)
+    passwordAttr, _ := client.CreateAttributeByID(userPasswordID, &encrypted)
+    request.SetAttributes([]protocol.RadiusAttribute { userNameAttr, passwordAttr })
+
+    challenges := 0
+    reply, err := client.Converse(&request, func(challenge *Challenge) ([]uint8, error) {
+      challenges++
+      assert.Equal(t, "Enter OTP", challenge.ReplyMessage(), "Reply-Message is not surfaced!")
+      assert.Equal(t, true,        challenge.Echo(),         "Prompt is not surfaced!")
+
+      return []uint8(testCase.otp), nil
+    })
+
+    assert.Equal(t, nil,                   err,          "Conversation failed!")
+    assert.Equal(t, testCase.challenges,   challenges,   "Number of Access-Challenges is not correct!")
+    assert.Equal(t, testCase.expectedCode, reply.Code(), "Conversation result is not correct!")
+  }
+}
+
+func TestConverseHandlerError(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+
+  client := InitialiseClient(dictionary, "127.0.0.1", "secret", 1, 2)
+  client.SetPort(protocol.AUTH, startTestChallengeServer(t, dictionary))
+
+  request       := client.CreateAuthRadiusPacket()
+  authenticator := request.Authenticator()
+  secret        := []uint8("secret")
+  password      := []uint8("password")
+  encrypted     := tools.EncryptData(&password, &authenticator, &secret)
+
+  passwordAttr, _ := client.CreateAttributeByID(userPasswordID, &encrypted)
+  request.SetAttributes([]protocol.RadiusAttribute { passwordAttr })
+
+  _, err := client.Converse(&request, func(challenge *Challenge) ([]uint8, error) {
+    return nil, errors.New("user cancelled")
+  })
+  assert.Equal(t, "user cancelled", err.Error(), "Error of ChallengeHandler is not returned!")
+}
@@ -2,20 +2,43 @@
 package client
 
 import (
+  "context"
+  "crypto/tls"
   "errors"
   "fmt"
-  "crypto/hmac"
-  "crypto/md5"
+  "net"
+  "strconv"
+  "time"
+
+  "github.com/pion/dtls/v2"
 
   "github.com/MikhailMS/go-radius/protocol"
+  "github.com/MikhailMS/go-radius/tools"
 )
 
+// ErrNoReply is returned, when RADIUS Server doesn't reply within timeout after all retries
+var ErrNoReply = errors.New("no reply received from RADIUS Server")
+
 type Client struct {
-  host    protocol.Host
-  server  string
-  secret  string
-  retries uint16
-  timeout uint16
+  host     protocol.Host
+  server   string
+  secret   string
+  retries  uint16
+  timeout  uint16
+  idSource protocol.PacketIDSource
+  stream   *streamTransport
+  pool     *socketPool
+  policy   *RetransmissionPolicy
+  dialer   ContextDialer
+  source   net.IP
+  nas      bool
+  nasID    string
+}
+
+// ContextDialer dials connections to RADIUS Server; it is implemented by *net.Dialer, so requests
+// could be sent from specific address or interface
+type ContextDialer interface {
+  DialContext(ctx context.Context, network, address string) (net.Conn, error)
 }
 
 // InitialiseClient initialises client
@@ -24,7 +47,134 @@ type Client struct {
 func InitialiseClient(dictionary protocol.Dictionary, server string, secret string, retries uint16, timeout uint16) Client {
   host := protocol.CreateHostWithDictionary(dictionary)
 
-  return Client { host, server, secret, retries, timeout }
+  return Client { host: host, server: server, secret: secret, retries: retries, timeout: timeout, idSource: protocol.CryptoRandSource{}, dialer: &net.Dialer{} }
+}
+
+// InitialiseRadSecClient initialises client, that sends requests over TLS (RadSec, RFC 6614) to
+// given port of RADIUS Server; config must contain client certificate, that Server would verify
+//
+// Requests of all RADIUS Message Types are sent over the same connection and are protected with
+// RADSEC_SECRET. They are not re-sent, as TLS is reliable transport, so timeout (in seconds) is
+// the time to wait for a reply
+func InitialiseRadSecClient(dictionary protocol.Dictionary, server string, port uint16, config *tls.Config, timeout uint16) Client {
+  client := InitialiseClient(dictionary, server, protocol.RADSEC_SECRET, 0, timeout)
+  client.SetPort(protocol.AUTH, port)
+  client.SetPort(protocol.ACCT, port)
+  client.SetPort(protocol.COA,  port)
+
+  dialer       := &tls.Dialer { Config: config }
+  client.stream = newStreamTransport(func(ctx context.Context, address string) (net.Conn, error) {
+    return dialer.DialContext(ctx, "tcp", address)
+  }, protocol.ReadStreamPacket, true)
+
+  return client
+}
+
+// InitialiseTCPClient initialises client, that sends requests over TCP (RFC 6613) to RADIUS Server
+//
+// Requests are sent over single connection per port, so multiple requests could be outstanding at
+// the same time. They are not re-sent, as TCP is reliable transport, so timeout (in seconds) is
+// the time to wait for a reply
+//
+// Please note that you would need to call **SetPort** manually to initialise Client in full
+func InitialiseTCPClient(dictionary protocol.Dictionary, server string, secret string, timeout uint16) Client {
+  client := InitialiseClient(dictionary, server, secret, 0, timeout)
+
+  dialer       := &net.Dialer{}
+  client.stream = newStreamTransport(func(ctx context.Context, address string) (net.Conn, error) {
+    return dialer.DialContext(ctx, "tcp", address)
+  }, protocol.ReadStreamPacket, true)
+
+  return client
+}
+
+// InitialiseDTLSClient initialises client, that sends requests over DTLS (RFC 7360) to given port of
+// RADIUS Server; config must contain client certificate, that Server would verify
+//
+// Requests of all RADIUS Message Types are sent over the same DTLS session and are protected with
+// RADIUS_DTLS_SECRET. As DTLS is datagram transport, packet is re-sent up to *retries* times, if no
+// reply is received within timeout (in seconds)
+func InitialiseDTLSClient(dictionary protocol.Dictionary, server string, port uint16, config *dtls.Config, retries uint16, timeout uint16) Client {
+  client := InitialiseClient(dictionary, server, protocol.RADIUS_DTLS_SECRET, retries, timeout)
+  client.SetPort(protocol.AUTH, port)
+  client.SetPort(protocol.ACCT, port)
+  client.SetPort(protocol.COA,  port)
+
+  client.stream = newStreamTransport(func(ctx context.Context, address string) (net.Conn, error) {
+    remoteAddr, err := net.ResolveUDPAddr("udp", address)
+    if err != nil {
+      return nil, err
+    }
+    return dtls.DialWithContext(ctx, "udp", remoteAddr, config)
+  }, protocol.ReadDatagramPacket, false)
+
+  return client
+}
+
+// **Optional**
+//
+// SetPacketIDSource sets source of IDs and authenticators for packets created by Client
+//
+// By default Client uses cryptographically secure random number generator, which is used for nil
+// source as well
+func (client *Client) SetPacketIDSource(source protocol.PacketIDSource) {
+  if source == nil {
+    source = protocol.CryptoRandSource{}
+  }
+  client.idSource = source
+}
+
+// packetIDSource returns source of IDs and authenticators, falling back to cryptographically secure
+// random number generator for Client, that is not initialised with one of **Initialise*Client**
+func (client *Client) packetIDSource() protocol.PacketIDSource {
+  if client.idSource == nil {
+    return protocol.CryptoRandSource{}
+  }
+  return client.idSource
+}
+
+// **Optional**
+//
+// SetSocketPool opens given number of UDP sockets, over which requests are sent, so up to 256
+// requests per socket could be outstanding at the same time
+//
+// Identifiers of packets are allocated by the pool: packet gets identifier, that is free on one
+// of the sockets, and its authenticators are generated again. Without the pool every request is
+// sent from its own socket
+func (client *Client) SetSocketPool(size int) error {
+  pool, err := newSocketPool(size, client.source)
+  if err != nil {
+    return err
+  }
+
+  if client.pool != nil {
+    client.pool.close()
+  }
+  client.pool = pool
+  return nil
+}
+
+// **Optional**
+//
+// SetPacketConn sets socket, over which requests are sent instead of sockets opened by Client, for
+// example in-memory connection in tests or socket of custom network stack
+//
+// Requests share the socket as they do sockets of the pool (see **SetSocketPool**); Client takes
+// ownership of the socket, so it is closed by **Close**
+func (client *Client) SetPacketConn(conn net.PacketConn) {
+  if client.pool != nil {
+    client.pool.close()
+  }
+  client.pool = newPacketConnPool([]net.PacketConn { conn })
+}
+
+// **Optional**
+//
+// SetDialer sets dialer, that opens UDP socket for every request sent without the pool
+//
+// By default sockets are opened with zero *net.Dialer
+func (client *Client) SetDialer(dialer ContextDialer) {
+  client.dialer = dialer
 }
 
 // **Required/Optional**
@@ -59,32 +209,61 @@ func (client *Client) Timeout() uint16 {
   return client.timeout
 }
 
+// Dictionary returns dictionary Client was initialised with
+func (client *Client) Dictionary() protocol.Dictionary {
+  return client.host.Dictionary()
+}
+
+// **Optional**
+//
+// SetIdleTimeout sets time after which connection of RadSec, TCP or DTLS transport, that has
+// no outstanding requests, is closed; connection is re-established with the next request
+//
+// By default connection is kept open until RADIUS Server closes it
+func (client *Client) SetIdleTimeout(timeout time.Duration) {
+  if client.stream != nil {
+    client.stream.idleTimeout = timeout
+  }
+}
+
+// Close closes connections of RadSec, TCP or DTLS transport and sockets of the pool; Client, that
+// sends requests over UDP without the pool, keeps no connections between requests
+func (client *Client) Close() error {
+  if client.pool != nil {
+    return client.pool.close()
+  }
+  if client.stream != nil {
+    return client.stream.close()
+  }
+  return nil
+}
+
 // CreateRadiusPacket creates RADIUS packet with any TypeCode without attributes
 //
 // You would need to set attributes manually via *set_attributes()* function
 func (client *Client) CreateRadiusPacket(typeCode protocol.TypeCode) protocol.RadiusPacket {
-  return protocol.InitialiseRadiusPacket(typeCode)
+  return protocol.InitialiseRadiusPacketWithSource(typeCode, client.packetIDSource())
 }
 
 // CreateAuthRadiusPacket creates RADIUS packet with AccessRequest TypeCode without attributes
 //
 // You would need to set attributes manually via *set_attributes()* function
 func (client *Client) CreateAuthRadiusPacket() protocol.RadiusPacket {
-  return protocol.InitialiseRadiusPacket(protocol.AccessRequest)
+  return protocol.InitialiseRadiusPacketWithSource(protocol.AccessRequest, client.packetIDSource())
 }
 
 // CreateAcctRadiusPacket creates RADIUS packet with AccountingRequest TypeCode without attributes
 //
 // You would need to set attributes manually via *set_attributes()* function
 func (client *Client) CreateAcctRadiusPacket() protocol.RadiusPacket {
-  return protocol.InitialiseRadiusPacket(protocol.AccountingRequest)
+  return protocol.InitialiseRadiusPacketWithSource(protocol.AccountingRequest, client.packetIDSource())
 }
 
 // CreateCoaRadiusPacket creates RADIUS packet with CoARequest TypeCode without attributes
 //
 // You would need to set attributes manually via *set_attributes()* function
 func (client *Client) CreateCoaRadiusPacket() protocol.RadiusPacket {
-  return protocol.InitialiseRadiusPacket(protocol.CoARequest)
+  return protocol.InitialiseRadiusPacketWithSource(protocol.CoARequest, client.packetIDSource())
 }
 
 // CreateAttributeByName creates RADIUS packet attribute by Name, that is defined in dictionary file
@@ -97,6 +276,29 @@ func (client *Client) CreateAttributeByID(attrID uint8, value *[]uint8) (protoco
   return client.host.CreateAttributeByID(attrID, value)
 }
 
+// CreateChapAttributes creates CHAP-Password & CHAP-Challenge attributes from cleartext password
+//
+// CHAP identifier and 16 octets long challenge are taken from Client's packet ID source, so
+// they are random unless custom source is set
+func (client *Client) CreateChapAttributes(password []uint8) ([]protocol.RadiusAttribute, error) {
+  chapID    := client.packetIDSource().PacketID()
+  challenge := client.packetIDSource().PacketAuthenticator()
+
+  chapPassword := append([]uint8{ chapID }, tools.ChapResponse(chapID, &password, &challenge)...)
+
+  chapPasswordAttr, err := client.CreateAttributeByName("CHAP-Password", &chapPassword)
+  if err != nil {
+    return nil, err
+  }
+
+  chapChallengeAttr, err := client.CreateAttributeByName("CHAP-Challenge", &challenge)
+  if err != nil {
+    return nil, err
+  }
+
+  return []protocol.RadiusAttribute { chapPasswordAttr, chapChallengeAttr }, nil
+}
+
 // RadiusAttrOriginalStringValue creates RADIUS packet attribute by ID, that is defined in dictionary file
 func (client *Client) RadiusAttrOriginalStringValue(attribute protocol.RadiusAttribute) (string, error) {
   dictAttr, ok := client.host.DictionaryAttributeByID(attribute.ID())
@@ -136,7 +338,10 @@ func (client *Client) InitialiseRadiusPacketFromBytes(reply *[]uint8) (protocol.
   return client.host.InitialiseRadiusPacketFromBytes(reply)
 }
 
-// VerifyReply creates RADIUS packet attribute by ID, that is defined in dictionary file
+// VerifyReply verifies that reply is sent in response to request and is signed with client's secret
+//
+// Authenticator and, if present, Message-Authenticator are verified over the reply bytes as they
+// were received
 func (client *Client) VerifyReply(request *protocol.RadiusPacket, reply *[]uint8) (bool, error) {
   if len(*reply) == 0 {
     return false, errors.New("Empty reply")
@@ -146,18 +351,16 @@ func (client *Client) VerifyReply(request *protocol.RadiusPacket, reply *[]uint8
     return false, errors.New("Packet identifier mismatch")
   }
 
-  hmacHash := md5.New()
-
-  hmacHash.Write((*reply)[0:4])           // Append reply type code, reply ID and reply length
-  hmacHash.Write(request.Authenticator()) // Append request authenticator
-  hmacHash.Write((*reply)[20:])           // Append rest of the reply
-  hmacHash.Write([]uint8(client.secret))  // Append secret
-
-  if hmac.Equal((*reply)[4:20], hmacHash.Sum(nil)) {
-    return true, nil
+  err := client.host.VerifyReplyAuthenticator(client.secret, reply, request.Authenticator())
+  if err != nil {
+    return false, err
   }
-  return false, errors.New("Packet authenticator mismatch")
 
+  err = client.host.VerifyReplyMessageAuthenticator(client.secret, reply, request.Authenticator())
+  if err != nil && !errors.Is(err, protocol.ErrNoMessageAuthenticator) {
+    return false, err
+  }
+  return true, nil
 }
 
 // VerifyMessageAuthenticator verifies that reply packet's Message-Authenticator attribute is valid
@@ -169,3 +372,209 @@ func (client *Client) VerifyMessageAuthenticator(packet *[]uint8) error {
 func (client *Client) VerifyPacketAttributes(packet *[]uint8) error {
   return client.host.VerifyPacketAttributes(packet)
 }
+
+// SendAndReceivePacket sends RadiusPacket to the port of RADIUS Server, that is responsible for
+// packet's TypeCode, and waits for a reply
+//
+// If no reply is received within timeout (in seconds), packet is re-sent up to *retries* times or
+// as defined by **SetRetransmissionPolicy**; re-sent Accounting-Request gets new identifier, so
+// packet is updated in place and reply should be verified against it
+func (client *Client) SendAndReceivePacket(packet *protocol.RadiusPacket) ([]uint8, error) {
+  return client.ExchangeContext(context.Background(), packet)
+}
+
+// ExchangeContext works as **SendAndReceivePacket**, but stops sending packet and waiting for a
+// reply once ctx is cancelled or its deadline is exceeded; ctx error is returned in such case
+func (client *Client) ExchangeContext(ctx context.Context, packet *protocol.RadiusPacket) ([]uint8, error) {
+  if err := ctx.Err(); err != nil {
+    return nil, err
+  }
+
+  port, ok := client.Port(packet.Code())
+  if !ok {
+    return nil, errors.New(fmt.Sprintf("no port is set for packet with code %d", packet.Code()))
+  }
+
+  return client.exchange(ctx, packet, port)
+}
+
+// ExchangeResult is the result of asynchronous exchange started with **ExchangeAsync**
+type ExchangeResult struct {
+  Packet *protocol.RadiusPacket // Request, that was sent
+  Reply  []uint8                // Reply bytes, if reply is received
+  Err    error                  // Error, if reply is not received
+}
+
+// ExchangeAsync sends packet as **ExchangeContext** does, but doesn't wait for a reply; the result
+// is delivered to returned channel, which receives exactly one ExchangeResult
+//
+// Packet must not be used until the result is received, as it could be updated when re-sent. Many
+// requests could be sent at once and their results gathered later
+func (client *Client) ExchangeAsync(ctx context.Context, packet *protocol.RadiusPacket) <-chan ExchangeResult {
+  result := make(chan ExchangeResult, 1)
+
+  go func() {
+    reply, err := client.ExchangeContext(ctx, packet)
+    result <- ExchangeResult { packet, reply, err }
+  }()
+
+  return result
+}
+
+// Ping sends Status-Server packet (RFC 5997) to AUTH or ACCT port of RADIUS Server and returns
+// round-trip time
+//
+// Reply must be signed with Message-Authenticator and must be Access-Accept for AUTH port or
+// Accounting-Response for ACCT port
+func (client *Client) Ping(msgType protocol.RadiusMsgType) (time.Duration, error) {
+  return client.PingContext(context.Background(), msgType)
+}
+
+// PingContext works as **Ping**, but stops waiting for a reply once ctx is cancelled or its
+// deadline is exceeded; ctx error is returned in such case
+func (client *Client) PingContext(ctx context.Context, msgType protocol.RadiusMsgType) (time.Duration, error) {
+  var port       uint16
+  var expectCode protocol.TypeCode
+
+  switch msgType {
+    case protocol.AUTH:
+      port, _    = client.Port(protocol.AccessRequest)
+      expectCode = protocol.AccessAccept
+    case protocol.ACCT:
+      port, _    = client.Port(protocol.AccountingRequest)
+      expectCode = protocol.AccountingResponse
+    default:
+      return 0, errors.New("Status-Server could only be sent to AUTH or ACCT port")
+  }
+
+  msgAuthBytes     := make([]uint8, 16)
+  msgAuthAttr, err := client.CreateAttributeByName("Message-Authenticator", &msgAuthBytes)
+  if err != nil {
+    return 0, err
+  }
+
+  packet := client.CreateRadiusPacket(protocol.StatusServer)
+  packet.SetAttributes([]protocol.RadiusAttribute { msgAuthAttr })
+
+  if err := packet.GenerateMessageAuthenticator(client.secret); err != nil {
+    return 0, err
+  }
+
+  started    := time.Now()
+  reply, err := client.exchange(ctx, &packet, port)
+  if err != nil {
+    return 0, err
+  }
+  rtt := time.Since(started)
+
+  if _, err := client.VerifyReply(&packet, &reply); err != nil {
+    return 0, err
+  }
+
+  if err := client.host.VerifyReplyMessageAuthenticator(client.secret, &reply, packet.Authenticator()); err != nil {
+    return 0, err
+  }
+
+  replyPacket, err := client.InitialiseRadiusPacketFromBytes(&reply)
+  if err != nil {
+    return 0, err
+  }
+
+  if replyPacket.Code() != expectCode {
+    return 0, errors.New(fmt.Sprintf("unexpected reply code %d to Status-Server", replyPacket.Code()))
+  }
+  return rtt, nil
+}
+
+// exchange sends RadiusPacket to given port of RADIUS Server and returns first reply with
+// matching identifier
+//
+// Packet is re-sent as scheduled by its transmission; cancellation of ctx stops waiting for reply
+func (client *Client) exchange(ctx context.Context, packet *protocol.RadiusPacket, port uint16) ([]uint8, error) {
+  if port == 0 {
+    return nil, errors.New("port is not set")
+  }
+
+  if err := client.addNASAttributes(packet); err != nil {
+    return nil, err
+  }
+
+  address := net.JoinHostPort(client.server, strconv.Itoa(int(port)))
+
+  if client.stream != nil {
+    return client.stream.exchange(ctx, address, client.newTransmission(packet))
+  }
+  if client.pool != nil {
+    return client.exchangePooled(ctx, packet, address)
+  }
+
+  conn, err := client.dialer.DialContext(ctx, "udp", address)
+  if err != nil {
+    if ctx.Err() != nil {
+      return nil, ctx.Err()
+    }
+    return nil, err
+  }
+  defer conn.Close()
+
+  // Closing connection unblocks pending Read once ctx is cancelled
+  done := make(chan struct{})
+  defer close(done)
+  go func() {
+    select {
+      case <-ctx.Done():
+        conn.Close()
+      case <-done:
+    }
+  }()
+
+  buffer       := make([]uint8, 4096)
+  transmission := client.newTransmission(packet)
+  reserveID    := func() (uint8, error) {
+    return client.packetIDSource().PacketID(), nil
+  }
+
+  for {
+    ok, err := transmission.next(reserveID)
+    if err != nil {
+      return nil, err
+    }
+    if !ok {
+      break
+    }
+
+    if _, err := conn.Write(transmission.bytes); err != nil {
+      if ctx.Err() != nil {
+        return nil, ctx.Err()
+      }
+      return nil, err
+    }
+
+    if err := conn.SetReadDeadline(time.Now().Add(transmission.timeout)); err != nil {
+      return nil, err
+    }
+
+    for {
+      n, err := conn.Read(buffer)
+      if err != nil {
+        if ctx.Err() != nil {
+          return nil, ctx.Err()
+        }
+
+        var netErr net.Error
+        if errors.As(err, &netErr) && netErr.Timeout() {
+          break
+        }
+        return nil, err
+      }
+
+      if n >= 20 && buffer[1] == packet.ID() {
+        reply := make([]uint8, n)
+        copy(reply, buffer[:n])
+        return reply, nil
+      }
+    }
+  }
+
+  return nil, ErrNoReply
+}
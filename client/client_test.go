@@ -1,13 +1,19 @@
 package client
 
 import (
+  "context"
   "fmt"
+  "net"
+  "sync"
+  "sync/atomic"
   "testing"
+  "time"
 
   "github.com/stretchr/testify/assert"
 
   "github.com/MikhailMS/go-radius/tools"
   "github.com/MikhailMS/go-radius/protocol"
+  "github.com/MikhailMS/go-radius/server"
 )
 
 func TestGetRadiusAttributeOriginalStringValue(t *testing.T) {
@@ -133,3 +139,216 @@ func TestVerifyReply(t *testing.T) {
   ok, _ := client.VerifyReply(&radPacket, &reply)
   assert.Equal(t, true, ok, "Valid reply is not verified!")
 }
+
+type fixedIDSource struct {
+  id            uint8
+  authenticator []uint8
+}
+
+func (source fixedIDSource) PacketID() uint8 {
+  return source.id
+}
+
+func (source fixedIDSource) PacketAuthenticator() []uint8 {
+  return source.authenticator
+}
+
+func TestCreateRadiusPacketWithPacketIDSource(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+
+  client := InitialiseClient(dictionary, "127.0.0.1", "secret", 1, 2)
+
+  authenticator := []uint8 { 152, 137, 115, 14, 56, 250, 103, 56, 57, 57, 104, 246, 226, 80, 71, 167 }
+  client.SetPacketIDSource(fixedIDSource { 220, authenticator })
+
+  radPacket := client.CreateAuthRadiusPacket()
+  assert.Equal(t, uint8(220),    radPacket.ID(),            "Radius Packet ID is not taken from source!")
+  assert.Equal(t, authenticator, radPacket.Authenticator(), "Radius Packet Authenticator is not taken from source!")
+}
+
+func TestNilPacketIDSource(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+
+  client := InitialiseClient(dictionary, "127.0.0.1", "secret", 1, 2)
+  client.SetPacketIDSource(nil)
+
+  radPacket := client.CreateAuthRadiusPacket()
+  assert.Equal(t, 16, len(radPacket.Authenticator()), "Nil source is not replaced with random source!")
+
+  // Client, that is not initialised, creates packets as well
+  var zeroClient Client
+  radPacket = zeroClient.CreateAcctRadiusPacket()
+  assert.Equal(t, 16, len(radPacket.Authenticator()), "Client without source doesn't create packets!")
+}
+
+func TestExchangeContext(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+
+  // Server never replies
+  conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer conn.Close()
+
+  client := InitialiseClient(dictionary, "127.0.0.1", "secret", 3, 5)
+  client.SetPort(protocol.AUTH, uint16(conn.LocalAddr().(*net.UDPAddr).Port))
+
+  radPacket := client.CreateAuthRadiusPacket()
+
+  ctx, cancel := context.WithTimeout(context.Background(), 100 * time.Millisecond)
+  defer cancel()
+
+  started := time.Now()
+  _, err   = client.ExchangeContext(ctx, &radPacket)
+  assert.Equal(t, context.DeadlineExceeded, err, "Deadline of context is not honoured!")
+  assert.Equal(t, true, time.Since(started) < time.Second, "Exchange is not stopped at deadline!")
+
+  cancelled, cancel := context.WithCancel(context.Background())
+  cancel()
+
+  _, err = client.ExchangeContext(cancelled, &radPacket)
+  assert.Equal(t, context.Canceled, err, "Packet is sent with cancelled context!")
+}
+
+func TestExchangeAsync(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+
+  port, requests := startTestGroupServer(t, dictionary, "127.0.0.1:0")
+  client         := createTestGroupClient(dictionary, port)
+
+  userName        := []uint8("testing")
+  userNameAttr, _ := client.CreateAttributeByName("User-Name", &userName)
+
+  // Requests are fanned out at once and their results are gathered afterwards
+  var results []<-chan ExchangeResult
+  for i := 0; i < 16; i++ {
+    radPacket := client.CreateAuthRadiusPacket()
+    radPacket.SetAttributes([]protocol.RadiusAttribute { userNameAttr })
+
+    results = append(results, client.ExchangeAsync(context.Background(), &radPacket))
+  }
+
+  for _, result := range results {
+    exchanged := <-result
+    assert.Equal(t, nil, exchanged.Err, "Asynchronous request is not answered!")
+
+    ok, _ := client.VerifyReply(exchanged.Packet, &exchanged.Reply)
+    assert.Equal(t, true, ok, "Reply to asynchronous request is not verified!")
+  }
+  assert.Equal(t, int32(16), atomic.LoadInt32(requests), "Not all asynchronous requests are received!")
+}
+
+// memoryPacket is datagram of memoryPacketConn
+type memoryPacket struct {
+  data []uint8
+  from net.Addr
+}
+
+// memoryPacketConn is in-memory net.PacketConn, that delivers datagrams to its peer
+type memoryPacketConn struct {
+  local  net.Addr
+  peer   *memoryPacketConn
+  in     chan memoryPacket
+  closed chan struct{}
+  once   sync.Once
+}
+
+func newMemoryPacketConns(first, second net.Addr) (*memoryPacketConn, *memoryPacketConn) {
+  firstConn  := &memoryPacketConn { local: first,  in: make(chan memoryPacket, 64), closed: make(chan struct{}) }
+  secondConn := &memoryPacketConn { local: second, in: make(chan memoryPacket, 64), closed: make(chan struct{}) }
+  firstConn.peer, secondConn.peer = secondConn, firstConn
+
+  return firstConn, secondConn
+}
+
+func (conn *memoryPacketConn) ReadFrom(buffer []uint8) (int, net.Addr, error) {
+  select {
+    case packet := <-conn.in:
+      return copy(buffer, packet.data), packet.from, nil
+    case <-conn.closed:
+      return 0, nil, net.ErrClosed
+  }
+}
+
+func (conn *memoryPacketConn) WriteTo(data []uint8, addr net.Addr) (int, error) {
+  packet := memoryPacket { append([]uint8{}, data...), conn.local }
+
+  // As with UDP, datagram is dropped if peer doesn't keep up
+  select {
+    case conn.peer.in <- packet:
+    default:
+  }
+  return len(data), nil
+}
+
+func (conn *memoryPacketConn) Close() error {
+  conn.once.Do(func() { close(conn.closed) })
+  return nil
+}
+
+func (conn *memoryPacketConn) LocalAddr() net.Addr                { return conn.local }
+func (conn *memoryPacketConn) SetDeadline(t time.Time) error      { return nil }
+func (conn *memoryPacketConn) SetReadDeadline(t time.Time) error  { return nil }
+func (conn *memoryPacketConn) SetWriteDeadline(t time.Time) error { return nil }
+
+func TestSetPacketConn(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+
+  clientAddr := &net.UDPAddr { IP: net.ParseIP("127.0.0.1"), Port: 50000 }
+  serverAddr := &net.UDPAddr { IP: net.ParseIP("127.0.0.1"), Port: 1812 }
+  local, remote := newMemoryPacketConns(clientAddr, serverAddr)
+
+  radServer := server.InitialiseServer(dictionary, map[string]string { "127.0.0.1": "secret" }, "127.0.0.1", 1, 2)
+  runtime   := server.InitialiseRuntime(&radServer)
+  runtime.SetHandler(protocol.AUTH, func(request *server.Request) (protocol.TypeCode, []protocol.RadiusAttribute, error) {
+    return protocol.AccessAccept, nil, nil
+  })
+  defer remote.Close()
+  go runtime.ServePacketConn(remote, protocol.AUTH)
+
+  client := InitialiseClient(dictionary, "127.0.0.1", "secret", 0, 1)
+  client.SetPort(protocol.AUTH, uint16(serverAddr.Port))
+  client.SetPacketConn(local)
+
+  result, err := client.AuthenticatePAP(context.Background(), "testing", []uint8("password"), nil)
+  assert.Equal(t, nil,  err,               "Access-Request is not sent over injected socket!")
+  assert.Equal(t, true, result.Accepted(), "Access-Request is not accepted over injected socket!")
+
+  client.Close()
+  _, _, err = local.ReadFrom(make([]uint8, 1))
+  assert.Equal(t, net.ErrClosed, err, "Injected socket is not closed with Client!")
+}
+
+// countingDialer counts sockets it opens
+type countingDialer struct {
+  net.Dialer
+  dials int32
+}
+
+func (dialer *countingDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+  atomic.AddInt32(&dialer.dials, 1)
+  return dialer.Dialer.DialContext(ctx, network, address)
+}
+
+func TestSetDialer(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+
+  port, _ := startTestGroupServer(t, dictionary, "127.0.0.1:0")
+  client  := createTestGroupClient(dictionary, port)
+
+  // Sockets are bound to specific source address
+  dialer := &countingDialer { Dialer: net.Dialer { LocalAddr: &net.UDPAddr { IP: net.ParseIP("127.0.0.1") } } }
+  client.SetDialer(dialer)
+
+  result, err := client.AuthenticatePAP(context.Background(), "testing", []uint8("password"), nil)
+  assert.Equal(t, nil,      err,                             "Access-Request is not sent with injected dialer!")
+  assert.Equal(t, true,     result.Accepted(),               "Access-Request is not accepted with injected dialer!")
+  assert.Equal(t, int32(1), atomic.LoadInt32(&dialer.dials), "Socket is not opened with injected dialer!")
+}
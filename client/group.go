@@ -0,0 +1,324 @@
+package client
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "io"
+  "net"
+  "sync"
+  "time"
+
+  "github.com/MikhailMS/go-radius/protocol"
+)
+
+// Defaults of ServerGroup, that could be changed with its setters
+const (
+  DEAD_AFTER_TIMEOUTS = 3
+  REVIVE_INTERVAL     = 30 * time.Second
+)
+
+// BalancingPolicy defines how ServerGroup chooses home server for a request
+type BalancingPolicy int
+
+const (
+  // Failover sends requests to the first live home server, using the next ones only if it fails
+  Failover BalancingPolicy = iota
+  // RoundRobin sends requests to live home servers in turns
+  RoundRobin
+  // Weighted sends requests to live home servers in proportion to their weights
+  Weighted
+)
+
+// RequestBuilder creates request for given home server, so it is encrypted & signed with its secret
+type RequestBuilder func(client *Client) (protocol.RadiusPacket, error)
+
+// homeServer is Client of single RADIUS Server within ServerGroup and its health
+type homeServer struct {
+  client        *Client
+  weight        int
+  currentWeight int
+  timeouts      int
+  dead          bool
+  probing       bool
+  nextProbe     time.Time
+}
+
+// ServerGroup sends requests to a group of home servers, each with its own secret & ports, failing
+// over to the next home server if one doesn't reply
+//
+// Home server is marked dead after number of consecutive failures and doesn't receive requests,
+// till it replies to Status-Server (RFC 5997) probe; while all home servers are dead, requests are
+// still sent to them in turn. Probes run in background, till group is closed with **Close**
+type ServerGroup struct {
+  policy         BalancingPolicy
+  now            func() time.Time
+  ctx            context.Context
+  cancel         context.CancelFunc
+
+  mutex          sync.Mutex
+  deadAfter      int
+  reviveInterval time.Duration
+  servers        []*homeServer
+  next           int
+  probes         sync.WaitGroup
+}
+
+// InitialiseServerGroup initialises empty ServerGroup with given balancing policy
+//
+// Please note that you would need to call **AddServer** for each home server
+func InitialiseServerGroup(policy BalancingPolicy) *ServerGroup {
+  ctx, cancel := context.WithCancel(context.Background())
+
+  return &ServerGroup {
+    policy:         policy,
+    now:            time.Now,
+    ctx:            ctx,
+    cancel:         cancel,
+    deadAfter:      DEAD_AFTER_TIMEOUTS,
+    reviveInterval: REVIVE_INTERVAL,
+  }
+}
+
+// AddServer adds home server, that is reached with given Client; weight is only used by Weighted
+// policy and should be positive
+func (group *ServerGroup) AddServer(client *Client, weight int) {
+  group.mutex.Lock()
+  defer group.mutex.Unlock()
+
+  group.servers = append(group.servers, &homeServer { client: client, weight: weight })
+}
+
+// **Optional**
+//
+// SetDeadServerDetection sets number of consecutive failures after which home server is marked
+// dead, and interval between Status-Server probes of dead home server
+func (group *ServerGroup) SetDeadServerDetection(deadAfter int, reviveInterval time.Duration) {
+  group.mutex.Lock()
+  defer group.mutex.Unlock()
+
+  group.deadAfter      = deadAfter
+  group.reviveInterval = reviveInterval
+}
+
+// Close stops Status-Server probes of dead home servers and waits for running ones to return;
+// Clients of home servers are not closed
+func (group *ServerGroup) Close() error {
+  // Probes are started with group mutex held, so none is started once context is cancelled
+  group.mutex.Lock()
+  group.cancel()
+  group.mutex.Unlock()
+
+  group.probes.Wait()
+  return nil
+}
+
+// IsAlive reports if home server, that is reached with given Client, is alive
+func (group *ServerGroup) IsAlive(client *Client) bool {
+  group.mutex.Lock()
+  defer group.mutex.Unlock()
+
+  for _, server := range group.servers {
+    if server.client == client {
+      return !server.dead
+    }
+  }
+  return false
+}
+
+// Exchange sends request built by builder to home server chosen by the policy and returns Client
+// of home server, that replied, and verified reply
+//
+// If home server doesn't reply, request is built again for the next home server
+func (group *ServerGroup) Exchange(ctx context.Context, builder RequestBuilder) (*Client, protocol.RadiusPacket, error) {
+  var reply protocol.RadiusPacket
+
+  client, err := group.do(ctx, func(client *Client) error {
+    request, err := builder(client)
+    if err != nil {
+      return err
+    }
+
+    port, ok := client.Port(request.Code())
+    if !ok {
+      return errors.New(fmt.Sprintf("no port is set for packet with code %d", request.Code()))
+    }
+
+    replyBytes, err := client.exchange(ctx, &request, port)
+    if err != nil {
+      return err
+    }
+
+    if ok, err := client.VerifyReply(&request, &replyBytes); !ok {
+      return err
+    }
+
+    reply, err = client.InitialiseRadiusPacketFromBytes(&replyBytes)
+    return err
+  })
+
+  return client, reply, err
+}
+
+// AuthenticatePAP sends PAP Access-Request (see **Client.AuthenticatePAP**) to home server chosen
+// by the policy, failing over to the next home server if one doesn't reply
+func (group *ServerGroup) AuthenticatePAP(ctx context.Context, username string, password []uint8, extraAttrs []protocol.RadiusAttribute) (AuthenticationResult, error) {
+  var result AuthenticationResult
+
+  _, err := group.do(ctx, func(client *Client) error {
+    var err error
+    result, err = client.AuthenticatePAP(ctx, username, password, extraAttrs)
+    return err
+  })
+
+  return result, err
+}
+
+// do calls send for home servers in order chosen by the policy, until one of them replies
+func (group *ServerGroup) do(ctx context.Context, send func(client *Client) error) (*Client, error) {
+  candidates := group.candidates()
+  if len(candidates) == 0 {
+    return nil, errors.New("no home servers in the group")
+  }
+
+  var lastErr error
+  for _, server := range candidates {
+    err := send(server.client)
+    if err == nil {
+      group.markAlive(server)
+      return server.client, nil
+    }
+
+    if ctx.Err() != nil || !isServerFailure(err) {
+      return nil, err
+    }
+
+    group.markFailure(server)
+    lastErr = err
+  }
+
+  return nil, lastErr
+}
+
+// candidates returns home servers in order they should be tried: live ones chosen by the policy
+// first, followed by dead ones
+func (group *ServerGroup) candidates() []*homeServer {
+  group.mutex.Lock()
+  defer group.mutex.Unlock()
+
+  var live, dead []*homeServer
+  for _, server := range group.servers {
+    if server.dead {
+      group.probe(server)
+      dead = append(dead, server)
+    } else {
+      live = append(live, server)
+    }
+  }
+
+  if len(live) > 0 {
+    switch group.policy {
+      case RoundRobin:
+        start     := group.next % len(live)
+        live       = append(live[start:], live[:start]...)
+        group.next = start + 1
+      case Weighted:
+        first := selectWeighted(live)
+        for i, server := range live {
+          if server == first {
+            live = append([]*homeServer { first }, append(live[:i:i], live[i+1:]...)...)
+            break
+          }
+        }
+    }
+  }
+
+  return append(live, dead...)
+}
+
+// selectWeighted chooses home server with smooth weighted round-robin, so home servers receive
+// requests in proportion to their weights without bursts
+func selectWeighted(servers []*homeServer) *homeServer {
+  var selected *homeServer
+  total := 0
+
+  for _, server := range servers {
+    weight := server.weight
+    if weight < 1 {
+      weight = 1
+    }
+
+    server.currentWeight += weight
+    total                += weight
+
+    if selected == nil || server.currentWeight > selected.currentWeight {
+      selected = server
+    }
+  }
+
+  selected.currentWeight -= total
+  return selected
+}
+
+// markAlive resets failures of home server
+func (group *ServerGroup) markAlive(server *homeServer) {
+  group.mutex.Lock()
+  defer group.mutex.Unlock()
+
+  server.timeouts = 0
+  server.dead     = false
+}
+
+// markFailure counts failure of home server and marks it dead, once limit is reached
+func (group *ServerGroup) markFailure(server *homeServer) {
+  group.mutex.Lock()
+  defer group.mutex.Unlock()
+
+  server.timeouts++
+  if !server.dead && server.timeouts >= group.deadAfter {
+    server.dead      = true
+    server.nextProbe = group.now().Add(group.reviveInterval)
+  }
+}
+
+// probe sends Status-Server to dead home server in background, if it is time to; home server is
+// marked alive once it replies
+//
+// Should be called with group mutex held
+func (group *ServerGroup) probe(server *homeServer) {
+  if server.probing || group.ctx.Err() != nil || group.now().Before(server.nextProbe) {
+    return
+  }
+  server.probing = true
+  group.probes.Add(1)
+
+  go func() {
+    defer group.probes.Done()
+
+    msgType := protocol.AUTH
+    if port, _ := server.client.Port(protocol.AccessRequest); port == 0 {
+      msgType = protocol.ACCT
+    }
+    _, err := server.client.PingContext(group.ctx, msgType)
+
+    group.mutex.Lock()
+    defer group.mutex.Unlock()
+
+    server.probing = false
+    if err == nil {
+      server.timeouts = 0
+      server.dead     = false
+    } else {
+      server.nextProbe = group.now().Add(group.reviveInterval)
+    }
+  }()
+}
+
+// isServerFailure reports if error means that home server is unreachable, rather than request is
+// invalid or reply is not verified; for stream transport that includes connection, which is closed
+// by home server
+func isServerFailure(err error) bool {
+  var netErr net.Error
+  return errors.Is(err, ErrNoReply) || errors.As(err, &netErr) ||
+    errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) || errors.Is(err, errIdle)
+}
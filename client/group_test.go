@@ -0,0 +1,208 @@
+package client
+
+import (
+  "context"
+  "net"
+  "strconv"
+  "sync/atomic"
+  "testing"
+  "time"
+
+  "github.com/stretchr/testify/assert"
+
+  "github.com/MikhailMS/go-radius/protocol"
+  "github.com/MikhailMS/go-radius/server"
+)
+
+// startTestGroupServer starts RADIUS Server on given UDP address, that accepts all Access-Requests
+// and counts them
+func startTestGroupServer(t *testing.T, dictionary protocol.Dictionary, address string) (uint16, *int32) {
+  var requests int32
+
+  radServer := server.InitialiseServer(dictionary, map[string]string { "127.0.0.1": "secret" }, "127.0.0.1", 1, 2)
+  runtime   := server.InitialiseRuntime(&radServer)
+  runtime.SetHandler(protocol.AUTH, func(request *server.Request) (protocol.TypeCode, []protocol.RadiusAttribute, error) {
+    atomic.AddInt32(&requests, 1)
+    return protocol.AccessAccept, nil, nil
+  })
+
+  conn, err := net.ListenPacket("udp", address)
+  if err != nil {
+    t.Fatal(err)
+  }
+  t.Cleanup(func() { conn.Close() })
+  go runtime.ServePacketConn(conn, protocol.AUTH)
+
+  return uint16(conn.LocalAddr().(*net.UDPAddr).Port), &requests
+}
+
+// createTestGroupClient creates Client for home server, listening on given port
+func createTestGroupClient(dictionary protocol.Dictionary, port uint16) *Client {
+  client := InitialiseClient(dictionary, "127.0.0.1", "secret", 0, 1)
+  client.SetPort(protocol.AUTH, port)
+  return &client
+}
+
+func TestServerGroupFailover(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+
+  // Port of the first home server is closed, so it refuses requests
+  conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+  if err != nil {
+    t.Fatal(err)
+  }
+  deadPort := uint16(conn.LocalAddr().(*net.UDPAddr).Port)
+  conn.Close()
+
+  livePort, liveRequests := startTestGroupServer(t, dictionary, "127.0.0.1:0")
+
+  deadClient := createTestGroupClient(dictionary, deadPort)
+  liveClient := createTestGroupClient(dictionary, livePort)
+
+  group := InitialiseServerGroup(Failover)
+  group.AddServer(deadClient, 1)
+  group.AddServer(liveClient, 1)
+  group.SetDeadServerDetection(2, time.Hour)
+  defer group.Close()
+
+  for i := 0; i < 3; i++ {
+    result, err := group.AuthenticatePAP(context.Background(), "testing", []uint8("password"), nil)
+    assert.Equal(t, nil,  err,               "Access-Request is not failed over to live home server!")
+    assert.Equal(t, true, result.Accepted(), "Access-Request is not accepted by live home server!")
+  }
+  assert.Equal(t, int32(3), atomic.LoadInt32(liveRequests), "Live home server hasn't received all requests!")
+  assert.Equal(t, false,    group.IsAlive(deadClient),      "Home server is not marked dead after consecutive failures!")
+  assert.Equal(t, true,     group.IsAlive(liveClient),      "Live home server is marked dead!")
+
+  // Once home server is back, it is revived by Status-Server probe
+  group.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+  startTestGroupServer(t, dictionary, net.JoinHostPort("127.0.0.1", strconv.Itoa(int(deadPort))))
+
+  _, err = group.AuthenticatePAP(context.Background(), "testing", []uint8("password"), nil)
+  assert.Equal(t, nil, err, "Access-Request is not answered while home server is probed!")
+  assert.Eventually(t, func() bool { return group.IsAlive(deadClient) }, 2 * time.Second, 10 * time.Millisecond, "Home server is not revived by Status-Server!")
+
+  // Invalid request is not failed over to the next home server
+  client, _, err := group.Exchange(context.Background(), func(client *Client) (protocol.RadiusPacket, error) {
+    return client.CreateRadiusPacket(protocol.AccessAccept), nil
+  })
+  assert.NotEqual(t, nil, err, "Request without port is sent!")
+  assert.Equal(t, (*Client)(nil), client, "Request without port is failed over!")
+}
+
+func TestServerGroupStreamFailover(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+
+  // The first home server closes every connection, so replies are never read
+  closing, err := net.Listen("tcp", "127.0.0.1:0")
+  if err != nil {
+    t.Fatal(err)
+  }
+  t.Cleanup(func() { closing.Close() })
+  go func() {
+    for {
+      conn, err := closing.Accept()
+      if err != nil {
+        return
+      }
+      conn.Close()
+    }
+  }()
+
+  radServer := server.InitialiseServer(dictionary, map[string]string { "127.0.0.1": "secret" }, "127.0.0.1", 1, 2)
+  runtime   := server.InitialiseRuntime(&radServer)
+  runtime.SetHandler(protocol.AUTH, func(request *server.Request) (protocol.TypeCode, []protocol.RadiusAttribute, error) {
+    return protocol.AccessAccept, nil, nil
+  })
+
+  live, err := net.Listen("tcp", "127.0.0.1:0")
+  if err != nil {
+    t.Fatal(err)
+  }
+  t.Cleanup(func() { live.Close() })
+  go runtime.ServeTCP(live, protocol.AUTH)
+
+  closingClient := InitialiseTCPClient(dictionary, "127.0.0.1", "secret", 1)
+  closingClient.SetPort(protocol.AUTH, uint16(closing.Addr().(*net.TCPAddr).Port))
+  liveClient    := InitialiseTCPClient(dictionary, "127.0.0.1", "secret", 1)
+  liveClient.SetPort(protocol.AUTH, uint16(live.Addr().(*net.TCPAddr).Port))
+
+  group := InitialiseServerGroup(Failover)
+  group.AddServer(&closingClient, 1)
+  group.AddServer(&liveClient, 1)
+  group.SetDeadServerDetection(1, time.Hour)
+  defer group.Close()
+
+  result, err := group.AuthenticatePAP(context.Background(), "testing", []uint8("password"), nil)
+  assert.Equal(t, nil,   err,                          "Access-Request is not failed over, when connection is closed!")
+  assert.Equal(t, true,  result.Accepted(),            "Access-Request is not accepted by live home server!")
+  assert.Equal(t, false, group.IsAlive(&closingClient), "Home server, that closes connection, is not marked dead!")
+}
+
+func TestServerGroupCloseStopsProbes(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+
+  // Home server never replies, so probe would wait for the whole timeout
+  conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer conn.Close()
+
+  client := InitialiseClient(dictionary, "127.0.0.1", "secret", 0, 10)
+  client.SetPort(protocol.AUTH, uint16(conn.LocalAddr().(*net.UDPAddr).Port))
+
+  group := InitialiseServerGroup(Failover)
+  group.AddServer(&client, 1)
+  group.SetDeadServerDetection(1, 0)
+
+  group.markFailure(group.servers[0])
+  group.candidates()
+
+  started := time.Now()
+  group.Close()
+  assert.Equal(t, true, time.Since(started) < 5 * time.Second, "Probe is not stopped, when group is closed!")
+
+  group.candidates()
+  assert.Equal(t, false, group.servers[0].probing, "Probe is started after group is closed!")
+}
+
+func TestServerGroupBalancing(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+
+  tests := []struct {
+    name     string
+    policy   BalancingPolicy
+    weights  []int
+    requests int
+    expected []int32
+  }{
+    { "failover",    Failover,   []int { 1, 1 }, 4, []int32 { 4, 0 } },
+    { "round robin", RoundRobin, []int { 1, 1 }, 4, []int32 { 2, 2 } },
+    { "weighted",    Weighted,   []int { 3, 1 }, 8, []int32 { 6, 2 } },
+  }
+
+  for _, test := range tests {
+    group    := InitialiseServerGroup(test.policy)
+    counters := make([]*int32, len(test.weights))
+
+    for i, weight := range test.weights {
+      port, requests := startTestGroupServer(t, dictionary, "127.0.0.1:0")
+      counters[i]     = requests
+      group.AddServer(createTestGroupClient(dictionary, port), weight)
+    }
+
+    for i := 0; i < test.requests; i++ {
+      _, err := group.AuthenticatePAP(context.Background(), "testing", []uint8("password"), nil)
+      assert.Equal(t, nil, err, "Access-Request is not answered ("+test.name+")!")
+    }
+
+    for i, expected := range test.expected {
+      assert.Equal(t, expected, atomic.LoadInt32(counters[i]), "Requests are not balanced ("+test.name+")!")
+    }
+  }
+}
@@ -0,0 +1,120 @@
+package client
+
+import (
+  "errors"
+  "net"
+
+  "github.com/MikhailMS/go-radius/protocol"
+)
+
+// IDs of attributes, that identify NAS to RADIUS Server
+const (
+  nasIPAddressID   uint8 = 4
+  nasIdentifierID  uint8 = 32
+  nasIPv6AddressID uint8 = 95
+)
+
+// **Optional**
+//
+// SetSourceAddress binds UDP sockets, that requests are sent from, to given local IP address, so
+// RADIUS Server, that identifies clients by source IP, sees the same address on multi-homed host
+//
+// Please note that it should be called before **SetSocketPool** and **SetPacketConn**; dialer set
+// by **SetDialer** keeps its settings, but should be *net.Dialer. Source address is not applied to
+// RadSec/TCP/DTLS connections, so error is returned for such Client
+func (client *Client) SetSourceAddress(ip net.IP) error {
+  if client.stream != nil {
+    return errors.New("source address is only applied to UDP sockets")
+  }
+  if client.pool != nil {
+    return errors.New("source address should be set before socket pool or PacketConn")
+  }
+
+  dialer, ok := client.dialer.(*net.Dialer)
+  if !ok {
+    return errors.New("source address could only be set on *net.Dialer")
+  }
+
+  bound          := *dialer
+  bound.LocalAddr = &net.UDPAddr { IP: ip }
+
+  client.source = ip
+  client.dialer = &bound
+  return nil
+}
+
+// **Optional**
+//
+// SetNASAttributes adds NAS-IP-Address or NAS-IPv6-Address, matching source address (see
+// **SetSourceAddress**), and NAS-Identifier, if identifier is not empty, to Access-Requests and
+// Accounting-Requests, that don't have them set already
+//
+// Request is signed again once attributes are added. NAS-IP-Address & NAS-IPv6-Address are only
+// added, when source address is set, that is for UDP; RadSec/TCP/DTLS requests get NAS-Identifier
+// only
+func (client *Client) SetNASAttributes(identifier string) {
+  client.nas   = true
+  client.nasID = identifier
+}
+
+// addNASAttributes adds NAS attributes, that are missing, to request; NAS address is taken from
+// source address of UDP sockets, so it is omitted, if source address is not set
+func (client *Client) addNASAttributes(packet *protocol.RadiusPacket) error {
+  if !client.nas {
+    return nil
+  }
+
+  switch packet.Code() {
+    case protocol.AccessRequest, protocol.AccountingRequest:
+    default:
+      return nil
+  }
+
+  var missing []protocol.RadiusAttribute
+
+  if ipv4 := client.source.To4(); ipv4 != nil {
+    if !hasAttribute(packet, nasIPAddressID) {
+      value := []uint8(ipv4)
+      attr, err := client.CreateAttributeByID(nasIPAddressID, &value)
+      if err != nil {
+        return err
+      }
+      missing = append(missing, attr)
+    }
+  } else if ipv6 := client.source.To16(); ipv6 != nil {
+    if !hasAttribute(packet, nasIPv6AddressID) {
+      value := []uint8(ipv6)
+      attr, err := client.CreateAttributeByID(nasIPv6AddressID, &value)
+      if err != nil {
+        return err
+      }
+      missing = append(missing, attr)
+    }
+  }
+
+  if client.nasID != "" && !hasAttribute(packet, nasIdentifierID) {
+    value     := []uint8(client.nasID)
+    attr, err := client.CreateAttributeByID(nasIdentifierID, &value)
+    if err != nil {
+      return err
+    }
+    missing = append(missing, attr)
+  }
+
+  if len(missing) == 0 {
+    return nil
+  }
+
+  packet.SetAttributes(append(packet.Attributes(), missing...))
+  return client.signRequest(packet)
+}
+
+// hasAttribute reports if packet has attribute with given ID
+func hasAttribute(packet *protocol.RadiusPacket, attrID uint8) bool {
+  for _, attr := range packet.Attributes() {
+    if attr.ID() == attrID {
+      return true
+    }
+  }
+  return false
+}
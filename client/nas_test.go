@@ -0,0 +1,147 @@
+package client
+
+import (
+  "context"
+  "net"
+  "sync"
+  "testing"
+  "time"
+
+  "github.com/stretchr/testify/assert"
+
+  "github.com/MikhailMS/go-radius/protocol"
+  "github.com/MikhailMS/go-radius/server"
+)
+
+func TestSourceAddressAndNASAttributes(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+
+  // Server only allows requests from source address Client is bound to
+  var mutex    sync.Mutex
+  var received []protocol.RadiusPacket
+
+  record := func(request *server.Request) {
+    mutex.Lock()
+    defer mutex.Unlock()
+
+    received = append(received, *request.Packet())
+  }
+
+  radServer := server.InitialiseServer(dictionary, map[string]string { "127.0.0.2": "secret" }, "127.0.0.1", 1, 2)
+  runtime   := server.InitialiseRuntime(&radServer)
+  runtime.SetHandler(protocol.AUTH, func(request *server.Request) (protocol.TypeCode, []protocol.RadiusAttribute, error) {
+    record(request)
+    return protocol.AccessAccept, nil, nil
+  })
+  runtime.SetHandler(protocol.ACCT, func(request *server.Request) (protocol.TypeCode, []protocol.RadiusAttribute, error) {
+    record(request)
+    return protocol.AccountingResponse, nil, nil
+  })
+
+  ports := make(map[protocol.RadiusMsgType]uint16)
+  for _, msgType := range []protocol.RadiusMsgType { protocol.AUTH, protocol.ACCT } {
+    conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+    if err != nil {
+      t.Fatal(err)
+    }
+    t.Cleanup(func() { conn.Close() })
+    go runtime.ServePacketConn(conn, msgType)
+    ports[msgType] = uint16(conn.LocalAddr().(*net.UDPAddr).Port)
+  }
+
+  for _, pooled := range []bool { false, true } {
+    client := InitialiseClient(dictionary, "127.0.0.1", "secret", 0, 1)
+    client.SetPort(protocol.AUTH, ports[protocol.AUTH])
+    client.SetPort(protocol.ACCT, ports[protocol.ACCT])
+    assert.Equal(t, nil, client.SetSourceAddress(net.ParseIP("127.0.0.2")), "Source address is not set!")
+    client.SetNASAttributes("nas-1")
+    if pooled {
+      assert.Equal(t, nil, client.SetSocketPool(1), "Socket pool is not opened!")
+    }
+
+    mutex.Lock()
+    received = nil
+    mutex.Unlock()
+
+    result, err := client.AuthenticatePAP(context.Background(), "testing", []uint8("password"), nil)
+    assert.Equal(t, nil,  err,               "Access-Request is not sent from source address!")
+    assert.Equal(t, true, result.Accepted(), "Access-Request is not accepted from source address!")
+
+    // NAS-Identifier, set by caller, is kept; Request Authenticator is generated after NAS-IP-Address is added
+    nasIdentifier        := []uint8("nas-2")
+    nasIdentifierAttr, _ := client.CreateAttributeByID(nasIdentifierID, &nasIdentifier)
+
+    request := client.CreateAcctRadiusPacket()
+    request.SetAttributes([]protocol.RadiusAttribute { nasIdentifierAttr })
+    request.GenerateRequestAuthenticator(client.Secret())
+
+    reply, err := client.SendAndReceivePacket(&request)
+    assert.Equal(t, nil, err, "Accounting-Request is not sent from source address!")
+    ok, _ := client.VerifyReply(&request, &reply)
+    assert.Equal(t, true, ok, "Accounting-Response is not verified!")
+
+    mutex.Lock()
+    packets := received
+    mutex.Unlock()
+
+    assert.Equal(t, 2, len(packets), "Requests are not received!")
+    if len(packets) == 2 {
+      for _, packet := range packets {
+        nasIPAddress := packet.AttributeByID(nasIPAddressID)
+        assert.Equal(t, []uint8 { 127, 0, 0, 2 }, nasIPAddress.Value(), "NAS-IP-Address doesn't match source address!")
+      }
+
+      authIdentifier := packets[0].AttributeByID(nasIdentifierID)
+      acctIdentifier := packets[1].AttributeByID(nasIdentifierID)
+      assert.Equal(t, []uint8("nas-1"), authIdentifier.Value(), "NAS-Identifier is not added!")
+      assert.Equal(t, []uint8("nas-2"), acctIdentifier.Value(), "NAS-Identifier set by caller is overridden!")
+    }
+
+    client.Close()
+  }
+}
+
+func TestNASIPv6Address(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+
+  client := InitialiseClient(dictionary, "::1", "secret", 0, 1)
+  assert.Equal(t, nil, client.SetSourceAddress(net.ParseIP("::1")), "Source address is not set!")
+  client.SetNASAttributes("")
+
+  request := client.CreateAuthRadiusPacket()
+  assert.Equal(t, nil, client.addNASAttributes(&request), "NAS attributes are not added!")
+
+  nasIPv6Address := request.AttributeByID(nasIPv6AddressID)
+  assert.Equal(t, []uint8(net.ParseIP("::1")), nasIPv6Address.Value(), "NAS-IPv6-Address doesn't match source address!")
+  assert.Equal(t, false, hasAttribute(&request, nasIPAddressID),  "NAS-IP-Address is added for IPv6 source address!")
+  assert.Equal(t, false, hasAttribute(&request, nasIdentifierID), "Empty NAS-Identifier is added!")
+
+  // Status-Server is left as it is
+  status := client.CreateRadiusPacket(protocol.StatusServer)
+  client.addNASAttributes(&status)
+  assert.Equal(t, 0, len(status.Attributes()), "NAS attributes are added to Status-Server!")
+}
+
+func TestSourceAddressOrder(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+
+  // Settings of dialer are kept
+  client := InitialiseClient(dictionary, "127.0.0.1", "secret", 0, 1)
+  client.SetDialer(&net.Dialer { Timeout: time.Second })
+  assert.Equal(t, nil, client.SetSourceAddress(net.ParseIP("127.0.0.2")), "Source address is not set!")
+
+  dialer := client.dialer.(*net.Dialer)
+  assert.Equal(t, time.Second,                                   dialer.Timeout,   "Settings of dialer are replaced!")
+  assert.Equal(t, &net.UDPAddr { IP: net.ParseIP("127.0.0.2") }, dialer.LocalAddr, "Dialer is not bound to source address!")
+
+  // Pool is already bound to its address
+  assert.Equal(t, nil,    client.SetSocketPool(1),                            "Socket pool is not opened!")
+  assert.NotEqual(t, nil, client.SetSourceAddress(net.ParseIP("127.0.0.3")), "Source address is set after socket pool!")
+  client.Close()
+
+  tcpClient := InitialiseTCPClient(dictionary, "127.0.0.1", "secret", 1)
+  assert.NotEqual(t, nil, tcpClient.SetSourceAddress(net.ParseIP("127.0.0.2")), "Source address is set for TCP!")
+}
@@ -0,0 +1,132 @@
+package client
+
+import (
+  "context"
+  "errors"
+  "fmt"
+
+  "github.com/MikhailMS/go-radius/protocol"
+  "github.com/MikhailMS/go-radius/tools"
+)
+
+// MAX_PASSWORD_LENGTH is the maximum length of User-Password (RFC 2865, section 5.2)
+const MAX_PASSWORD_LENGTH = 128
+
+// AuthenticationStatus is outcome of Access-Request
+type AuthenticationStatus int
+
+const (
+  Accepted AuthenticationStatus = iota
+  Rejected
+  Challenged
+)
+
+// AuthenticationResult is verified reply to Access-Request
+type AuthenticationResult struct {
+  status AuthenticationStatus
+  reply  protocol.RadiusPacket
+}
+
+// Status returns outcome of Access-Request
+func (result *AuthenticationResult) Status() AuthenticationStatus {
+  return result.status
+}
+
+// Accepted reports if Access-Accept is received
+func (result *AuthenticationResult) Accepted() bool {
+  return result.status == Accepted
+}
+
+// ReplyMessage returns text of Reply-Message attributes, which usually explains rejection or
+// what is requested by Access-Challenge
+func (result *AuthenticationResult) ReplyMessage() string {
+  return replyMessage(&result.reply)
+}
+
+// State returns value of State attribute of Access-Challenge, that has to be copied into the
+// next Access-Request
+func (result *AuthenticationResult) State() []uint8 {
+  state := result.reply.AttributeByID(stateID)
+  return state.Value()
+}
+
+// Reply returns reply packet
+func (result *AuthenticationResult) Reply() protocol.RadiusPacket {
+  return result.reply
+}
+
+// AuthenticatePAP sends Access-Request with User-Name, User-Password encrypted with the secret,
+// extraAttrs & Message-Authenticator, and returns verified reply
+//
+// Cancellation of ctx stops waiting for reply. Access-Challenge is returned as Challenged result
+// and is not answered: its State and Reply-Message are available from the result. When challenges
+// are expected, build Access-Request and send it with **Converse** instead, which answers them
+func (client *Client) AuthenticatePAP(ctx context.Context, username string, password []uint8, extraAttrs []protocol.RadiusAttribute) (AuthenticationResult, error) {
+  if len(password) > MAX_PASSWORD_LENGTH {
+    return AuthenticationResult{}, errors.New(fmt.Sprintf("password is longer than %d octets", MAX_PASSWORD_LENGTH))
+  }
+
+  request       := client.CreateAuthRadiusPacket()
+  authenticator := request.Authenticator()
+  secret        := []uint8(client.secret)
+  userName      := []uint8(username)
+  encrypted     := tools.EncryptData(&password, &authenticator, &secret)
+  msgAuthBytes  := make([]uint8, 16)
+
+  userNameAttr, err := client.CreateAttributeByID(userNameID, &userName)
+  if err != nil {
+    return AuthenticationResult{}, err
+  }
+  passwordAttr, err := client.CreateAttributeByID(userPasswordID, &encrypted)
+  if err != nil {
+    return AuthenticationResult{}, err
+  }
+  msgAuthAttr, err := client.CreateAttributeByID(protocol.MESSAGE_AUTHENTICATOR_ID, &msgAuthBytes)
+  if err != nil {
+    return AuthenticationResult{}, err
+  }
+
+  // Message-Authenticator among extraAttrs is skipped, so request has only one
+  attributes := []protocol.RadiusAttribute { userNameAttr, passwordAttr }
+  for _, attr := range extraAttrs {
+    if attr.ID() != protocol.MESSAGE_AUTHENTICATOR_ID {
+      attributes = append(attributes, attr)
+    }
+  }
+  attributes = append(attributes, msgAuthAttr)
+  request.SetAttributes(attributes)
+
+  if err := request.GenerateMessageAuthenticator(client.secret); err != nil {
+    return AuthenticationResult{}, err
+  }
+
+  port, ok := client.Port(request.Code())
+  if !ok {
+    return AuthenticationResult{}, errors.New(fmt.Sprintf("no port is set for packet with code %d", request.Code()))
+  }
+
+  replyBytes, err := client.exchange(ctx, &request, port)
+  if err != nil {
+    return AuthenticationResult{}, err
+  }
+
+  if ok, err := client.VerifyReply(&request, &replyBytes); !ok {
+    return AuthenticationResult{}, err
+  }
+
+  reply, err := client.InitialiseRadiusPacketFromBytes(&replyBytes)
+  if err != nil {
+    return AuthenticationResult{}, err
+  }
+
+  switch reply.Code() {
+    case protocol.AccessAccept:
+      return AuthenticationResult { Accepted, reply }, nil
+    case protocol.AccessReject:
+      return AuthenticationResult { Rejected, reply }, nil
+    case protocol.AccessChallenge:
+      return AuthenticationResult { Challenged, reply }, nil
+    default:
+      return AuthenticationResult{}, errors.New(fmt.Sprintf("unexpected reply code %d to Access-Request", reply.Code()))
+  }
+}
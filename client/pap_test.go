@@ -0,0 +1,59 @@
+package client
+
+import (
+  "context"
+  "testing"
+
+  "github.com/stretchr/testify/assert"
+
+  "github.com/MikhailMS/go-radius/protocol"
+)
+
+func TestAuthenticatePAP(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+
+  client := InitialiseClient(dictionary, "127.0.0.1", "secret", 1, 2)
+  client.SetPort(protocol.AUTH, startTestChallengeServer(t, dictionary))
+
+  nasID        := []uint8("trillian")
+  nasIDAttr, _ := client.CreateAttributeByName("NAS-Identifier", &nasID)
+
+  // Message-Authenticator among extra attributes is replaced with the one of the request
+  msgAuthBytes   := make([]uint8, 16)
+  msgAuthAttr, _ := client.CreateAttributeByName("Message-Authenticator", &msgAuthBytes)
+
+  for _, testCase := range []struct {
+    password       string
+    expectedStatus AuthenticationStatus
+    expectedMsg    string
+    expectedState  []uint8
+  } {
+    { "no-otp",   Accepted,   "",               nil },
+    { "password", Challenged, "Enter OTP",      []uint8("otp-state") },
+    { "wrong",    Rejected,   "Wrong password", nil },
+  } {
+    result, err := client.AuthenticatePAP(context.Background(), "testing", []uint8(testCase.password), []protocol.RadiusAttribute { nasIDAttr, msgAuthAttr })
+    assert.Equal(t, nil,                     err,                   "PAP authentication failed!")
+    assert.Equal(t, testCase.expectedStatus, result.Status(),       "PAP authentication status is not correct!")
+    assert.Equal(t, testCase.expectedMsg,    result.ReplyMessage(), "Reply-Message is not correct!")
+    assert.Equal(t, testCase.expectedState,  result.State(),        "State is not correct!")
+  }
+}
+
+func TestAuthenticatePAPErrors(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+
+  client := InitialiseClient(dictionary, "127.0.0.1", "secret", 1, 2)
+  client.SetPort(protocol.AUTH, startTestChallengeServer(t, dictionary))
+
+  _, err := client.AuthenticatePAP(context.Background(), "testing", make([]uint8, 129), nil)
+  assert.Equal(t, "password is longer than 128 octets", err.Error(), "Too long password is sent!")
+
+  ctx, cancel := context.WithCancel(context.Background())
+  cancel()
+
+  _, err = client.AuthenticatePAP(ctx, "testing", []uint8("password"), nil)
+  assert.Equal(t, context.Canceled, err, "Cancelled context is ignored!")
+}
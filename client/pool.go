@@ -0,0 +1,303 @@
+package client
+
+import (
+  "context"
+  "errors"
+  "net"
+  "sync"
+  "time"
+
+  "github.com/MikhailMS/go-radius/protocol"
+)
+
+// IDS_PER_SOCKET is the number of packet identifiers, that could be outstanding on single socket
+const IDS_PER_SOCKET = 256
+
+// socketPool keeps UDP sockets, over which many requests could be outstanding at the same time
+//
+// Every request holds identifier on one of the sockets until it is answered or times out; replies
+// are matched by socket, identifier and Response Authenticator, so late or forged replies are
+// dropped
+type socketPool struct {
+  sockets []*poolSocket
+  slots   chan struct{}
+  done    chan struct{}
+  once    sync.Once
+
+  mutex   sync.Mutex
+  next    int
+}
+
+// poolSocket is UDP socket of socketPool and requests outstanding on it
+type poolSocket struct {
+  conn    net.PacketConn
+  done    <-chan struct{}
+
+  mutex   sync.Mutex
+  nextID  uint8
+  pending map[uint8]*poolRequest
+}
+
+// poolRequest is request, that waits for reply
+//
+// Authenticator is set once request is signed with reserved identifier; till then replies are not
+// matched
+type poolRequest struct {
+  remoteAddr    string
+  authenticator []uint8
+  verify        func(reply *[]uint8, authenticator []uint8) bool
+  reply         chan []uint8
+}
+
+// newSocketPool opens given number of UDP sockets, bound to given source address, if it is set
+func newSocketPool(size int, source net.IP) (*socketPool, error) {
+  if size < 1 {
+    return nil, errors.New("socket pool should have at least one socket")
+  }
+
+  localAddr := ":0"
+  if source != nil {
+    localAddr = net.JoinHostPort(source.String(), "0")
+  }
+
+  var conns []net.PacketConn
+  for i := 0; i < size; i++ {
+    conn, err := net.ListenPacket("udp", localAddr)
+    if err != nil {
+      for _, conn := range conns {
+        conn.Close()
+      }
+      return nil, err
+    }
+    conns = append(conns, conn)
+  }
+
+  return newPacketConnPool(conns), nil
+}
+
+// newPacketConnPool initialises socket pool over given sockets, that are closed with the pool
+func newPacketConnPool(conns []net.PacketConn) *socketPool {
+  pool := &socketPool { slots: make(chan struct{}, len(conns) * IDS_PER_SOCKET), done: make(chan struct{}) }
+
+  for _, conn := range conns {
+    socket := &poolSocket { conn: conn, done: pool.done, pending: make(map[uint8]*poolRequest) }
+    go socket.readReplies()
+
+    pool.sockets = append(pool.sockets, socket)
+  }
+
+  for i := 0; i < cap(pool.slots); i++ {
+    pool.slots <- struct{}{}
+  }
+  return pool
+}
+
+// acquire reserves free identifier on one of the sockets, waiting for it if all identifiers are in
+// use
+func (pool *socketPool) acquire(ctx context.Context, request *poolRequest) (*poolSocket, uint8, error) {
+  select {
+    case <-pool.slots:
+    case <-ctx.Done():
+      return nil, 0, ctx.Err()
+  }
+
+  pool.mutex.Lock()
+  defer pool.mutex.Unlock()
+
+  // Sockets are tried in turns, so identifiers are spread over all of them
+  for i := 0; i < len(pool.sockets); i++ {
+    socket := pool.sockets[(pool.next + i) % len(pool.sockets)]
+    id, ok := socket.reserve(request)
+    if ok {
+      pool.next = (pool.next + i + 1) % len(pool.sockets)
+      return socket, id, nil
+    }
+  }
+
+  // Number of slots matches number of identifiers, so this could not happen
+  pool.slots <- struct{}{}
+  return nil, 0, errors.New("no free packet identifier")
+}
+
+// release frees identifier reserved on socket
+func (pool *socketPool) release(socket *poolSocket, id uint8) {
+  socket.mutex.Lock()
+  delete(socket.pending, id)
+  socket.mutex.Unlock()
+
+  pool.slots <- struct{}{}
+}
+
+// close closes all sockets
+func (pool *socketPool) close() error {
+  pool.once.Do(func() { close(pool.done) })
+
+  var lastErr error
+  for _, socket := range pool.sockets {
+    if err := socket.conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+      lastErr = err
+    }
+  }
+  return lastErr
+}
+
+// reserve finds free identifier on socket and assigns it to request
+func (socket *poolSocket) reserve(request *poolRequest) (uint8, bool) {
+  socket.mutex.Lock()
+  defer socket.mutex.Unlock()
+
+  for i := 0; i < IDS_PER_SOCKET; i++ {
+    id := socket.nextID + uint8(i)
+    if _, busy := socket.pending[id]; !busy {
+      socket.pending[id] = request
+      socket.nextID      = id + 1
+      return id, true
+    }
+  }
+  return 0, false
+}
+
+// sign sets authenticator of request, that holds given identifier
+func (socket *poolSocket) sign(id uint8, authenticator []uint8) {
+  socket.mutex.Lock()
+  defer socket.mutex.Unlock()
+
+  if request, ok := socket.pending[id]; ok {
+    request.authenticator = authenticator
+  }
+}
+
+// readReplies passes replies to requests waiting for them, until socket is closed or fails with
+// error, that is not temporary
+func (socket *poolSocket) readReplies() {
+  buffer := make([]uint8, protocol.MAX_PACKET_LENGTH)
+
+  for {
+    n, addr, err := socket.conn.ReadFrom(buffer)
+    if err != nil {
+      select {
+        case <-socket.done:
+          return
+        default:
+      }
+
+      if errors.Is(err, net.ErrClosed) || !isTemporary(err) {
+        return
+      }
+      continue
+    }
+    if n < protocol.MIN_PACKET_LENGTH {
+      continue
+    }
+
+    reply := make([]uint8, n)
+    copy(reply, buffer[:n])
+
+    var authenticator []uint8
+
+    socket.mutex.Lock()
+    request, ok := socket.pending[reply[1]]
+    if ok {
+      authenticator = request.authenticator
+    }
+    socket.mutex.Unlock()
+
+    if !ok || authenticator == nil || request.remoteAddr != addr.String() || !request.verify(&reply, authenticator) {
+      continue
+    }
+
+    select {
+      case request.reply <- reply:
+      default:
+    }
+  }
+}
+
+// isTemporary reports if read error is transient, so socket could be read again
+func isTemporary(err error) bool {
+  var temporary interface { Temporary() bool }
+  return errors.As(err, &temporary) && temporary.Temporary()
+}
+
+// exchangePooled sends RadiusPacket over socket pool and waits for matching reply
+//
+// Packet gets identifier, that is free on chosen socket, so authenticators depending on it are
+// generated again. Re-sent Accounting-Request gets new identifier, that could be on another socket
+func (client *Client) exchangePooled(ctx context.Context, packet *protocol.RadiusPacket, address string) ([]uint8, error) {
+  remoteAddr, err := net.ResolveUDPAddr("udp", address)
+  if err != nil {
+    return nil, err
+  }
+
+  request := &poolRequest {
+    remoteAddr: remoteAddr.String(),
+    verify:     func(reply *[]uint8, authenticator []uint8) bool {
+      return client.host.VerifyReplyAuthenticator(client.secret, reply, authenticator) == nil
+    },
+    reply:      make(chan []uint8, 1),
+  }
+
+  socket, id, err := client.pool.acquire(ctx, request)
+  if err != nil {
+    return nil, err
+  }
+  defer func() {
+    if socket != nil {
+      client.pool.release(socket, id)
+    }
+  }()
+
+  packet.OverrideID(id)
+  if err := client.signRequest(packet); err != nil {
+    return nil, err
+  }
+
+  reserveID := func() (uint8, error) {
+    client.pool.release(socket, id)
+    request.authenticator = nil
+
+    // Reply to the previous transmission is dropped, as it doesn't match re-signed packet
+    select {
+      case <-request.reply:
+      default:
+    }
+
+    socket, id, err = client.pool.acquire(ctx, request)
+    if err != nil {
+      socket = nil
+      return 0, err
+    }
+    return id, nil
+  }
+
+  transmission := client.newTransmission(packet)
+  for {
+    ok, err := transmission.next(reserveID)
+    if err != nil {
+      return nil, err
+    }
+    if !ok {
+      break
+    }
+
+    socket.sign(id, packet.Authenticator())
+
+    if _, err := socket.conn.WriteTo(transmission.bytes, remoteAddr); err != nil {
+      return nil, err
+    }
+
+    timer := time.NewTimer(transmission.timeout)
+
+    select {
+      case reply := <-request.reply:
+        timer.Stop()
+        return reply, nil
+      case <-ctx.Done():
+        timer.Stop()
+        return nil, ctx.Err()
+      case <-timer.C:
+    }
+  }
+
+  return nil, ErrNoReply
+}
@@ -0,0 +1,123 @@
+package client
+
+import (
+  "context"
+  "io"
+  "net"
+  "sync"
+  "sync/atomic"
+  "testing"
+  "time"
+
+  "github.com/stretchr/testify/assert"
+
+  "github.com/MikhailMS/go-radius/protocol"
+  "github.com/MikhailMS/go-radius/server"
+)
+
+func TestSocketPool(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+
+  radServer := server.InitialiseServer(dictionary, map[string]string { "127.0.0.1": "secret" }, "127.0.0.1", 1, 2)
+  runtime   := server.InitialiseRuntime(&radServer)
+  runtime.SetHandler(protocol.AUTH, func(request *server.Request) (protocol.TypeCode, []protocol.RadiusAttribute, error) {
+    return protocol.AccessAccept, nil, nil
+  })
+  runtime.SetHandler(protocol.ACCT, func(request *server.Request) (protocol.TypeCode, []protocol.RadiusAttribute, error) {
+    return protocol.AccountingResponse, nil, nil
+  })
+  t.Cleanup(func() { runtime.Close() })
+
+  ports := make(map[protocol.RadiusMsgType]uint16)
+  for _, msgType := range []protocol.RadiusMsgType { protocol.AUTH, protocol.ACCT } {
+    conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+    if err != nil {
+      t.Fatal(err)
+    }
+    go runtime.ServePacketConn(conn, msgType)
+    ports[msgType] = uint16(conn.LocalAddr().(*net.UDPAddr).Port)
+  }
+
+  client := InitialiseClient(dictionary, "127.0.0.1", "secret", 3, 2)
+  client.SetPort(protocol.AUTH, ports[protocol.AUTH])
+  client.SetPort(protocol.ACCT, ports[protocol.ACCT])
+  assert.Equal(t, nil, client.SetSocketPool(1), "Socket pool is not opened!")
+  defer client.Close()
+
+  // More requests than identifiers of single socket are outstanding at the same time
+  var wg sync.WaitGroup
+  for i := 0; i < 2 * IDS_PER_SOCKET; i++ {
+    wg.Add(1)
+    go func() {
+      defer wg.Done()
+
+      result, err := client.AuthenticatePAP(context.Background(), "testing", []uint8("password"), nil)
+      assert.Equal(t, nil,  err,               "Access-Request is not answered over socket pool!")
+      assert.Equal(t, true, result.Accepted(), "Access-Request is not accepted over socket pool!")
+    }()
+  }
+  wg.Wait()
+
+  // Request Authenticator of Accounting-Request is generated for identifier allocated by the pool
+  request         := client.CreateAcctRadiusPacket()
+  userName        := []uint8("testing")
+  userNameAttr, _ := client.CreateAttributeByID(userNameID, &userName)
+  request.SetAttributes([]protocol.RadiusAttribute { userNameAttr })
+
+  reply, err := client.SendAndReceivePacket(&request)
+  assert.Equal(t, nil, err, "Accounting-Request is not answered over socket pool!")
+
+  ok, err := client.VerifyReply(&request, &reply)
+  assert.Equal(t, true, ok, "Accounting-Response is not verified!")
+}
+
+func TestSocketPoolIdentifiers(t *testing.T) {
+  pool, err := newSocketPool(1, nil)
+  assert.Equal(t, nil, err, "Socket pool is not opened!")
+  defer pool.close()
+
+  for i := 0; i < IDS_PER_SOCKET; i++ {
+    _, id, err := pool.acquire(context.Background(), &poolRequest{})
+    assert.Equal(t, nil,      err, "Free identifier is not reserved!")
+    assert.Equal(t, uint8(i), id,  "Identifiers are not allocated in turns!")
+  }
+
+  ctx, cancel := context.WithTimeout(context.Background(), 50 * time.Millisecond)
+  defer cancel()
+
+  _, _, err = pool.acquire(ctx, &poolRequest{})
+  assert.Equal(t, context.DeadlineExceeded, err, "Identifier is reserved twice!")
+
+  pool.release(pool.sockets[0], 7)
+  _, id, err := pool.acquire(context.Background(), &poolRequest{})
+  assert.Equal(t, nil,      err, "Released identifier is not reserved again!")
+  assert.Equal(t, uint8(7), id,  "Released identifier is not reused!")
+
+  _, err = newSocketPool(0, nil)
+  assert.Equal(t, "socket pool should have at least one socket", err.Error(), "Empty socket pool is opened!")
+}
+
+// eofPacketConn is net.PacketConn, that returns io.EOF from every read once it is closed
+type eofPacketConn struct {
+  memoryPacketConn
+  reads atomic.Int64
+}
+
+func (conn *eofPacketConn) ReadFrom(buffer []uint8) (int, net.Addr, error) {
+  conn.reads.Add(1)
+  <-conn.closed
+  return 0, nil, io.EOF
+}
+
+func TestSocketPoolStopsOnReadError(t *testing.T) {
+  conn := &eofPacketConn { memoryPacketConn: memoryPacketConn { closed: make(chan struct{}) } }
+  pool := newPacketConnPool([]net.PacketConn { conn })
+
+  assert.Eventually(t, func() bool { return conn.reads.Load() == 1 }, time.Second, time.Millisecond, "Socket is not read!")
+  pool.close()
+
+  // Socket, that is closed, is not read again
+  time.Sleep(50 * time.Millisecond)
+  assert.Equal(t, int64(1), conn.reads.Load(), "Closed socket is read again!")
+}
@@ -0,0 +1,386 @@
+package client
+
+import (
+  "context"
+  "encoding/binary"
+  "errors"
+  "fmt"
+  "log"
+  "os"
+  "path/filepath"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+
+  "github.com/MikhailMS/go-radius/protocol"
+)
+
+// Defaults of AccountingQueue, that could be changed with its setters
+const (
+  QUEUE_RETRY_INTERVAL = 10 * time.Second
+  QUEUE_FILE_EXT       = ".acct"
+  QUEUE_INVALID_EXT    = ".invalid"
+)
+
+// ErrQueueFull is returned, when Accounting-Request doesn't fit into disk space of AccountingQueue
+var ErrQueueFull = errors.New("accounting queue is full")
+
+// queueEntry is Accounting-Request stored in file of AccountingQueue
+type queueEntry struct {
+  name string
+  size int64
+}
+
+// AccountingQueue stores Accounting-Requests on disk and forwards them to RADIUS Server in order,
+// so they are not lost while RADIUS Server is unreachable
+//
+// Every Accounting-Request is written to its own file in queue directory before it is sent, and
+// file is removed once Accounting-Response is received. If RADIUS Server doesn't reply,
+// Accounting-Request is sent again after retry interval, holding back the ones queued after it.
+// Accounting-Requests left on disk are forwarded, once queue is initialised on the same directory
+// again
+//
+// Acct-Delay-Time of forwarded Accounting-Request is increased by the time it spent in the queue,
+// so it gets new identifier and Request Authenticator. Acknowledged Accounting-Request is never sent
+// again: if its file could not be removed, only removal is retried. Accounting-Request, that could
+// not be read back or signed, is set aside with QUEUE_INVALID_EXT appended to its file name
+type AccountingQueue struct {
+  client        *Client
+  dir           string
+  maxBytes      int64
+
+  mutex         sync.Mutex
+  retryInterval time.Duration
+  entries       []queueEntry
+  acknowledged  []queueEntry
+  size          int64
+  nextSeq       uint64
+
+  wake          chan struct{}
+  ctx           context.Context
+  cancel        context.CancelFunc
+  stopped       chan struct{}
+}
+
+// InitialiseAccountingQueue initialises AccountingQueue, that keeps Accounting-Requests in given
+// directory and forwards them with Client; maxBytes limits disk space taken by queued requests,
+// zero means no limit
+//
+// Accounting-Requests, that are already stored in directory, are forwarded first; temporary files
+// left by interrupted **Send** are removed
+func InitialiseAccountingQueue(client *Client, dir string, maxBytes int64) (*AccountingQueue, error) {
+  if err := os.MkdirAll(dir, 0700); err != nil {
+    return nil, err
+  }
+
+  files, err := os.ReadDir(dir)
+  if err != nil {
+    return nil, err
+  }
+
+  ctx, cancel := context.WithCancel(context.Background())
+  queue       := &AccountingQueue {
+    client:        client,
+    dir:           dir,
+    maxBytes:      maxBytes,
+    retryInterval: QUEUE_RETRY_INTERVAL,
+    wake:          make(chan struct{}, 1),
+    ctx:           ctx,
+    cancel:        cancel,
+    stopped:       make(chan struct{}),
+  }
+
+  // Files are named by sequence number, so directory listing keeps the order of the queue
+  for _, file := range files {
+    if !file.IsDir() && filepath.Ext(file.Name()) == ".tmp" {
+      os.Remove(filepath.Join(dir, file.Name()))
+      continue
+    }
+    if file.IsDir() || filepath.Ext(file.Name()) != QUEUE_FILE_EXT {
+      continue
+    }
+
+    seq, err := strconv.ParseUint(strings.TrimSuffix(file.Name(), QUEUE_FILE_EXT), 10, 64)
+    if err != nil {
+      continue
+    }
+
+    info, err := file.Info()
+    if err != nil {
+      cancel()
+      return nil, err
+    }
+
+    queue.entries  = append(queue.entries, queueEntry { file.Name(), info.Size() })
+    queue.size    += info.Size()
+    queue.nextSeq  = seq + 1
+  }
+
+  go queue.forward()
+  return queue, nil
+}
+
+// **Optional**
+//
+// SetRetryInterval sets time to wait before Accounting-Request, that RADIUS Server didn't reply
+// to, is sent again
+func (queue *AccountingQueue) SetRetryInterval(interval time.Duration) {
+  queue.mutex.Lock()
+  defer queue.mutex.Unlock()
+
+  queue.retryInterval = interval
+}
+
+// Send stores Accounting-Request on disk and queues it to be forwarded to RADIUS Server
+//
+// ErrQueueFull is returned, if request doesn't fit into disk space of the queue
+func (queue *AccountingQueue) Send(packet *protocol.RadiusPacket) error {
+  if packet.Code() != protocol.AccountingRequest {
+    return errors.New(fmt.Sprintf("only Accounting-Request could be queued, got packet with code %d", packet.Code()))
+  }
+
+  packetBytes, ok := packet.ToBytes()
+  if !ok {
+    return errors.New("failed to convert RadiusPacket to bytes")
+  }
+
+  // Time request is queued at is stored before it, to update Acct-Delay-Time once it's forwarded
+  record := make([]uint8, 8, 8 + len(packetBytes))
+  binary.BigEndian.PutUint64(record, uint64(time.Now().UnixNano()))
+  record  = append(record, packetBytes...)
+
+  queue.mutex.Lock()
+  defer queue.mutex.Unlock()
+
+  if queue.maxBytes > 0 && queue.size + int64(len(record)) > queue.maxBytes {
+    return ErrQueueFull
+  }
+
+  name := fmt.Sprintf("%020d%s", queue.nextSeq, QUEUE_FILE_EXT)
+  if err := writeFileSync(filepath.Join(queue.dir, name), record); err != nil {
+    return err
+  }
+
+  queue.nextSeq++
+  queue.entries  = append(queue.entries, queueEntry { name, int64(len(record)) })
+  queue.size    += int64(len(record))
+
+  select {
+    case queue.wake <- struct{}{}:
+    default:
+  }
+  return nil
+}
+
+// Len returns number of Accounting-Requests, that are not acknowledged yet
+func (queue *AccountingQueue) Len() int {
+  queue.mutex.Lock()
+  defer queue.mutex.Unlock()
+
+  return len(queue.entries)
+}
+
+// Close stops forwarding of Accounting-Requests; requests, that are not acknowledged yet, are kept
+// on disk
+func (queue *AccountingQueue) Close() error {
+  queue.cancel()
+  <-queue.stopped
+  return nil
+}
+
+// forward sends queued Accounting-Requests one by one, until queue is closed
+func (queue *AccountingQueue) forward() {
+  defer close(queue.stopped)
+
+  for {
+    queue.mutex.Lock()
+    queue.removeAcknowledged()
+    interval  := queue.retryInterval
+    undeleted := len(queue.acknowledged) > 0
+    queue.mutex.Unlock()
+
+    entry, ok := queue.head()
+    if !ok && !undeleted {
+      select {
+        case <-queue.wake:
+          continue
+        case <-queue.ctx.Done():
+          return
+      }
+    }
+
+    if ok && queue.replay(entry) == nil {
+      continue
+    }
+
+    timer := time.NewTimer(interval)
+    select {
+      case <-timer.C:
+      case <-queue.wake:
+        timer.Stop()
+      case <-queue.ctx.Done():
+        timer.Stop()
+        return
+    }
+  }
+}
+
+// head returns the oldest Accounting-Request of the queue
+func (queue *AccountingQueue) head() (queueEntry, bool) {
+  queue.mutex.Lock()
+  defer queue.mutex.Unlock()
+
+  if len(queue.entries) == 0 {
+    return queueEntry{}, false
+  }
+  return queue.entries[0], true
+}
+
+// replay sends stored Accounting-Request to RADIUS Server and removes it from the queue once it is
+// acknowledged; error means request should be sent again later
+//
+// Request, that could not be read back or signed, is set aside, so it doesn't hold back the queue
+func (queue *AccountingQueue) replay(entry queueEntry) error {
+  record, err := os.ReadFile(filepath.Join(queue.dir, entry.name))
+  if err != nil {
+    return queue.setAside(entry, err)
+  }
+  if len(record) < 8 {
+    return queue.setAside(entry, errors.New("record is too short"))
+  }
+
+  queuedAt    := time.Unix(0, int64(binary.BigEndian.Uint64(record[:8])))
+  packetBytes := record[8:]
+
+  packet, err := queue.client.InitialiseRadiusPacketFromBytes(&packetBytes)
+  if err != nil {
+    return queue.setAside(entry, err)
+  }
+  if packet.Code() != protocol.AccountingRequest {
+    return queue.setAside(entry, errors.New(fmt.Sprintf("packet has code %d", packet.Code())))
+  }
+
+  delay := acctDelayTime(&packet) + uint32(time.Since(queuedAt) / time.Second)
+  if err := queue.client.setAcctDelayTime(&packet, delay); err != nil {
+    return queue.setAside(entry, err)
+  }
+
+  packet.OverrideID(queue.client.packetIDSource().PacketID())
+  if err := queue.client.signRequest(&packet); err != nil {
+    return queue.setAside(entry, err)
+  }
+
+  port, ok := queue.client.Port(protocol.AccountingRequest)
+  if !ok {
+    return errors.New("no port is set for Accounting-Request")
+  }
+
+  replyBytes, err := queue.client.exchange(queue.ctx, &packet, port)
+  if err != nil {
+    return err
+  }
+
+  if ok, err := queue.client.VerifyReply(&packet, &replyBytes); !ok {
+    return err
+  }
+
+  reply, err := queue.client.InitialiseRadiusPacketFromBytes(&replyBytes)
+  if err != nil {
+    return err
+  }
+  if reply.Code() != protocol.AccountingResponse {
+    return errors.New(fmt.Sprintf("unexpected reply code %d to Accounting-Request", reply.Code()))
+  }
+
+  queue.remove(entry)
+  return nil
+}
+
+// remove removes the oldest Accounting-Request, that is acknowledged, from the queue and deletes
+// its file; file, that could not be deleted, is deleted later, while request is not sent again
+func (queue *AccountingQueue) remove(entry queueEntry) {
+  queue.mutex.Lock()
+  defer queue.mutex.Unlock()
+
+  queue.entries      = queue.entries[1:]
+  queue.acknowledged = append(queue.acknowledged, entry)
+  queue.removeAcknowledged()
+}
+
+// removeAcknowledged deletes files of acknowledged Accounting-Requests; disk space of files, that
+// could not be deleted, is still counted
+//
+// Should be called with queue mutex held
+func (queue *AccountingQueue) removeAcknowledged() {
+  var undeleted []queueEntry
+
+  for _, entry := range queue.acknowledged {
+    if err := os.Remove(filepath.Join(queue.dir, entry.name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+      log.Println(fmt.Sprintf("WARNING: failed to delete acknowledged Accounting-Request %s: %s", entry.name, err))
+      undeleted = append(undeleted, entry)
+      continue
+    }
+    queue.size -= entry.size
+  }
+
+  queue.acknowledged = undeleted
+}
+
+// setAside removes the oldest Accounting-Request, that could not be forwarded, from the queue and
+// renames its file, so it is kept on disk, but is not forwarded again
+func (queue *AccountingQueue) setAside(entry queueEntry, reason error) error {
+  queue.mutex.Lock()
+  defer queue.mutex.Unlock()
+
+  log.Println(fmt.Sprintf("WARNING: set aside queued Accounting-Request %s: %s", entry.name, reason))
+
+  path := filepath.Join(queue.dir, entry.name)
+  if err := os.Rename(path, path + QUEUE_INVALID_EXT); err != nil && !errors.Is(err, os.ErrNotExist) {
+    return err
+  }
+
+  queue.entries  = queue.entries[1:]
+  queue.size    -= entry.size
+  return nil
+}
+
+// writeFileSync writes data to temporary file, flushes it to disk and renames it to given path,
+// so partially written file is never left at that path; directory is flushed as well, so renamed
+// file survives power loss
+func writeFileSync(path string, data []uint8) error {
+  tmpPath := path + ".tmp"
+
+  file, err := os.OpenFile(tmpPath, os.O_WRONLY | os.O_CREATE | os.O_TRUNC, 0600)
+  if err != nil {
+    return err
+  }
+
+  if _, err := file.Write(data); err != nil {
+    file.Close()
+    os.Remove(tmpPath)
+    return err
+  }
+  if err := file.Sync(); err != nil {
+    file.Close()
+    os.Remove(tmpPath)
+    return err
+  }
+  if err := file.Close(); err != nil {
+    os.Remove(tmpPath)
+    return err
+  }
+
+  if err := os.Rename(tmpPath, path); err != nil {
+    os.Remove(tmpPath)
+    return err
+  }
+
+  dir, err := os.Open(filepath.Dir(path))
+  if err != nil {
+    return err
+  }
+  defer dir.Close()
+
+  return dir.Sync()
+}
@@ -0,0 +1,186 @@
+package client
+
+import (
+  "errors"
+  "fmt"
+  "net"
+  "os"
+  "path/filepath"
+  "strconv"
+  "sync"
+  "testing"
+  "time"
+
+  "github.com/stretchr/testify/assert"
+
+  "github.com/MikhailMS/go-radius/protocol"
+  "github.com/MikhailMS/go-radius/server"
+)
+
+// createTestAcctPacket creates Accounting-Request with given User-Name
+func createTestAcctPacket(client *Client, username string) protocol.RadiusPacket {
+  packet          := client.CreateAcctRadiusPacket()
+  userName        := []uint8(username)
+  userNameAttr, _ := client.CreateAttributeByID(userNameID, &userName)
+  packet.SetAttributes([]protocol.RadiusAttribute { userNameAttr })
+
+  return packet
+}
+
+func TestAccountingQueue(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+  dir           := t.TempDir()
+
+  // RADIUS Server is unreachable while requests are queued
+  conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+  if err != nil {
+    t.Fatal(err)
+  }
+  port := uint16(conn.LocalAddr().(*net.UDPAddr).Port)
+  conn.Close()
+
+  client := InitialiseClient(dictionary, "127.0.0.1", "secret", 0, 1)
+  client.SetPort(protocol.ACCT, port)
+
+  queue, err := InitialiseAccountingQueue(&client, dir, 0)
+  assert.Equal(t, nil, err, "Accounting queue is not initialised!")
+  queue.SetRetryInterval(50 * time.Millisecond)
+
+  for i := 0; i < 3; i++ {
+    packet := createTestAcctPacket(&client, "user" + strconv.Itoa(i))
+    assert.Equal(t, nil, queue.Send(&packet), "Accounting-Request is not queued!")
+  }
+
+  time.Sleep(100 * time.Millisecond)
+  assert.Equal(t, 3, queue.Len(), "Unacknowledged Accounting-Requests are removed from the queue!")
+  queue.Close()
+
+  files, _ := os.ReadDir(dir)
+  assert.Equal(t, 3, len(files), "Accounting-Requests are not stored on disk!")
+
+  // Requests spend at least a second in the queue, so their Acct-Delay-Time is updated
+  time.Sleep(1100 * time.Millisecond)
+
+  var mutex     sync.Mutex
+  var usernames []string
+  var delays    []uint32
+
+  radServer := server.InitialiseServer(dictionary, map[string]string { "127.0.0.1": "secret" }, "127.0.0.1", 1, 2)
+  runtime   := server.InitialiseRuntime(&radServer)
+  runtime.SetHandler(protocol.ACCT, func(request *server.Request) (protocol.TypeCode, []protocol.RadiusAttribute, error) {
+    mutex.Lock()
+    defer mutex.Unlock()
+
+    userName := request.Packet().AttributeByID(userNameID)
+    usernames = append(usernames, string(userName.Value()))
+    delays    = append(delays, acctDelayTime(request.Packet()))
+    return protocol.AccountingResponse, nil, nil
+  })
+
+  conn, err = net.ListenPacket("udp", "127.0.0.1:" + strconv.Itoa(int(port)))
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer conn.Close()
+  go runtime.ServePacketConn(conn, protocol.ACCT)
+
+  // Queue, initialised on the same directory, forwards stored requests once Server is back
+  queue, err = InitialiseAccountingQueue(&client, dir, 0)
+  assert.Equal(t, nil, err, "Accounting queue is not initialised again!")
+  defer queue.Close()
+
+  assert.Eventually(t, func() bool { return queue.Len() == 0 }, 5 * time.Second, 10 * time.Millisecond, "Accounting-Requests are not forwarded!")
+
+  mutex.Lock()
+  defer mutex.Unlock()
+
+  assert.Equal(t, []string { "user0", "user1", "user2" }, usernames, "Accounting-Requests are not forwarded in order!")
+  for _, delay := range delays {
+    assert.Equal(t, true, delay >= 1, "Acct-Delay-Time is not updated!")
+  }
+
+  files, _ = os.ReadDir(dir)
+  assert.Equal(t, 0, len(files), "Acknowledged Accounting-Requests are not removed from disk!")
+}
+
+func TestAccountingQueueLimit(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+
+  client := InitialiseClient(dictionary, "127.0.0.1", "secret", 0, 1)
+
+  // Every record takes 8 bytes of timestamp, 20 bytes of header and 7 bytes of User-Name
+  queue, err := InitialiseAccountingQueue(&client, t.TempDir(), 80)
+  assert.Equal(t, nil, err, "Accounting queue is not initialised!")
+  defer queue.Close()
+
+  for i := 0; i < 2; i++ {
+    packet := createTestAcctPacket(&client, "user" + strconv.Itoa(i))
+    assert.Equal(t, nil, queue.Send(&packet), "Accounting-Request is not queued!")
+  }
+
+  packet := createTestAcctPacket(&client, "user2")
+  assert.Equal(t, ErrQueueFull, queue.Send(&packet), "Disk usage of the queue is not bounded!")
+
+  request := client.CreateAuthRadiusPacket()
+  assert.NotEqual(t, nil, queue.Send(&request), "Access-Request is queued!")
+}
+
+func TestAccountingQueueRetriesDelete(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+  dir           := t.TempDir()
+  client        := InitialiseClient(dictionary, "127.0.0.1", "secret", 0, 1)
+
+  // Queue is closed, so requests are not forwarded behind the test
+  queue, err := InitialiseAccountingQueue(&client, dir, 0)
+  assert.Equal(t, nil, err, "Accounting queue is not initialised!")
+  queue.Close()
+
+  packet := createTestAcctPacket(&client, "user0")
+  assert.Equal(t, nil, queue.Send(&packet), "Accounting-Request is not queued!")
+
+  // Request file is replaced with non-empty directory, so it could not be deleted
+  entry, _ := queue.head()
+  path     := filepath.Join(dir, entry.name)
+  os.Remove(path)
+  os.MkdirAll(filepath.Join(path, "busy"), 0700)
+
+  queue.remove(entry)
+  assert.Equal(t, 0,          queue.Len(),             "Acknowledged request is kept in the queue!")
+  assert.Equal(t, entry.size, queue.size,              "Disk space of undeleted request is not counted!")
+  assert.Equal(t, 1,          len(queue.acknowledged), "Undeleted request is not remembered!")
+
+  os.Remove(filepath.Join(path, "busy"))
+  queue.mutex.Lock()
+  queue.removeAcknowledged()
+  queue.mutex.Unlock()
+
+  _, err = os.Stat(path)
+  assert.Equal(t, true,     errors.Is(err, os.ErrNotExist), "Acknowledged request is not deleted on retry!")
+  assert.Equal(t, int64(0), queue.size,                     "Disk space of deleted request is still counted!")
+}
+
+func TestAccountingQueueSetsAsideInvalidRequest(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+  dir           := t.TempDir()
+  client        := InitialiseClient(dictionary, "127.0.0.1", "secret", 0, 1)
+
+  invalid := fmt.Sprintf("%020d%s", 0, QUEUE_FILE_EXT)
+  os.WriteFile(filepath.Join(dir, invalid), []uint8{ 1, 2, 3 }, 0600)
+  os.WriteFile(filepath.Join(dir, invalid + ".tmp"), []uint8{ 1, 2, 3 }, 0600)
+
+  queue, err := InitialiseAccountingQueue(&client, dir, 0)
+  assert.Equal(t, nil, err, "Accounting queue is not initialised!")
+  defer queue.Close()
+
+  assert.Eventually(t, func() bool { return queue.Len() == 0 }, time.Second, 10 * time.Millisecond, "Invalid request holds back the queue!")
+
+  _, err = os.Stat(filepath.Join(dir, invalid + QUEUE_INVALID_EXT))
+  assert.Equal(t, nil, err, "Invalid request is not kept on disk!")
+
+  _, err = os.Stat(filepath.Join(dir, invalid + ".tmp"))
+  assert.Equal(t, true, errors.Is(err, os.ErrNotExist), "Leftover temporary file is not removed!")
+}
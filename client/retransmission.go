@@ -0,0 +1,196 @@
+package client
+
+import (
+  "encoding/binary"
+  "errors"
+  "math/rand"
+  "time"
+
+  "github.com/MikhailMS/go-radius/protocol"
+)
+
+// acctDelayTimeID is ID of Acct-Delay-Time attribute, that is updated when Accounting-Request is
+// re-sent
+const acctDelayTimeID uint8 = 41
+
+// RetransmissionPolicy defines how request is re-sent, if RADIUS Server doesn't reply, as
+// described by RFC 5080 (section 2.2.1)
+//
+// Time to wait for reply starts at IRT and doubles after every transmission up to MRT, with
+// random jitter of ±10%; request is re-sent up to MRC times and for no longer than MRD since the
+// first transmission. Zero MRC, MRT or MRD means no limit
+type RetransmissionPolicy struct {
+  IRT time.Duration // Initial retransmission time
+  MRC int           // Maximum retransmission count
+  MRT time.Duration // Maximum retransmission time
+  MRD time.Duration // Maximum retransmission duration
+}
+
+// DEFAULT_RETRANSMISSION_POLICY has values recommended by RFC 5080 (section 2.2.1)
+var DEFAULT_RETRANSMISSION_POLICY = RetransmissionPolicy { IRT: 2 * time.Second, MRC: 5, MRT: 16 * time.Second, MRD: 30 * time.Second }
+
+// **Optional**
+//
+// SetRetransmissionPolicy sets exponential backoff, with which requests are re-sent over UDP or
+// DTLS, instead of re-sending them up to *retries* times with fixed *timeout*
+//
+// As required by RFC 5080 (section 2.2.1), Access-Request is re-sent with the same identifier and
+// authenticator, while re-sent Accounting-Request gets updated Acct-Delay-Time and therefore new
+// identifier and Request Authenticator
+func (client *Client) SetRetransmissionPolicy(policy RetransmissionPolicy) error {
+  if policy.IRT <= 0 {
+    return errors.New("initial retransmission time should be positive")
+  }
+  if client.stream != nil && client.stream.reliable {
+    return errors.New("requests are not re-sent over reliable transport")
+  }
+
+  client.policy = &policy
+  return nil
+}
+
+// transmission schedules sending of single request and prepares request for every transmission
+type transmission struct {
+  client  *Client
+  packet  *protocol.RadiusPacket
+  bytes   []uint8
+  timeout time.Duration
+
+  sent    int
+  started time.Time
+  delay   uint32
+}
+
+// newTransmission starts transmission of given request
+func (client *Client) newTransmission(packet *protocol.RadiusPacket) *transmission {
+  return &transmission { client: client, packet: packet }
+}
+
+// next prepares request for the next transmission, so its bytes and time to wait for reply are
+// set; it returns false once request shouldn't be sent anymore
+//
+// Before Accounting-Request is re-sent, its Acct-Delay-Time is updated and reserveID is called for
+// new identifier
+func (transmission *transmission) next(reserveID func() (uint8, error)) (bool, error) {
+  if transmission.sent == 0 {
+    transmission.started = time.Now()
+    transmission.delay   = acctDelayTime(transmission.packet)
+  }
+
+  timeout, ok := transmission.schedule()
+  if !ok {
+    return false, nil
+  }
+
+  if transmission.sent > 0 && transmission.packet.Code() == protocol.AccountingRequest {
+    delay := transmission.delay + uint32(time.Since(transmission.started) / time.Second)
+    if err := transmission.client.setAcctDelayTime(transmission.packet, delay); err != nil {
+      return false, err
+    }
+
+    id, err := reserveID()
+    if err != nil {
+      return false, err
+    }
+    transmission.packet.OverrideID(id)
+
+    if err := transmission.client.signRequest(transmission.packet); err != nil {
+      return false, err
+    }
+    transmission.bytes = nil
+  }
+
+  if transmission.bytes == nil {
+    bytes, ok := transmission.packet.ToBytes()
+    if !ok {
+      return false, errors.New("failed to convert RadiusPacket to bytes")
+    }
+    transmission.bytes = bytes
+  }
+
+  transmission.timeout = timeout
+  transmission.sent++
+  return true, nil
+}
+
+// schedule returns time to wait for reply after the next transmission, or false once request
+// shouldn't be sent anymore
+func (transmission *transmission) schedule() (time.Duration, bool) {
+  policy := transmission.client.policy
+  if policy == nil {
+    return time.Duration(transmission.client.timeout) * time.Second, transmission.sent <= int(transmission.client.retries)
+  }
+
+  if policy.MRC > 0 && transmission.sent > policy.MRC {
+    return 0, false
+  }
+
+  timeout := policy.IRT + jitter(policy.IRT)
+  if transmission.sent > 0 {
+    timeout = 2 * transmission.timeout + jitter(transmission.timeout)
+  }
+  if policy.MRT > 0 && timeout > policy.MRT {
+    timeout = policy.MRT + jitter(policy.MRT)
+  }
+
+  if policy.MRD > 0 {
+    remaining := policy.MRD - time.Since(transmission.started)
+    if remaining <= 0 {
+      return 0, false
+    }
+    if timeout > remaining {
+      timeout = remaining
+    }
+  }
+
+  return timeout, true
+}
+
+// acctDelayTime returns Acct-Delay-Time of packet, or zero if packet has none
+func acctDelayTime(packet *protocol.RadiusPacket) uint32 {
+  delay := packet.AttributeByID(acctDelayTimeID)
+  if len(delay.Value()) != 4 {
+    return 0
+  }
+  return binary.BigEndian.Uint32(delay.Value())
+}
+
+// setAcctDelayTime sets Acct-Delay-Time of packet to given number of seconds, adding attribute if
+// packet has none
+func (client *Client) setAcctDelayTime(packet *protocol.RadiusPacket, delay uint32) error {
+  value := make([]uint8, 4)
+  binary.BigEndian.PutUint32(value, delay)
+
+  attributes := packet.Attributes()
+  for idx := range attributes {
+    if attributes[idx].ID() == acctDelayTimeID {
+      attributes[idx].OverrideValue(value)
+      return nil
+    }
+  }
+
+  attr, err := client.CreateAttributeByID(acctDelayTimeID, &value)
+  if err != nil {
+    return err
+  }
+  packet.SetAttributes(append(attributes, attr))
+  return nil
+}
+
+// signRequest generates authenticators of request, that depend on its identifier
+func (client *Client) signRequest(packet *protocol.RadiusPacket) error {
+  switch packet.Code() {
+    case protocol.AccountingRequest, protocol.CoARequest, protocol.DisconnectRequest:
+      return packet.GenerateRequestAuthenticator(client.secret)
+  }
+
+  if _, err := packet.MessageAuthenticator(); err == nil {
+    return packet.GenerateMessageAuthenticator(client.secret)
+  }
+  return nil
+}
+
+// jitter returns random duration within ±10% of given one (RAND of RFC 5080)
+func jitter(duration time.Duration) time.Duration {
+  return time.Duration((rand.Float64() * 0.2 - 0.1) * float64(duration))
+}
@@ -0,0 +1,194 @@
+package client
+
+import (
+  "encoding/binary"
+  "errors"
+  "net"
+  "sync"
+  "testing"
+  "time"
+
+  "github.com/stretchr/testify/assert"
+
+  "github.com/MikhailMS/go-radius/protocol"
+  "github.com/MikhailMS/go-radius/server"
+)
+
+// sequentialIDSource issues packet IDs in turns, so re-sent packets always get new ID
+type sequentialIDSource struct {
+  mutex sync.Mutex
+  next  uint8
+}
+
+func (source *sequentialIDSource) PacketID() uint8 {
+  source.mutex.Lock()
+  defer source.mutex.Unlock()
+
+  source.next++
+  return source.next
+}
+
+func (source *sequentialIDSource) PacketAuthenticator() []uint8 {
+  return protocol.CryptoRandSource{}.PacketAuthenticator()
+}
+
+func TestRetransmissionSchedule(t *testing.T) {
+  client := InitialiseClient(protocol.Dictionary{}, "127.0.0.1", "secret", 2, 1)
+  packet := client.CreateAuthRadiusPacket()
+
+  // By default packet is re-sent *retries* times with fixed timeout
+  var timeouts []time.Duration
+  transmission := client.newTransmission(&packet)
+  for {
+    ok, err := transmission.next(nil)
+    assert.Equal(t, nil, err, "Packet is not prepared for transmission!")
+    if !ok {
+      break
+    }
+    timeouts = append(timeouts, transmission.timeout)
+  }
+  assert.Equal(t, []time.Duration { time.Second, time.Second, time.Second }, timeouts, "Fixed timeouts are not scheduled!")
+
+  // Timeout doubles up to MRT, till MRC retransmissions are sent
+  assert.Equal(t, nil, client.SetRetransmissionPolicy(RetransmissionPolicy { IRT: 100 * time.Millisecond, MRC: 3, MRT: 300 * time.Millisecond }), "Retransmission policy is not set!")
+
+  timeouts      = nil
+  transmission  = client.newTransmission(&packet)
+  for {
+    ok, _ := transmission.next(nil)
+    if !ok {
+      break
+    }
+    timeouts = append(timeouts, transmission.timeout)
+  }
+
+  expected := []time.Duration { 100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond }
+  assert.Equal(t, len(expected), len(timeouts), "Packet is not re-sent MRC times!")
+  for idx, timeout := range timeouts {
+    assert.InDelta(t, float64(expected[idx]), float64(timeout), 0.25 * float64(expected[idx]), "Timeout is not backed off exponentially!")
+  }
+
+  // Timeout never exceeds what is left of MRD
+  client.SetRetransmissionPolicy(RetransmissionPolicy { IRT: time.Second, MRD: 500 * time.Millisecond })
+  transmission = client.newTransmission(&packet)
+  transmission.next(nil)
+  assert.Equal(t, true, transmission.timeout <= 500 * time.Millisecond, "Timeout exceeds MRD!")
+
+  assert.Equal(t, "initial retransmission time should be positive", client.SetRetransmissionPolicy(RetransmissionPolicy{}).Error(), "Policy without IRT is set!")
+
+  tcpClient := InitialiseTCPClient(protocol.Dictionary{}, "127.0.0.1", "secret", 1)
+  assert.Equal(t, "requests are not re-sent over reliable transport", tcpClient.SetRetransmissionPolicy(DEFAULT_RETRANSMISSION_POLICY).Error(), "Policy is set for TCP!")
+}
+
+func TestRetransmission(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+
+  // Server drops the first two transmissions of every request
+  var mutex    sync.Mutex
+  var received []protocol.RadiusPacket
+
+  drop := func(request *server.Request) bool {
+    mutex.Lock()
+    defer mutex.Unlock()
+
+    received = append(received, *request.Packet())
+    return len(received) < 3
+  }
+  reset := func() {
+    mutex.Lock()
+    defer mutex.Unlock()
+
+    received = nil
+  }
+  snapshot := func() []protocol.RadiusPacket {
+    mutex.Lock()
+    defer mutex.Unlock()
+
+    return received
+  }
+
+  radServer := server.InitialiseServer(dictionary, map[string]string { "127.0.0.1": "secret" }, "127.0.0.1", 1, 2)
+  runtime   := server.InitialiseRuntime(&radServer)
+  runtime.SetHandler(protocol.AUTH, func(request *server.Request) (protocol.TypeCode, []protocol.RadiusAttribute, error) {
+    if drop(request) {
+      return 0, nil, errors.New("dropped by test")
+    }
+    return protocol.AccessAccept, nil, nil
+  })
+  runtime.SetHandler(protocol.ACCT, func(request *server.Request) (protocol.TypeCode, []protocol.RadiusAttribute, error) {
+    if drop(request) {
+      return 0, nil, errors.New("dropped by test")
+    }
+    return protocol.AccountingResponse, nil, nil
+  })
+
+  ports := make(map[protocol.RadiusMsgType]uint16)
+  for _, msgType := range []protocol.RadiusMsgType { protocol.AUTH, protocol.ACCT } {
+    conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+    if err != nil {
+      t.Fatal(err)
+    }
+    t.Cleanup(func() { conn.Close() })
+    go runtime.ServePacketConn(conn, msgType)
+    ports[msgType] = uint16(conn.LocalAddr().(*net.UDPAddr).Port)
+  }
+
+  for _, pooled := range []bool { false, true } {
+    client := InitialiseClient(dictionary, "127.0.0.1", "secret", 0, 1)
+    client.SetPort(protocol.AUTH, ports[protocol.AUTH])
+    client.SetPort(protocol.ACCT, ports[protocol.ACCT])
+    client.SetPacketIDSource(&sequentialIDSource{})
+    if pooled {
+      assert.Equal(t, nil, client.SetSocketPool(2), "Socket pool is not opened!")
+    }
+
+    // Access-Request is re-sent with the same identifier and authenticator
+    client.SetRetransmissionPolicy(RetransmissionPolicy { IRT: 50 * time.Millisecond, MRC: 3 })
+    reset()
+
+    request         := client.CreateAuthRadiusPacket()
+    userName        := []uint8("testing")
+    userNameAttr, _ := client.CreateAttributeByID(userNameID, &userName)
+    request.SetAttributes([]protocol.RadiusAttribute { userNameAttr })
+
+    reply, err := client.SendAndReceivePacket(&request)
+    assert.Equal(t, nil, err, "Re-sent Access-Request is not answered!")
+    ok, _ := client.VerifyReply(&request, &reply)
+    assert.Equal(t, true, ok, "Reply to re-sent Access-Request is not verified!")
+
+    packets := snapshot()
+    assert.Equal(t, 3, len(packets), "Access-Request is not re-sent!")
+    for _, packet := range packets {
+      assert.Equal(t, request.ID(),            packet.ID(),            "Access-Request is re-sent with new identifier!")
+      assert.Equal(t, request.Authenticator(), packet.Authenticator(), "Access-Request is re-sent with new authenticator!")
+    }
+
+    // Accounting-Request is re-sent with updated Acct-Delay-Time, new identifier and authenticator
+    client.SetRetransmissionPolicy(RetransmissionPolicy { IRT: 600 * time.Millisecond, MRC: 3 })
+    reset()
+
+    request = client.CreateAcctRadiusPacket()
+    request.SetAttributes([]protocol.RadiusAttribute { userNameAttr })
+    request.GenerateRequestAuthenticator(client.Secret())
+
+    reply, err = client.SendAndReceivePacket(&request)
+    assert.Equal(t, nil, err, "Re-sent Accounting-Request is not answered!")
+    ok, _ = client.VerifyReply(&request, &reply)
+    assert.Equal(t, true, ok, "Reply to re-sent Accounting-Request is not verified!")
+
+    packets = snapshot()
+    assert.Equal(t, 3, len(packets), "Accounting-Request is not re-sent!")
+    assert.NotEqual(t, packets[0].ID(),            packets[1].ID(),            "Accounting-Request is re-sent with the same identifier!")
+    assert.NotEqual(t, packets[0].Authenticator(), packets[1].Authenticator(), "Accounting-Request is re-sent with the same authenticator!")
+    assert.Equal(t, request.ID(), packets[2].ID(), "Packet is not updated with identifier of the last transmission!")
+
+    delay := packets[2].AttributeByID(acctDelayTimeID)
+    assert.Equal(t, 4, len(delay.Value()), "Acct-Delay-Time is not added to re-sent Accounting-Request!")
+    if len(delay.Value()) == 4 {
+      assert.Equal(t, true, binary.BigEndian.Uint32(delay.Value()) >= 1, "Acct-Delay-Time is not updated!")
+    }
+
+    client.Close()
+  }
+}
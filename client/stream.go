@@ -0,0 +1,342 @@
+package client
+
+import (
+  "context"
+  "errors"
+  "io"
+  "net"
+  "sync"
+  "time"
+
+  "github.com/MikhailMS/go-radius/protocol"
+)
+
+// errIdle is returned for request, that is not written, because connection is closed after idle
+// timeout
+var errIdle = errors.New("connection is closed after idle timeout")
+
+// streamTransport keeps connections (RadSec, TCP or DTLS) to RADIUS Server, one per address, and
+// re-dials them once they are closed
+//
+// Requests are only re-sent over datagram transport; reliable transport waits for reply to the
+// single transmission
+type streamTransport struct {
+  dial        func(ctx context.Context, address string) (net.Conn, error)
+  read        func(reader io.Reader) ([]uint8, error)
+  reliable    bool
+  idleTimeout time.Duration
+
+  mutex       sync.Mutex
+  conns       map[string]*streamConn
+}
+
+// newStreamTransport initialises transport, that dials connections with dial and reads packets
+// from them with read
+func newStreamTransport(dial func(ctx context.Context, address string) (net.Conn, error), read func(reader io.Reader) ([]uint8, error), reliable bool) *streamTransport {
+  return &streamTransport { dial: dial, read: read, reliable: reliable, conns: make(map[string]*streamConn) }
+}
+
+// exchange sends request over connection to given address and waits for reply with matching
+// identifier
+//
+// Connection, that is closed after idle timeout just before request is written, is re-dialed once
+func (transport *streamTransport) exchange(ctx context.Context, address string, transmission *transmission) ([]uint8, error) {
+  conn, err := transport.conn(ctx, address)
+  if err != nil {
+    return nil, err
+  }
+
+  reply, err := conn.exchange(ctx, transmission)
+  if !errors.Is(err, errIdle) {
+    return reply, err
+  }
+
+  conn, err = transport.conn(ctx, address)
+  if err != nil {
+    return nil, err
+  }
+  return conn.exchange(ctx, transmission)
+}
+
+// conn returns open connection to given address, dialing it if needed
+func (transport *streamTransport) conn(ctx context.Context, address string) (*streamConn, error) {
+  transport.mutex.Lock()
+  defer transport.mutex.Unlock()
+
+  if conn, ok := transport.conns[address]; ok && !conn.isClosed() {
+    return conn, nil
+  }
+
+  netConn, err := transport.dial(ctx, address)
+  if err != nil {
+    return nil, err
+  }
+
+  conn := newStreamConn(netConn, transport.read, transport.idleTimeout)
+  transport.conns[address] = conn
+  return conn, nil
+}
+
+// close closes all connections
+func (transport *streamTransport) close() error {
+  transport.mutex.Lock()
+  defer transport.mutex.Unlock()
+
+  for address, conn := range transport.conns {
+    conn.fail(net.ErrClosed)
+    delete(transport.conns, address)
+  }
+  return nil
+}
+
+// streamConn is connection, that carries multiple outstanding requests, which are matched with
+// replies by their identifiers and authenticators
+type streamConn struct {
+  conn        net.Conn
+  read        func(reader io.Reader) ([]uint8, error)
+  idleTimeout time.Duration
+
+  mutex       sync.Mutex
+  pending     map[uint8]*streamRequest
+  err         error
+  closed      chan struct{}
+}
+
+// streamRequest is request, that waits for reply
+//
+// Authenticator is set once request is signed with reserved identifier; till then replies are not
+// matched
+type streamRequest struct {
+  authenticator []uint8
+  verify        func(reply *[]uint8, authenticator []uint8) bool
+  reply         chan []uint8
+}
+
+func newStreamConn(conn net.Conn, read func(reader io.Reader) ([]uint8, error), idleTimeout time.Duration) *streamConn {
+  streamConn := &streamConn { conn: conn, read: read, idleTimeout: idleTimeout, pending: make(map[uint8]*streamRequest), closed: make(chan struct{}) }
+  go streamConn.readReplies()
+
+  return streamConn
+}
+
+// readReplies passes replies to requests waiting for them, until connection is closed or stays
+// idle without outstanding requests
+//
+// Replies, that don't match authenticators of outstanding request, are dropped
+func (conn *streamConn) readReplies() {
+  for {
+    if conn.idleTimeout > 0 {
+      conn.conn.SetReadDeadline(time.Now().Add(conn.idleTimeout))
+    }
+
+    reply, err := conn.read(conn.conn)
+    if err != nil {
+      var netErr net.Error
+      if errors.As(err, &netErr) && netErr.Timeout() {
+        if conn.failIdle() {
+          return
+        }
+        continue
+      }
+
+      conn.fail(err)
+      return
+    }
+
+    var authenticator []uint8
+
+    conn.mutex.Lock()
+    request, ok := conn.pending[reply[1]]
+    if ok {
+      authenticator = request.authenticator
+    }
+    conn.mutex.Unlock()
+
+    // Replies nobody waits for anymore or forged ones are dropped
+    if !ok || authenticator == nil || !request.verify(&reply, authenticator) {
+      continue
+    }
+
+    select {
+      case request.reply <- reply:
+      default:
+    }
+  }
+}
+
+// exchange writes request to connection and waits for reply with matching identifier
+//
+// If no reply is received, request is re-sent as scheduled by transmission, which only makes sense
+// for datagram transport
+func (conn *streamConn) exchange(ctx context.Context, transmission *transmission) ([]uint8, error) {
+  id     := transmission.packet.ID()
+  client := transmission.client
+
+  conn.mutex.Lock()
+  if conn.err != nil {
+    conn.mutex.Unlock()
+    return nil, conn.err
+  }
+  if _, ok := conn.pending[id]; ok {
+    // Identifier is used by concurrent request, so request gets free one and is signed again
+    free, ok := conn.freeID(id)
+    if !ok {
+      conn.mutex.Unlock()
+      return nil, errors.New("no free packet identifier on the connection")
+    }
+
+    id = free
+    transmission.packet.OverrideID(id)
+    if err := client.signRequest(transmission.packet); err != nil {
+      conn.mutex.Unlock()
+      return nil, err
+    }
+  }
+  request := &streamRequest {
+    verify: func(reply *[]uint8, authenticator []uint8) bool {
+      if client.host.VerifyReplyAuthenticator(client.secret, reply, authenticator) != nil {
+        return false
+      }
+
+      err := client.host.VerifyReplyMessageAuthenticator(client.secret, reply, authenticator)
+      return err == nil || errors.Is(err, protocol.ErrNoMessageAuthenticator)
+    },
+    reply:  make(chan []uint8, 1),
+  }
+  conn.pending[id] = request
+  conn.mutex.Unlock()
+
+  // Re-sent request waits for reply with identifier, that is free on the connection
+  reserveID := func() (uint8, error) {
+    conn.mutex.Lock()
+    defer conn.mutex.Unlock()
+
+    next, ok := conn.freeID(client.packetIDSource().PacketID())
+    if !ok {
+      return 0, errors.New("no free packet identifier on the connection")
+    }
+
+    // Reply to the previous transmission is dropped, as it doesn't match re-signed packet
+    delete(conn.pending, id)
+    request.authenticator = nil
+    select {
+      case <-request.reply:
+      default:
+    }
+
+    conn.pending[next] = request
+    id                 = next
+    return next, nil
+  }
+
+  for {
+    ok, err := transmission.next(reserveID)
+    if err != nil {
+      conn.release(id)
+      return nil, err
+    }
+    if !ok {
+      break
+    }
+
+    conn.mutex.Lock()
+    request.authenticator = append([]uint8(nil), transmission.packet.Authenticator()...)
+    conn.mutex.Unlock()
+
+    // net.Conn writes whole packet at once, even if it is shared by multiple goroutines
+    if _, err := conn.conn.Write(transmission.bytes); err != nil {
+      conn.fail(err)
+      return nil, err
+    }
+
+    timer := time.NewTimer(transmission.timeout)
+
+    select {
+      case reply := <-request.reply:
+        timer.Stop()
+        return reply, nil
+      case <-conn.closed:
+        timer.Stop()
+        return nil, conn.err
+      case <-ctx.Done():
+        timer.Stop()
+        conn.release(id)
+        return nil, ctx.Err()
+      case <-timer.C:
+    }
+  }
+
+  conn.release(id)
+  return nil, ErrNoReply
+}
+
+// freeID returns the first identifier starting from given one, that is not used by outstanding
+// request
+//
+// Should be called with connection mutex held
+func (conn *streamConn) freeID(start uint8) (uint8, bool) {
+  for i := 0; i < IDS_PER_SOCKET; i++ {
+    id := start + uint8(i)
+    if _, busy := conn.pending[id]; !busy {
+      return id, true
+    }
+  }
+  return 0, false
+}
+
+// release stops waiting for reply with given identifier
+func (conn *streamConn) release(id uint8) {
+  conn.mutex.Lock()
+  defer conn.mutex.Unlock()
+
+  delete(conn.pending, id)
+}
+
+// fail closes connection, so requests waiting for replies are failed with given error
+func (conn *streamConn) fail(err error) {
+  conn.mutex.Lock()
+  defer conn.mutex.Unlock()
+
+  conn.close(err)
+}
+
+// failIdle closes connection with errIdle, unless there are requests waiting for replies, and
+// reports if it is closed
+//
+// As requests are added to pending under the same mutex, request is either written to connection,
+// that stays open, or fails with errIdle before it is written
+func (conn *streamConn) failIdle() bool {
+  conn.mutex.Lock()
+  defer conn.mutex.Unlock()
+
+  if len(conn.pending) > 0 {
+    return false
+  }
+
+  conn.close(errIdle)
+  return true
+}
+
+// close closes connection with given error, unless it is closed already
+//
+// Should be called with connection mutex held
+func (conn *streamConn) close(err error) {
+  if conn.err != nil {
+    return
+  }
+
+  conn.err     = err
+  conn.pending = make(map[uint8]*streamRequest)
+  conn.conn.Close()
+  close(conn.closed)
+}
+
+// isClosed reports if connection is closed
+func (conn *streamConn) isClosed() bool {
+  select {
+    case <-conn.closed:
+      return true
+    default:
+      return false
+  }
+}
@@ -0,0 +1,178 @@
+package client
+
+import (
+  "context"
+  "io"
+  "net"
+  "testing"
+  "time"
+
+  "github.com/stretchr/testify/assert"
+
+  "github.com/MikhailMS/go-radius/protocol"
+  "github.com/MikhailMS/go-radius/server"
+)
+
+// acceptStreamRequest creates Access-Accept, signed with "secret", for given request
+func acceptStreamRequest(request []uint8) []uint8 {
+  radServer     := server.InitialiseServer(protocol.Dictionary{}, nil, "127.0.0.1", 0, 1)
+  reply, _      := radServer.CreateReplyPacket(protocol.AccessAccept, nil, &request, "secret")
+  replyBytes, _ := reply.ToBytes()
+
+  return replyBytes
+}
+
+func TestStreamConnMatchesReplies(t *testing.T) {
+  local, remote := net.Pipe()
+  defer remote.Close()
+
+  conn := newStreamConn(local, protocol.ReadStreamPacket, 0)
+  defer conn.fail(net.ErrClosed)
+
+  // Server replies to both requests in reverse order
+  go func() {
+    first, _  := protocol.ReadStreamPacket(remote)
+    second, _ := protocol.ReadStreamPacket(remote)
+    remote.Write(acceptStreamRequest(second))
+    remote.Write(acceptStreamRequest(first))
+
+    io.Copy(io.Discard, remote)
+  }()
+
+  client   := InitialiseClient(protocol.Dictionary{}, "127.0.0.1", "secret", 0, 1)
+  requests := []protocol.RadiusPacket { client.CreateAuthRadiusPacket(), client.CreateAuthRadiusPacket() }
+  requests[0].OverrideID(7)
+  requests[1].OverrideID(8)
+  replies := make(chan []uint8, len(requests))
+
+  for idx := range requests {
+    go func(request *protocol.RadiusPacket) {
+      reply, _ := conn.exchange(context.Background(), client.newTransmission(request))
+      replies <- reply
+    }(&requests[idx])
+    time.Sleep(10 * time.Millisecond)
+  }
+
+  for range requests {
+    reply := <-replies
+    assert.Equal(t, 20, len(reply), "Reply is not received!")
+  }
+
+  client.SetRetransmissionPolicy(RetransmissionPolicy { IRT: 50 * time.Millisecond, MRD: 50 * time.Millisecond })
+  _, err := conn.exchange(context.Background(), client.newTransmission(&requests[0]))
+  assert.Equal(t, "no reply received from RADIUS Server", err.Error(), "Request without reply doesn't time out!")
+}
+
+func TestStreamConnReassignsIdentifier(t *testing.T) {
+  local, remote := net.Pipe()
+  defer remote.Close()
+
+  conn := newStreamConn(local, protocol.ReadStreamPacket, 0)
+  defer conn.fail(net.ErrClosed)
+
+  // Server replies once both requests are received
+  go func() {
+    first, _  := protocol.ReadStreamPacket(remote)
+    second, _ := protocol.ReadStreamPacket(remote)
+    remote.Write(acceptStreamRequest(first))
+    remote.Write(acceptStreamRequest(second))
+
+    io.Copy(io.Discard, remote)
+  }()
+
+  client   := InitialiseClient(protocol.Dictionary{}, "127.0.0.1", "secret", 0, 1)
+  requests := []protocol.RadiusPacket { client.CreateAuthRadiusPacket(), client.CreateAuthRadiusPacket() }
+  requests[0].OverrideID(9)
+  requests[1].OverrideID(9)
+  errs := make(chan error, len(requests))
+
+  for idx := range requests {
+    go func(request *protocol.RadiusPacket) {
+      _, err := conn.exchange(context.Background(), client.newTransmission(request))
+      errs <- err
+    }(&requests[idx])
+    time.Sleep(10 * time.Millisecond)
+  }
+
+  for range requests {
+    assert.Equal(t, nil, <-errs, "Request with identifier in use is not answered!")
+  }
+  assert.NotEqual(t, requests[0].ID(), requests[1].ID(), "Identifier in use is not reassigned!")
+}
+
+func TestStreamConnIdleTimeout(t *testing.T) {
+  local, remote := net.Pipe()
+  defer remote.Close()
+
+  conn := newStreamConn(local, protocol.ReadStreamPacket, 50 * time.Millisecond)
+  time.Sleep(200 * time.Millisecond)
+
+  assert.Equal(t, true, conn.isClosed(), "Idle connection is not closed!")
+}
+
+func TestStreamConnDropsForgedReplies(t *testing.T) {
+  local, remote := net.Pipe()
+  defer remote.Close()
+
+  conn := newStreamConn(local, protocol.ReadStreamPacket, 0)
+  defer conn.fail(net.ErrClosed)
+
+  // Server echoes request back, then sends reply signed with another secret and the valid one last
+  go func() {
+    request, _ := protocol.ReadStreamPacket(remote)
+    remote.Write(request)
+
+    radServer      := server.InitialiseServer(protocol.Dictionary{}, nil, "127.0.0.1", 0, 1)
+    forged, _      := radServer.CreateReplyPacket(protocol.AccessReject, nil, &request, "forged")
+    forgedBytes, _ := forged.ToBytes()
+    remote.Write(forgedBytes)
+
+    remote.Write(acceptStreamRequest(request))
+
+    io.Copy(io.Discard, remote)
+  }()
+
+  client  := InitialiseClient(protocol.Dictionary{}, "127.0.0.1", "secret", 0, 1)
+  request := client.CreateAuthRadiusPacket()
+
+  reply, err := conn.exchange(context.Background(), client.newTransmission(&request))
+  assert.Equal(t, nil, err, "Request is not answered!")
+  ok, _ := client.VerifyReply(&request, &reply)
+  assert.Equal(t, true, ok, "Reply, that doesn't match request, is passed to it!")
+}
+
+func TestStreamTransportRedialsIdleConnection(t *testing.T) {
+  dials := 0
+  dial  := func(ctx context.Context, address string) (net.Conn, error) {
+    dials += 1
+
+    local, remote := net.Pipe()
+    go func() {
+      defer remote.Close()
+
+      request, err := protocol.ReadStreamPacket(remote)
+      if err != nil {
+        return
+      }
+      remote.Write(acceptStreamRequest(request))
+    }()
+    return local, nil
+  }
+
+  transport := newStreamTransport(dial, protocol.ReadStreamPacket, true)
+  conn, _   := transport.conn(context.Background(), "server")
+
+  // Connection is closed after idle timeout, while it is still kept by transport
+  assert.Equal(t, true, conn.failIdle(), "Connection without outstanding requests is not idle!")
+
+  client  := InitialiseClient(protocol.Dictionary{}, "127.0.0.1", "secret", 0, 1)
+  request := client.CreateAuthRadiusPacket()
+
+  _, err := conn.exchange(context.Background(), client.newTransmission(&request))
+  assert.Equal(t, errIdle, err, "Request is written to idle connection!")
+
+  _, err = transport.exchange(context.Background(), "server", client.newTransmission(&request))
+  assert.Equal(t, nil, err, "Idle connection is not re-dialed!")
+  assert.Equal(t, 2,   dials, "Idle connection is not re-dialed!")
+  transport.close()
+}
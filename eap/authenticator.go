@@ -0,0 +1,440 @@
+// Server side of EAP conversation carried over RADIUS: correlation of Access-Requests by State
+// attribute and dispatch to EAP methods
+package eap
+
+import (
+  "crypto/rand"
+  "errors"
+  "fmt"
+  "io"
+  "sync"
+  "time"
+
+  "github.com/MikhailMS/go-radius/protocol"
+  "github.com/MikhailMS/go-radius/server"
+  "github.com/MikhailMS/go-radius/tools"
+)
+
+// MethodResult represents outcome of EAP method processing EAP-Response
+type MethodResult int
+
+const (
+  // Method needs another round trip
+  Continue MethodResult = iota
+  // Peer is authenticated
+  Succeeded
+  // Peer is not authenticated
+  Failed
+)
+
+// Method is server side of EAP authentication method
+//
+// New Method is created for every EAP conversation, so it could keep conversation state. If Method
+// also implements io.Closer, it is closed once conversation is finished or dropped
+type Method interface {
+  // Type returns EAP Type of the method
+  Type() EapType
+  // Initiate returns Type-Data of the first EAP-Request of the method
+  Initiate(session *Session) ([]uint8, error)
+  // Process handles EAP-Response of the method; when result is Continue, returns Type-Data of the next
+  // EAP-Request
+  Process(session *Session, response *EapPacket) (MethodResult, []uint8, error)
+}
+
+// KeyingMethod is Method, that derives keying material (RFC 5247), which is sent to NAS in
+// MS-MPPE-Recv-Key & MS-MPPE-Send-Key attributes of Access-Accept
+type KeyingMethod interface {
+  Method
+  // MSK returns 64 octets long Master Session Key
+  MSK() []uint8
+}
+
+// MethodFactory creates new Method for EAP conversation
+type MethodFactory func() Method
+
+// PasswordLookup returns cleartext password of given user
+type PasswordLookup func(identity string) ([]uint8, bool)
+
+// Session represents state of single EAP conversation
+type Session struct {
+  identity   string
+  state      []uint8
+  lastID     uint8
+  method     Method
+  triedTypes []EapType
+  created    time.Time
+  busy       bool
+}
+
+// cachedReply is the last reply sent in EAP conversation, that is sent again, if Access-Request is
+// retransmitted
+type cachedReply struct {
+  id         uint8
+  code       protocol.TypeCode
+  attributes []protocol.RadiusAttribute
+  created    time.Time
+}
+
+// Identity returns identity peer provided in EAP-Response/Identity
+func (session *Session) Identity() string {
+  return session.identity
+}
+
+// Authenticator runs server side of EAP conversations
+//
+// Each Access-Challenge carries State attribute, that is used to find conversation next
+// Access-Request belongs to. Conversations, that are not finished within timeout, are dropped
+//
+// The last reply of every conversation is kept for timeout as well, so retransmitted Access-Request
+// (with the same State and EAP Identifier) gets the same reply, as required by RFC 3579 (section
+// 2.6.1), instead of ending the conversation. Access-Request, that arrives while the previous one of
+// the same conversation is still processed, is dropped without reply, as is EAP-Response with
+// unexpected EAP Identifier (RFC 3748, section 4.1)
+type Authenticator struct {
+  dictionary protocol.Dictionary
+  timeout    time.Duration
+  methods    []EapType
+  factories  map[EapType]MethodFactory
+
+  mutex      sync.Mutex
+  sessions   map[string]*Session
+  replies    map[string]cachedReply
+}
+
+// InitialiseAuthenticator initialises Authenticator
+//
+// Please note that you would need to call **AddMethod** at least once to initialise Authenticator
+// in full
+func InitialiseAuthenticator(dictionary protocol.Dictionary, timeout time.Duration) *Authenticator {
+  return &Authenticator {
+    dictionary: dictionary,
+    timeout:    timeout,
+    factories:  make(map[EapType]MethodFactory),
+    sessions:   make(map[string]*Session),
+    replies:    make(map[string]cachedReply),
+  }
+}
+
+// AddMethod adds EAP method Authenticator could offer to peer
+//
+// Methods are offered in the order they are added; peer could propose another method with Nak
+func (authenticator *Authenticator) AddMethod(eapType EapType, factory MethodFactory) {
+  if _, ok := authenticator.factories[eapType]; !ok {
+    authenticator.methods = append(authenticator.methods, eapType)
+  }
+  authenticator.factories[eapType] = factory
+}
+
+// Handler returns server.Handler, that could be set for AUTH socket of server.Runtime
+func (authenticator *Authenticator) Handler() server.Handler {
+  return func(request *server.Request) (protocol.TypeCode, []protocol.RadiusAttribute, error) {
+    return authenticator.HandleAccessRequest(request.Packet(), request.Secret())
+  }
+}
+
+// HandleAccessRequest processes Access-Request carrying EAP-Message and returns TypeCode &
+// attributes of the reply
+//
+// Reply attributes don't include Message-Authenticator, so reply should be signed by the caller
+// (server.Runtime does it for every request, that has Message-Authenticator)
+func (authenticator *Authenticator) HandleAccessRequest(request *protocol.RadiusPacket, secret string) (protocol.TypeCode, []protocol.RadiusAttribute, error) {
+  response, err := EapPacketFromRadiusPacket(request)
+  if err != nil {
+    return 0, nil, err
+  }
+
+  if response.Code() != Response {
+    return 0, nil, errors.New(fmt.Sprintf("unexpected EAP Code %d in Access-Request", response.Code()))
+  }
+
+  authenticator.expireSessions()
+
+  stateAttr := request.AttributeByName("State")
+  state     := stateAttr.Value()
+
+  if len(state) == 0 {
+    return authenticator.startSession(&response)
+  }
+
+  reply, session, err := authenticator.takeSession(state, response.ID())
+  if err != nil {
+    return 0, nil, err
+  }
+  if reply != nil {
+    return reply.code, reply.attributes, nil
+  }
+  if session == nil {
+    return authenticator.failure(response.ID())
+  }
+
+  code, attributes, err := authenticator.continueSession(session, &response, request, secret)
+  authenticator.finishSession(session, response.ID(), code, attributes, err)
+  return code, attributes, err
+}
+
+// continueSession processes EAP-Response, that belongs to given conversation
+func (authenticator *Authenticator) continueSession(session *Session, response *EapPacket, request *protocol.RadiusPacket, secret string) (protocol.TypeCode, []protocol.RadiusAttribute, error) {
+  if response.ID() != session.lastID {
+    return 0, nil, errors.New(fmt.Sprintf("EAP-Response has Identifier %d, %d is expected", response.ID(), session.lastID))
+  }
+
+  if response.Type() == Nak {
+    return authenticator.switchMethod(session, response)
+  }
+
+  if response.Type() != session.method.Type() {
+    authenticator.endSession(session)
+    return authenticator.failure(response.ID())
+  }
+
+  result, data, err := session.method.Process(session, response)
+  if err == nil && result == Continue {
+    return authenticator.challenge(session, data)
+  }
+
+  defer authenticator.endSession(session)
+  if err == nil && result == Succeeded {
+    return authenticator.success(session, response, request, secret)
+  }
+  return authenticator.failure(response.ID())
+}
+
+// startSession starts new EAP conversation with the first method configured
+func (authenticator *Authenticator) startSession(response *EapPacket) (protocol.TypeCode, []protocol.RadiusAttribute, error) {
+  identity, ok := response.Identity()
+  if !ok {
+    return authenticator.failure(response.ID())
+  }
+
+  if len(authenticator.methods) == 0 {
+    return 0, nil, errors.New("no EAP methods configured")
+  }
+
+  state, err := randomBytes(16)
+  if err != nil {
+    return 0, nil, err
+  }
+
+  session := &Session { identity: identity, state: state, lastID: response.ID(), created: time.Now() }
+  return authenticator.initiateMethod(session, authenticator.methods[0])
+}
+
+// switchMethod starts method peer proposed in Nak, if Authenticator supports it
+func (authenticator *Authenticator) switchMethod(session *Session, response *EapPacket) (protocol.TypeCode, []protocol.RadiusAttribute, error) {
+  session.close()
+
+  desiredTypes, _ := response.NakTypes()
+
+  for _, desiredType := range desiredTypes {
+    if _, ok := authenticator.factories[desiredType]; ok && !session.hasTried(desiredType) {
+      return authenticator.initiateMethod(session, desiredType)
+    }
+  }
+
+  authenticator.endSession(session)
+  return authenticator.failure(response.ID())
+}
+
+// initiateMethod creates new Method for the session and sends its first EAP-Request
+func (authenticator *Authenticator) initiateMethod(session *Session, eapType EapType) (protocol.TypeCode, []protocol.RadiusAttribute, error) {
+  session.method     = authenticator.factories[eapType]()
+  session.triedTypes = append(session.triedTypes, eapType)
+
+  data, err := session.method.Initiate(session)
+  if err != nil {
+    authenticator.endSession(session)
+    return authenticator.failure(session.lastID)
+  }
+
+  return authenticator.challenge(session, data)
+}
+
+// challenge stores session and creates Access-Challenge with next EAP-Request of the method
+func (authenticator *Authenticator) challenge(session *Session, data []uint8) (protocol.TypeCode, []protocol.RadiusAttribute, error) {
+  session.lastID++
+
+  eapRequest      := InitialiseEapPacket(Request, session.lastID, session.method.Type(), data)
+  attributes, err := EapMessageAttributes(&authenticator.dictionary, &eapRequest)
+  if err != nil {
+    return 0, nil, err
+  }
+
+  stateAttr, ok := protocol.CreateRadAttributeByName(&authenticator.dictionary, "State", &session.state)
+  if !ok {
+    return 0, nil, errors.New("State attribute is not found in dictionary")
+  }
+
+  authenticator.mutex.Lock()
+  authenticator.sessions[string(session.state)] = session
+  authenticator.mutex.Unlock()
+
+  return protocol.AccessChallenge, append(attributes, stateAttr), nil
+}
+
+// success creates Access-Accept with EAP-Success and keying material, if method derived it
+func (authenticator *Authenticator) success(session *Session, response *EapPacket, request *protocol.RadiusPacket, secret string) (protocol.TypeCode, []protocol.RadiusAttribute, error) {
+  eapSuccess      := CreateSuccess(response.ID())
+  attributes, err := EapMessageAttributes(&authenticator.dictionary, &eapSuccess)
+  if err != nil {
+    return 0, nil, err
+  }
+
+  if keyingMethod, ok := session.method.(KeyingMethod); ok {
+    mppeAttributes, err := authenticator.mppeKeyAttributes(keyingMethod.MSK(), request.Authenticator(), secret)
+    if err != nil {
+      return 0, nil, err
+    }
+    attributes = append(attributes, mppeAttributes...)
+  }
+
+  return protocol.AccessAccept, attributes, nil
+}
+
+// failure creates Access-Reject with EAP-Failure
+func (authenticator *Authenticator) failure(id uint8) (protocol.TypeCode, []protocol.RadiusAttribute, error) {
+  eapFailure      := CreateFailure(id)
+  attributes, err := EapMessageAttributes(&authenticator.dictionary, &eapFailure)
+  if err != nil {
+    return 0, nil, err
+  }
+
+  return protocol.AccessReject, attributes, nil
+}
+
+// mppeKeyAttributes creates MS-MPPE-Recv-Key & MS-MPPE-Send-Key attributes from MSK, as defined in
+// RFC 5216: first 32 octets are sent as receive key, next 32 octets - as send key
+func (authenticator *Authenticator) mppeKeyAttributes(msk, requestAuthenticator []uint8, secret string) ([]protocol.RadiusAttribute, error) {
+  if len(msk) < 64 {
+    return nil, errors.New("MSK is shorter than 64 octets")
+  }
+
+  var attributes []protocol.RadiusAttribute
+
+  secretBytes := []uint8(secret)
+  keys        := []struct {
+    vendorType uint8
+    key        []uint8
+  } {
+    { tools.MS_MPPE_RECV_KEY, msk[:32] },
+    { tools.MS_MPPE_SEND_KEY, msk[32:64] },
+  }
+
+  for _, mppeKey := range keys {
+    encryptedKey, err := tools.EncryptMPPEKey(&mppeKey.key, &requestAuthenticator, &secretBytes)
+    if err != nil {
+      return nil, err
+    }
+
+    vsaBytes := tools.VendorSpecificToBytes(tools.MICROSOFT_VENDOR_ID, mppeKey.vendorType, &encryptedKey)
+    vsaAttr, ok := protocol.CreateRadAttributeByID(&authenticator.dictionary, protocol.VENDOR_SPECIFIC_ID, &vsaBytes)
+    if !ok {
+      return nil, errors.New("Vendor-Specific attribute is not found in dictionary")
+    }
+    attributes = append(attributes, vsaAttr)
+  }
+
+  return attributes, nil
+}
+
+// takeSession returns the last reply of conversation with given State, if it was sent to
+// EAP-Response with given EAP Identifier, or session with that State, which is marked as busy until
+// **finishSession** is called; neither is returned for unknown State
+//
+// Error is returned for session, that is already busy, so retransmitted or concurrent
+// Access-Requests with the same State are not processed twice
+func (authenticator *Authenticator) takeSession(state []uint8, id uint8) (*cachedReply, *Session, error) {
+  authenticator.mutex.Lock()
+  defer authenticator.mutex.Unlock()
+
+  if reply, ok := authenticator.replies[string(state)]; ok && reply.id == id {
+    // Caller could append to attributes, e.g. Message-Authenticator, so cached ones are not shared
+    reply.attributes = append([]protocol.RadiusAttribute(nil), reply.attributes...)
+    return &reply, nil, nil
+  }
+
+  session, ok := authenticator.sessions[string(state)]
+  if !ok {
+    return nil, nil, nil
+  }
+  if session.busy {
+    return nil, nil, errors.New("EAP conversation is being processed, Access-Request is dropped")
+  }
+
+  session.busy = true
+  return nil, session, nil
+}
+
+// finishSession marks session as no longer busy and stores reply to EAP-Response with given EAP
+// Identifier as the last reply of conversation, unless request was dropped
+func (authenticator *Authenticator) finishSession(session *Session, id uint8, code protocol.TypeCode, attributes []protocol.RadiusAttribute, err error) {
+  authenticator.mutex.Lock()
+  defer authenticator.mutex.Unlock()
+
+  session.busy = false
+  if err != nil {
+    return
+  }
+
+  authenticator.replies[string(session.state)] = cachedReply {
+    id:         id,
+    code:       code,
+    attributes: append([]protocol.RadiusAttribute(nil), attributes...),
+    created:    time.Now(),
+  }
+}
+
+// endSession removes finished session from Authenticator and closes its Method
+func (authenticator *Authenticator) endSession(session *Session) {
+  authenticator.mutex.Lock()
+  delete(authenticator.sessions, string(session.state))
+  authenticator.mutex.Unlock()
+
+  session.close()
+}
+
+// expireSessions drops sessions, that were not finished within timeout, and replies, that were
+// cached for longer than timeout
+func (authenticator *Authenticator) expireSessions() {
+  authenticator.mutex.Lock()
+  defer authenticator.mutex.Unlock()
+
+  for state, session := range authenticator.sessions {
+    if !session.busy && time.Since(session.created) > authenticator.timeout {
+      delete(authenticator.sessions, state)
+      session.close()
+    }
+  }
+
+  for state, reply := range authenticator.replies {
+    if time.Since(reply.created) > authenticator.timeout {
+      delete(authenticator.replies, state)
+    }
+  }
+}
+
+// hasTried checks if method of given type was already started in this session
+func (session *Session) hasTried(eapType EapType) bool {
+  for _, triedType := range session.triedTypes {
+    if triedType == eapType {
+      return true
+    }
+  }
+  return false
+}
+
+// close closes Method of the session, if it implements io.Closer
+func (session *Session) close() {
+  if closer, ok := session.method.(io.Closer); ok {
+    closer.Close()
+  }
+}
+
+// randomBytes reads given number of bytes from crypto/rand
+func randomBytes(length int) ([]uint8, error) {
+  output := make([]uint8, length)
+
+  if _, err := rand.Read(output); err != nil {
+    return nil, err
+  }
+  return output, nil
+}
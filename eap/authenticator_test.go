@@ -0,0 +1,263 @@
+package eap
+
+import (
+  "net"
+  "testing"
+  "time"
+
+  "github.com/stretchr/testify/assert"
+
+  "github.com/MikhailMS/go-radius/client"
+  "github.com/MikhailMS/go-radius/protocol"
+  "github.com/MikhailMS/go-radius/server"
+  "github.com/MikhailMS/go-radius/tools"
+)
+
+func testPasswordLookup(identity string) ([]uint8, bool) {
+  if identity == "testing" {
+    return []uint8("password"), true
+  }
+  return nil, false
+}
+
+func startTestAuthenticator(t *testing.T, authenticator *Authenticator) client.Client {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+  allowedHosts  := map[string]string { "127.0.0.1": "secret" }
+
+  radServer := server.InitialiseServer(dictionary, allowedHosts, "127.0.0.1", 1, 2)
+  runtime   := server.InitialiseRuntime(&radServer)
+  runtime.SetHandler(protocol.AUTH, authenticator.Handler())
+
+  conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+  if err != nil {
+    t.Fatal(err)
+  }
+  go runtime.ServePacketConn(conn, protocol.AUTH)
+  t.Cleanup(func() { runtime.Close() })
+
+  radClient := client.InitialiseClient(dictionary, "127.0.0.1", "secret", 1, 2)
+  radClient.SetPort(protocol.AUTH, uint16(conn.LocalAddr().(*net.UDPAddr).Port))
+
+  return radClient
+}
+
+// exchangeEap sends EAP packet inside Access-Request (with State, if it is given) and returns reply
+// together with EAP packet it carries
+func exchangeEap(t *testing.T, radClient *client.Client, dictionary *protocol.Dictionary, eapPacket *EapPacket, state []uint8) (protocol.RadiusPacket, EapPacket) {
+  radPacket := radClient.CreateAuthRadiusPacket()
+
+  if len(state) > 0 {
+    stateAttr, _ := radClient.CreateAttributeByName("State", &state)
+    radPacket.SetAttributes([]protocol.RadiusAttribute { stateAttr })
+  }
+  SetEapMessage(dictionary, &radPacket, eapPacket)
+  radPacket.GenerateMessageAuthenticator(radClient.Secret())
+
+  replyBytes, err := radClient.SendAndReceivePacket(&radPacket)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  ok, err := radClient.VerifyReply(&radPacket, &replyBytes)
+  if !ok {
+    t.Fatal(err)
+  }
+
+  reply, _       := protocol.InitialiseRadiusPacketFromBytes(dictionary, &replyBytes)
+  eapReply, err  := EapPacketFromRadiusPacket(&reply)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  return reply, eapReply
+}
+
+func TestAuthenticatorMD5Challenge(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+
+  authenticator := InitialiseAuthenticator(dictionary, time.Minute)
+  authenticator.AddMethod(MD5Challenge, MD5ChallengeMethod(testPasswordLookup))
+
+  radClient := startTestAuthenticator(t, authenticator)
+
+  for _, testCase := range []struct {
+    password     []uint8
+    expectedCode protocol.TypeCode
+    expectedEap  EapCode
+  } {
+    { []uint8("password"), protocol.AccessAccept, Success },
+    { []uint8("wrong"),    protocol.AccessReject, Failure },
+  } {
+    identity        := CreateIdentityResponse(1, "testing")
+    reply, eapReply := exchangeEap(t, &radClient, &dictionary, &identity, nil)
+    assert.Equal(t, protocol.AccessChallenge, reply.Code(),     "Identity is not answered with Access-Challenge!")
+    assert.Equal(t, MD5Challenge,             eapReply.Type(),  "EAP-MD5 is not started!")
+    assert.Equal(t, uint8(2),                 eapReply.ID(),    "EAP Identifier is not incremented!")
+
+    stateAttr := reply.AttributeByName("State")
+    challenge := eapReply.Data()[1:17]
+    value     := tools.ChapResponse(eapReply.ID(), &testCase.password, &challenge)
+
+    md5Response     := InitialiseEapPacket(Response, eapReply.ID(), MD5Challenge, append([]uint8{ 16 }, value...))
+    reply, eapReply  = exchangeEap(t, &radClient, &dictionary, &md5Response, stateAttr.Value())
+    assert.Equal(t, testCase.expectedCode, reply.Code(),    "Reply code is not correct!")
+    assert.Equal(t, testCase.expectedEap,  eapReply.Code(), "EAP code is not correct!")
+
+    // Conversation is finished, so State could only be used by retransmission of the last request
+    nextResponse   := InitialiseEapPacket(Response, eapReply.ID() + 1, MD5Challenge, append([]uint8{ 16 }, value...))
+    reply, eapReply = exchangeEap(t, &radClient, &dictionary, &nextResponse, stateAttr.Value())
+    assert.Equal(t, protocol.AccessReject, reply.Code(),    "Finished conversation is continued!")
+    assert.Equal(t, Failure,               eapReply.Code(), "Finished conversation is continued!")
+  }
+}
+
+func TestAuthenticatorNakToGTC(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+
+  authenticator := InitialiseAuthenticator(dictionary, time.Minute)
+  authenticator.AddMethod(MD5Challenge, MD5ChallengeMethod(testPasswordLookup))
+  authenticator.AddMethod(GTC,          GTCMethod(testPasswordLookup, "Password: "))
+
+  radClient := startTestAuthenticator(t, authenticator)
+
+  identity        := CreateIdentityResponse(1, "testing")
+  reply, eapReply := exchangeEap(t, &radClient, &dictionary, &identity, nil)
+  assert.Equal(t, MD5Challenge, eapReply.Type(), "EAP-MD5 is not offered first!")
+
+  nak            := CreateNak(eapReply.ID(), GTC)
+  state          := reply.AttributeByName("State")
+  reply, eapReply = exchangeEap(t, &radClient, &dictionary, &nak, state.Value())
+  assert.Equal(t, protocol.AccessChallenge, reply.Code(),    "Nak is not answered with Access-Challenge!")
+  assert.Equal(t, GTC,                      eapReply.Type(),  "EAP-GTC is not started after Nak!")
+  assert.Equal(t, []uint8("Password: "),    eapReply.Data(),  "EAP-GTC prompt is not correct!")
+
+  gtcResponse    := InitialiseEapPacket(Response, eapReply.ID(), GTC, []uint8("password"))
+  state           = reply.AttributeByName("State")
+  reply, eapReply = exchangeEap(t, &radClient, &dictionary, &gtcResponse, state.Value())
+  assert.Equal(t, protocol.AccessAccept, reply.Code(),    "Valid EAP-GTC response is not accepted!")
+  assert.Equal(t, Success,               eapReply.Code(), "EAP-Success is not sent!")
+}
+
+func TestAuthenticatorRetransmission(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+
+  authenticator := InitialiseAuthenticator(dictionary, time.Minute)
+  authenticator.AddMethod(MD5Challenge, MD5ChallengeMethod(testPasswordLookup))
+  authenticator.AddMethod(GTC,          GTCMethod(testPasswordLookup, "Password: "))
+
+  radClient := startTestAuthenticator(t, authenticator)
+
+  identity        := CreateIdentityResponse(1, "testing")
+  reply, eapReply := exchangeEap(t, &radClient, &dictionary, &identity, nil)
+  state           := reply.AttributeByName("State")
+  nak             := CreateNak(eapReply.ID(), GTC)
+
+  // Retransmitted Access-Request gets the same Access-Challenge, so conversation is not ended
+  reply, eapReply  = exchangeEap(t, &radClient, &dictionary, &nak, state.Value())
+  again, eapAgain := exchangeEap(t, &radClient, &dictionary, &nak, state.Value())
+  state            = reply.AttributeByName("State")
+  againState      := again.AttributeByName("State")
+  assert.Equal(t, protocol.AccessChallenge, again.Code(),       "Retransmitted Access-Request is not answered with Access-Challenge!")
+  assert.Equal(t, eapReply.ID(),            eapAgain.ID(),      "Retransmitted Access-Request is not answered with the same EAP-Request!")
+  assert.Equal(t, eapReply.Type(),          eapAgain.Type(),    "Retransmitted Access-Request is not answered with the same EAP-Request!")
+  assert.Equal(t, state.Value(),            againState.Value(), "Retransmitted Access-Request is not answered with the same State!")
+
+  gtcResponse := InitialiseEapPacket(Response, eapReply.ID(), GTC, []uint8("password"))
+  for i := 0; i < 2; i++ {
+    reply, eapReply = exchangeEap(t, &radClient, &dictionary, &gtcResponse, state.Value())
+    assert.Equal(t, protocol.AccessAccept, reply.Code(),    "Retransmitted Access-Request is not accepted!")
+    assert.Equal(t, Success,               eapReply.Code(), "EAP-Success is not sent again!")
+  }
+}
+
+func TestAuthenticatorUnknownState(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+
+  authenticator := InitialiseAuthenticator(dictionary, time.Minute)
+  authenticator.AddMethod(GTC, GTCMethod(testPasswordLookup, "Password: "))
+
+  state        := []uint8("unknown")
+  stateAttr, _ := protocol.CreateRadAttributeByName(&dictionary, "State", &state)
+  gtcResponse  := InitialiseEapPacket(Response, 2, GTC, []uint8("password"))
+
+  radPacket := protocol.InitialiseRadiusPacket(protocol.AccessRequest)
+  radPacket.SetAttributes([]protocol.RadiusAttribute { stateAttr })
+  SetEapMessage(&dictionary, &radPacket, &gtcResponse)
+
+  code, _, err := authenticator.HandleAccessRequest(&radPacket, "secret")
+  assert.Equal(t, nil,                   err,  "Request with unknown State is dropped!")
+  assert.Equal(t, protocol.AccessReject, code, "Request with unknown State is not rejected!")
+}
+
+// handleTestEap passes EAP packet inside Access-Request (with State, if it is given) directly to
+// Authenticator
+func handleTestEap(authenticator *Authenticator, dictionary *protocol.Dictionary, eapPacket *EapPacket, state []uint8) (protocol.TypeCode, []protocol.RadiusAttribute, error) {
+  radPacket := protocol.InitialiseRadiusPacket(protocol.AccessRequest)
+
+  if len(state) > 0 {
+    stateAttr, _ := protocol.CreateRadAttributeByName(dictionary, "State", &state)
+    radPacket.SetAttributes([]protocol.RadiusAttribute { stateAttr })
+  }
+  SetEapMessage(dictionary, &radPacket, eapPacket)
+
+  return authenticator.HandleAccessRequest(&radPacket, "secret")
+}
+
+func TestAuthenticatorDropsUnexpectedRequests(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+
+  // Password lookup blocks, until the test lets it go
+  entered := make(chan struct{}, 1)
+  release := make(chan struct{})
+  lookup  := func(identity string) ([]uint8, bool) {
+    entered <- struct{}{}
+    <-release
+    return testPasswordLookup(identity)
+  }
+
+  authenticator := InitialiseAuthenticator(dictionary, time.Minute)
+  authenticator.AddMethod(GTC, GTCMethod(lookup, "Password: "))
+
+  identity              := CreateIdentityResponse(1, "testing")
+  code, attributes, err := handleTestEap(authenticator, &dictionary, &identity, nil)
+  assert.Equal(t, nil,                      err,  "Identity is not answered!")
+  assert.Equal(t, protocol.AccessChallenge, code, "Identity is not answered with Access-Challenge!")
+
+  var state []uint8
+  for _, attr := range attributes {
+    if attr.Name() == "State" {
+      state = attr.Value()
+    }
+  }
+
+  // EAP-Response with unexpected EAP Identifier is discarded, conversation goes on
+  staleResponse := InitialiseEapPacket(Response, 1, GTC, []uint8("password"))
+  _, _, err      = handleTestEap(authenticator, &dictionary, &staleResponse, state)
+  assert.NotEqual(t, nil, err, "EAP-Response with unexpected EAP Identifier is answered!")
+
+  // Access-Request, that arrives while the previous one is processed, is dropped
+  gtcResponse := InitialiseEapPacket(Response, 2, GTC, []uint8("password"))
+  codes       := make(chan protocol.TypeCode, 1)
+  go func() {
+    code, _, _ := handleTestEap(authenticator, &dictionary, &gtcResponse, state)
+    codes <- code
+  }()
+
+  <-entered
+  _, _, err = handleTestEap(authenticator, &dictionary, &gtcResponse, state)
+  assert.NotEqual(t, nil, err, "Access-Request of busy conversation is not dropped!")
+
+  close(release)
+  assert.Equal(t, protocol.AccessAccept, <-codes, "Conversation is not continued after unexpected requests!")
+
+  // Once processed, the same Access-Request is answered from cache
+  code, _, err = handleTestEap(authenticator, &dictionary, &gtcResponse, state)
+  assert.Equal(t, nil,                   err,  "Retransmitted Access-Request is dropped!")
+  assert.Equal(t, protocol.AccessAccept, code, "Retransmitted Access-Request is not accepted!")
+}
@@ -0,0 +1,307 @@
+// EAP (RFC 3748) implementation and its transport inside RADIUS packets (RFC 3579)
+package eap
+
+import (
+  "encoding/binary"
+  "errors"
+  "fmt"
+
+  "github.com/MikhailMS/go-radius/protocol"
+)
+
+// MAX_ATTRIBUTE_VALUE_LENGTH is the maximum length of RADIUS attribute value, so EAP packet longer
+// than that is split across multiple EAP-Message attributes
+const MAX_ATTRIBUTE_VALUE_LENGTH = 253
+
+// EapCode represents Code of EAP packet as defined in RFC 3748
+type EapCode uint8
+
+const (
+  // Request = 1
+  Request EapCode = 1
+  // Response = 2
+  Response EapCode = 2
+  // Success = 3
+  Success EapCode = 3
+  // Failure = 4
+  Failure EapCode = 4
+)
+
+// EapType represents Type of EAP Request/Response packet
+type EapType uint8
+
+const (
+  // Identity = 1, RFC 3748
+  Identity EapType = 1
+  // Notification = 2, RFC 3748
+  Notification EapType = 2
+  // Nak = 3, RFC 3748 (legacy Nak, Response only)
+  Nak EapType = 3
+  // MD5Challenge = 4, RFC 3748
+  MD5Challenge EapType = 4
+  // OTP = 5, RFC 3748
+  OTP EapType = 5
+  // GTC = 6, RFC 3748
+  GTC EapType = 6
+  // TLS = 13, RFC 5216
+  TLS EapType = 13
+  // TTLS = 21, RFC 5281
+  TTLS EapType = 21
+  // PEAP = 25
+  PEAP EapType = 25
+  // MSCHAPv2 = 26
+  MSCHAPv2 EapType = 26
+  // TLV = 33, PEAP extensions
+  TLV EapType = 33
+)
+
+// EapPacket represents EAP packet
+type EapPacket struct {
+  code    EapCode
+  id      uint8
+  eapType EapType
+  data    []uint8
+}
+
+// InitialiseEapPacket initialises EAP Request or Response packet
+func InitialiseEapPacket(code EapCode, id uint8, eapType EapType, data []uint8) EapPacket {
+  return EapPacket { code, id, eapType, data }
+}
+
+// InitialiseEapPacketFromBytes initialises EAP packet from raw bytes
+func InitialiseEapPacketFromBytes(bytes []uint8) (EapPacket, error) {
+  /*
+   *  0                   1                   2                   3
+      0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+     +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+     |     Code      |  Identifier   |            Length             |
+     +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+     |     Type      |  Type-Data ...
+     +-+-+-+-+-+-+-+-+-+-+-+-+-+-
+   * Taken from https://tools.ietf.org/html/rfc3748#section-4
+  */
+  if len(bytes) < 4 {
+    return EapPacket{}, errors.New("EAP packet is shorter than EAP header")
+  }
+
+  code   := EapCode(bytes[0])
+  length := int(binary.BigEndian.Uint16(bytes[2:4]))
+
+  if length < 4 || length > len(bytes) {
+    return EapPacket{}, errors.New(fmt.Sprintf("invalid EAP packet length: %d", length))
+  }
+
+  switch code {
+    case Success, Failure:
+      return EapPacket { code, bytes[1], 0, []uint8{} }, nil
+    case Request, Response:
+      if length < 5 {
+        return EapPacket{}, errors.New("EAP Request/Response has no Type")
+      }
+
+      data := make([]uint8, length - 5)
+      copy(data, bytes[5:length])
+
+      return EapPacket { code, bytes[1], EapType(bytes[4]), data }, nil
+    default:
+      return EapPacket{}, errors.New(fmt.Sprintf("invalid EAP Code: %d", code))
+  }
+}
+
+// CreateIdentityRequest creates EAP-Request/Identity packet
+func CreateIdentityRequest(id uint8) EapPacket {
+  return EapPacket { Request, id, Identity, []uint8{} }
+}
+
+// CreateIdentityResponse creates EAP-Response/Identity packet
+func CreateIdentityResponse(id uint8, identity string) EapPacket {
+  return EapPacket { Response, id, Identity, []uint8(identity) }
+}
+
+// CreateNak creates EAP-Response/Nak packet, that lists authentication types peer would like to use
+func CreateNak(id uint8, desiredTypes ...EapType) EapPacket {
+  var data []uint8
+
+  for _, desiredType := range desiredTypes {
+    data = append(data, uint8(desiredType))
+  }
+  if len(data) == 0 {
+    // Zero means that peer has no alternative to propose
+    data = []uint8{ 0 }
+  }
+
+  return EapPacket { Response, id, Nak, data }
+}
+
+// CreateSuccess creates EAP-Success packet
+func CreateSuccess(id uint8) EapPacket {
+  return EapPacket { Success, id, 0, []uint8{} }
+}
+
+// CreateFailure creates EAP-Failure packet
+func CreateFailure(id uint8) EapPacket {
+  return EapPacket { Failure, id, 0, []uint8{} }
+}
+
+// Code returns EapPacket code
+func (eapPacket *EapPacket) Code() EapCode {
+  return eapPacket.code
+}
+
+// ID returns EapPacket identifier
+func (eapPacket *EapPacket) ID() uint8 {
+  return eapPacket.id
+}
+
+// Type returns EapPacket type
+//
+// Success & Failure packets have no type, so 0 is returned for them
+func (eapPacket *EapPacket) Type() EapType {
+  return eapPacket.eapType
+}
+
+// Data returns EapPacket Type-Data
+func (eapPacket *EapPacket) Data() []uint8 {
+  return eapPacket.data
+}
+
+// Identity returns identity carried by EAP-Response/Identity packet
+func (eapPacket *EapPacket) Identity() (string, bool) {
+  if eapPacket.code != Response || eapPacket.eapType != Identity {
+    return "", false
+  }
+  return string(eapPacket.data), true
+}
+
+// NakTypes returns authentication types proposed by peer in EAP-Response/Nak packet
+func (eapPacket *EapPacket) NakTypes() ([]EapType, bool) {
+  if eapPacket.code != Response || eapPacket.eapType != Nak {
+    return nil, false
+  }
+
+  var desiredTypes []EapType
+  for _, desiredType := range eapPacket.data {
+    if desiredType != 0 {
+      desiredTypes = append(desiredTypes, EapType(desiredType))
+    }
+  }
+  return desiredTypes, true
+}
+
+// ToBytes converts EapPacket into bytes
+func (eapPacket *EapPacket) ToBytes() []uint8 {
+  var output []uint8
+
+  if eapPacket.code == Success || eapPacket.code == Failure {
+    output = make([]uint8, 4)
+  } else {
+    output = make([]uint8, 5, 5 + len(eapPacket.data))
+    output[4] = uint8(eapPacket.eapType)
+    output    = append(output, eapPacket.data...)
+  }
+
+  output[0] = uint8(eapPacket.code)
+  output[1] = eapPacket.id
+  binary.BigEndian.PutUint16(output[2:4], uint16(len(output)))
+
+  return output
+}
+
+// EapMessageAttributes splits EapPacket into EAP-Message attributes, each carrying no more than
+// 253 octets
+func EapMessageAttributes(dictionary *protocol.Dictionary, eapPacket *EapPacket) ([]protocol.RadiusAttribute, error) {
+  var attributes []protocol.RadiusAttribute
+
+  eapBytes := eapPacket.ToBytes()
+
+  for len(eapBytes) > 0 {
+    chunkLength := len(eapBytes)
+    if chunkLength > MAX_ATTRIBUTE_VALUE_LENGTH {
+      chunkLength = MAX_ATTRIBUTE_VALUE_LENGTH
+    }
+
+    chunk := eapBytes[:chunkLength]
+    attr, ok := protocol.CreateRadAttributeByID(dictionary, protocol.EAP_MESSAGE_ID, &chunk)
+    if !ok {
+      return nil, errors.New("EAP-Message attribute is not found in dictionary")
+    }
+
+    attributes = append(attributes, attr)
+    eapBytes    = eapBytes[chunkLength:]
+  }
+
+  return attributes, nil
+}
+
+// SetEapMessage adds EapPacket to RadiusPacket as a sequence of EAP-Message attributes
+//
+// Any EAP-Message attributes already present in RadiusPacket are replaced; Message-Authenticator
+// is added (zeroed) if RadiusPacket has none, as RFC 3579 requires it in every packet carrying
+// EAP-Message, so caller would need to generate it before sending RadiusPacket
+func SetEapMessage(dictionary *protocol.Dictionary, radPacket *protocol.RadiusPacket, eapPacket *EapPacket) error {
+  eapAttributes, err := EapMessageAttributes(dictionary, eapPacket)
+  if err != nil {
+    return err
+  }
+
+  var attributes []protocol.RadiusAttribute
+  hasMsgAuth := false
+
+  for _, attr := range radPacket.Attributes() {
+    if attr.ID() == protocol.EAP_MESSAGE_ID {
+      continue
+    }
+    if attr.ID() == protocol.MESSAGE_AUTHENTICATOR_ID {
+      hasMsgAuth = true
+    }
+    attributes = append(attributes, attr)
+  }
+  attributes = append(attributes, eapAttributes...)
+
+  if !hasMsgAuth {
+    msgAuthBytes := make([]uint8, 16)
+
+    msgAuthAttr, ok := protocol.CreateRadAttributeByID(dictionary, protocol.MESSAGE_AUTHENTICATOR_ID, &msgAuthBytes)
+    if !ok {
+      return errors.New("Message-Authenticator attribute is not found in dictionary")
+    }
+    attributes = append(attributes, msgAuthAttr)
+  }
+
+  radPacket.SetAttributes(attributes)
+  return nil
+}
+
+// EapPacketFromRadiusPacket reassembles EapPacket from all EAP-Message attributes of RadiusPacket
+//
+// As required by RFC 3579, RadiusPacket without Message-Authenticator is rejected. Please note that
+// this function only checks Message-Authenticator presence, its value should be verified
+// separately (Server Runtime does it for every request)
+func EapPacketFromRadiusPacket(radPacket *protocol.RadiusPacket) (EapPacket, error) {
+  var eapBytes []uint8
+
+  for _, attr := range radPacket.Attributes() {
+    if attr.ID() == protocol.EAP_MESSAGE_ID {
+      eapBytes = append(eapBytes, attr.Value()...)
+    }
+  }
+
+  if len(eapBytes) == 0 {
+    return EapPacket{}, errors.New("EAP-Message attribute not found in packet")
+  }
+
+  if _, err := radPacket.MessageAuthenticator(); err != nil {
+    return EapPacket{}, errors.New("packet with EAP-Message has no Message-Authenticator")
+  }
+
+  eapPacket, err := InitialiseEapPacketFromBytes(eapBytes)
+  if err != nil {
+    return EapPacket{}, err
+  }
+
+  if int(binary.BigEndian.Uint16(eapBytes[2:4])) != len(eapBytes) {
+    return EapPacket{}, errors.New("EAP packet length does not match length of EAP-Message attributes")
+  }
+
+  return eapPacket, nil
+}
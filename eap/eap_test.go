@@ -0,0 +1,87 @@
+package eap
+
+import (
+  "testing"
+
+  "github.com/stretchr/testify/assert"
+
+  "github.com/MikhailMS/go-radius/protocol"
+)
+
+func TestEapPacketToBytes(t *testing.T) {
+  expectedBytes := []uint8 { 2, 7, 0, 12, 1, 116, 101, 115, 116, 105, 110, 103 }
+
+  eapPacket := CreateIdentityResponse(7, "testing")
+  assert.Equal(t, expectedBytes, eapPacket.ToBytes(), "EAP packet was not converted to correct bytes!")
+
+  success := CreateSuccess(7)
+  assert.Equal(t, []uint8 { 3, 7, 0, 4 }, success.ToBytes(), "EAP-Success was not converted to correct bytes!")
+}
+
+func TestInitialiseEapPacketFromBytes(t *testing.T) {
+  eapBytes := []uint8 { 2, 7, 0, 12, 1, 116, 101, 115, 116, 105, 110, 103 }
+
+  eapPacket, err := InitialiseEapPacketFromBytes(eapBytes)
+  assert.Equal(t, nil, err, "EAP packet is not parsed!")
+
+  identity, ok := eapPacket.Identity()
+  assert.Equal(t, true,      ok,       "EAP packet is not Identity Response!")
+  assert.Equal(t, "testing", identity, "EAP identity is not correct!")
+}
+
+func TestInitialiseEapPacketFromBytesError(t *testing.T) {
+  _, err := InitialiseEapPacketFromBytes([]uint8 { 2, 7, 0, 20, 1 })
+  assert.Equal(t, "invalid EAP packet length: 20", err.Error(), "Malformed EAP packet is parsed!")
+
+  _, err = InitialiseEapPacketFromBytes([]uint8 { 9, 7, 0, 4 })
+  assert.Equal(t, "invalid EAP Code: 9", err.Error(), "EAP packet with invalid code is parsed!")
+}
+
+func TestNakTypes(t *testing.T) {
+  nak := CreateNak(3, MD5Challenge, GTC)
+
+  desiredTypes, ok := nak.NakTypes()
+  assert.Equal(t, true,                             ok,           "EAP packet is not Nak!")
+  assert.Equal(t, []EapType { MD5Challenge, GTC }, desiredTypes, "Nak types are not correct!")
+}
+
+func TestEapMessageFragmentation(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+
+  data := make([]uint8, 600)
+  for i := range data {
+    data[i] = uint8(i)
+  }
+  eapPacket := InitialiseEapPacket(Request, 5, TLS, data)
+
+  radPacket := protocol.InitialiseRadiusPacket(protocol.AccessChallenge)
+  err       := SetEapMessage(&dictionary, &radPacket, &eapPacket)
+  assert.Equal(t, nil, err, "EAP-Message is not set!")
+
+  // 605 octets of EAP packet require 3 EAP-Message attributes, plus Message-Authenticator
+  assert.Equal(t, 4, len(radPacket.Attributes()), "EAP packet is not fragmented correctly!")
+
+  radPacket.GenerateMessageAuthenticator("secret")
+  radBytes, _ := radPacket.ToBytes()
+
+  receivedPacket, _ := protocol.InitialiseRadiusPacketFromBytes(&dictionary, &radBytes)
+  reassembled, err  := EapPacketFromRadiusPacket(&receivedPacket)
+  assert.Equal(t, nil,       err,                "EAP packet is not reassembled!")
+  assert.Equal(t, eapPacket, reassembled,        "Reassembled EAP packet is not same!")
+  assert.Equal(t, nil,       receivedPacket.VerifyMessageAuthenticator("secret", nil), "Message-Authenticator is not valid!")
+}
+
+func TestEapPacketFromRadiusPacketWoMessageAuthenticator(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+
+  eapPacket        := CreateIdentityResponse(1, "testing")
+  eapAttributes, _ := EapMessageAttributes(&dictionary, &eapPacket)
+
+  radPacket := protocol.InitialiseRadiusPacket(protocol.AccessRequest)
+  radPacket.SetAttributes(eapAttributes)
+
+  _, err := EapPacketFromRadiusPacket(&radPacket)
+  assert.Equal(t, "packet with EAP-Message has no Message-Authenticator", err.Error(), "EAP packet without Message-Authenticator is accepted!")
+}
@@ -0,0 +1,42 @@
+package eap
+
+import (
+  "crypto/subtle"
+)
+
+// gtcMethod is server side of EAP-GTC (RFC 3748, section 5.6)
+type gtcMethod struct {
+  lookup PasswordLookup
+  prompt string
+}
+
+// GTCMethod returns MethodFactory of EAP-GTC method, that shows prompt to peer and compares its
+// response with cleartext password returned by lookup
+func GTCMethod(lookup PasswordLookup, prompt string) MethodFactory {
+  return func() Method {
+    return &gtcMethod { lookup: lookup, prompt: prompt }
+  }
+}
+
+// Type returns EAP Type of the method
+func (method *gtcMethod) Type() EapType {
+  return GTC
+}
+
+// Initiate returns Type-Data of EAP-Request/GTC, which is prompt shown to peer
+func (method *gtcMethod) Initiate(session *Session) ([]uint8, error) {
+  return []uint8(method.prompt), nil
+}
+
+// Process verifies EAP-Response/GTC, which carries response (password) in clear
+func (method *gtcMethod) Process(session *Session, response *EapPacket) (MethodResult, []uint8, error) {
+  password, ok := method.lookup(session.Identity())
+  if !ok {
+    return Failed, nil, nil
+  }
+
+  if subtle.ConstantTimeCompare(password, response.Data()) != 1 {
+    return Failed, nil, nil
+  }
+  return Succeeded, nil, nil
+}
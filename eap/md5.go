@@ -0,0 +1,89 @@
+package eap
+
+import (
+  "crypto/subtle"
+  "errors"
+
+  "github.com/MikhailMS/go-radius/tools"
+)
+
+// md5ChallengeMethod is server side of EAP-MD5 (RFC 3748, section 5.4)
+type md5ChallengeMethod struct {
+  lookup    PasswordLookup
+  challenge []uint8
+}
+
+// MD5ChallengeMethod returns MethodFactory of EAP-MD5 method, that verifies peer against cleartext
+// password returned by lookup
+func MD5ChallengeMethod(lookup PasswordLookup) MethodFactory {
+  return func() Method {
+    return &md5ChallengeMethod { lookup: lookup }
+  }
+}
+
+// Type returns EAP Type of the method
+func (method *md5ChallengeMethod) Type() EapType {
+  return MD5Challenge
+}
+
+// Initiate returns Type-Data of EAP-Request/MD5-Challenge: Value-Size followed by random challenge
+func (method *md5ChallengeMethod) Initiate(session *Session) ([]uint8, error) {
+  challenge, err := randomBytes(16)
+  if err != nil {
+    return nil, err
+  }
+  method.challenge = challenge
+
+  return append([]uint8{ uint8(len(challenge)) }, challenge...), nil
+}
+
+// Process verifies EAP-Response/MD5-Challenge, which value is calculated the same way as CHAP
+// response with EAP Identifier used as CHAP identifier
+func (method *md5ChallengeMethod) Process(session *Session, response *EapPacket) (MethodResult, []uint8, error) {
+  data := response.Data()
+  if len(data) < 17 || data[0] != 16 {
+    return Failed, nil, errors.New("malformed EAP-Response/MD5-Challenge")
+  }
+
+  password, ok := method.lookup(session.Identity())
+  if !ok {
+    return Failed, nil, nil
+  }
+
+  expected := tools.ChapResponse(response.ID(), &password, &method.challenge)
+  if subtle.ConstantTimeCompare(expected, data[1:17]) != 1 {
+    return Failed, nil, nil
+  }
+  return Succeeded, nil, nil
+}
+
+// md5ChallengePeer is peer side of EAP-MD5
+type md5ChallengePeer struct {
+  password []uint8
+}
+
+// MD5ChallengePeer returns PeerMethodFactory of EAP-MD5 method, that answers challenge with given
+// password
+func MD5ChallengePeer(password []uint8) PeerMethodFactory {
+  return func() PeerMethod {
+    return &md5ChallengePeer { password: password }
+  }
+}
+
+// Type returns EAP Type of the method
+func (peer *md5ChallengePeer) Type() EapType {
+  return MD5Challenge
+}
+
+// Process answers EAP-Request/MD5-Challenge with Value-Size followed by CHAP response
+func (peer *md5ChallengePeer) Process(request *EapPacket) ([]uint8, error) {
+  data := request.Data()
+  if len(data) < 1 || data[0] == 0 || len(data) < 1 + int(data[0]) {
+    return nil, errors.New("malformed EAP-Request/MD5-Challenge")
+  }
+
+  challenge := data[1:1 + int(data[0])]
+  response  := tools.ChapResponse(request.ID(), &peer.password, &challenge)
+
+  return append([]uint8{ uint8(len(response)) }, response...), nil
+}
@@ -0,0 +1,606 @@
+package eap
+
+import (
+  "bytes"
+  "crypto/hmac"
+  "crypto/sha1"
+  "crypto/subtle"
+  "crypto/tls"
+  "encoding/binary"
+  "encoding/hex"
+  "errors"
+  "fmt"
+  "strings"
+
+  "github.com/MikhailMS/go-radius/tools"
+)
+
+// Opcodes of EAP-MSCHAPv2 packets (draft-kamath-pppext-eap-mschapv2)
+const (
+  msChapV2Challenge uint8 = 1
+  msChapV2Response  uint8 = 2
+  msChapV2Success   uint8 = 3
+  msChapV2Failure   uint8 = 4
+)
+
+// Types of PEAP TLVs (MS-PEAP, section 2.2.8); TLVs marked as mandatory have tlvMandatory bit set
+const (
+  tlvMandatory     uint16 = 0x8000
+  tlvTypeMask      uint16 = 0x3FFF
+  tlvResult        uint16 = 3
+  tlvCryptoBinding uint16 = 12
+
+  tlvResultSuccess uint16 = 1
+  tlvResultFailure uint16 = 2
+)
+
+// MSCHAPV2_SERVER_NAME is Name sent in EAP-MSCHAPv2 Challenge
+const MSCHAPV2_SERVER_NAME = "go-radius"
+
+type peapPhase int
+
+const (
+  peapHandshake peapPhase = iota
+  peapIdentity
+  peapChallenge
+  peapSuccess
+  peapFailure
+  peapResult
+)
+
+// peapMethod is server side of PEAPv0 with inner EAP-MSCHAPv2 (MS-PEAP)
+type peapMethod struct {
+  config        *tls.Config
+  lookup        PasswordLookup
+  tunnel        *tlsTunnel
+  fragments     tlsFragments
+  phase         peapPhase
+
+  identity      string
+  msChapID      uint8
+  authChallenge []uint8
+  isk           []uint8
+  nonce         []uint8
+  msk           []uint8
+}
+
+// PEAPMethod returns MethodFactory of PEAPv0 method, that authenticates peer inside TLS tunnel with
+// EAP-MSCHAPv2 against cleartext password returned by lookup
+//
+// PEAP is not defined over TLS 1.3 by MS-PEAP, so MaxVersion of config is lowered to TLS 1.2
+func PEAPMethod(config *tls.Config, lookup PasswordLookup) MethodFactory {
+  return func() Method {
+    return &peapMethod { config: config, lookup: lookup }
+  }
+}
+
+// Type returns EAP Type of the method
+func (method *peapMethod) Type() EapType {
+  return PEAP
+}
+
+// Initiate starts TLS server and returns Type-Data of PEAP Start packet
+func (method *peapMethod) Initiate(session *Session) ([]uint8, error) {
+  method.tunnel = newServerTLSTunnel(tunneledTLSConfig(method.config))
+  if _, err := method.tunnel.start(method.tunnel.handshakeAndRead); err != nil {
+    return nil, err
+  }
+
+  return []uint8{ tlsFlagStart }, nil
+}
+
+// Process handles PEAP response: during TLS handshake it works as EAP-TLS, afterwards it decrypts
+// inner EAP packet and replies to it
+func (method *peapMethod) Process(session *Session, response *EapPacket) (MethodResult, []uint8, error) {
+  next, message, err := method.fragments.handle(response.Data())
+  if err != nil {
+    return Failed, nil, err
+  }
+  if next != nil {
+    return Continue, next, nil
+  }
+
+  if method.phase == peapHandshake {
+    if len(message) == 0 {
+      // Peer acknowledged final flight of TLS handshake, so inner authentication could start
+      if !method.tunnel.handshaken {
+        return Failed, nil, errors.New("unexpected PEAP acknowledgement")
+      }
+      method.phase = peapIdentity
+      return method.sendInner([]uint8{ uint8(Identity) })
+    }
+
+    output, err := method.tunnel.step(message)
+    if err != nil {
+      return Failed, nil, err
+    }
+    if len(output) == 0 {
+      return Failed, nil, errors.New("TLS handshake produced no reply")
+    }
+
+    method.fragments.send(output)
+    return Continue, method.fragments.next(), nil
+  }
+
+  if len(message) == 0 {
+    return Failed, nil, errors.New("unexpected PEAP acknowledgement")
+  }
+  if _, err := method.tunnel.step(message); err != nil {
+    return Failed, nil, err
+  }
+
+  inner := method.tunnel.takeReceived()
+  if len(inner) == 0 {
+    return Failed, nil, errors.New("PEAP response carries no inner EAP packet")
+  }
+
+  return method.processInner(session, inner)
+}
+
+// MSK returns Master Session Key derived from TLS session and, if crypto binding is used, from
+// inner EAP-MSCHAPv2 keys
+func (method *peapMethod) MSK() []uint8 {
+  return method.msk
+}
+
+// Close stops TLS server
+func (method *peapMethod) Close() error {
+  if method.tunnel == nil {
+    return nil
+  }
+  return method.tunnel.Close()
+}
+
+// processInner handles decrypted inner EAP packet
+//
+// PEAPv0 sends inner EAP packets without EAP header (just Type & Type-Data), except for EAP-TLV
+// packets, which are sent in full
+func (method *peapMethod) processInner(session *Session, inner []uint8) (MethodResult, []uint8, error) {
+  switch method.phase {
+    case peapIdentity:
+      if EapType(inner[0]) != Identity {
+        return Failed, nil, errors.New("unexpected inner EAP packet, Identity is expected")
+      }
+      method.identity = string(inner[1:])
+      return method.sendChallenge(session)
+    case peapChallenge:
+      return method.verifyResponse(inner)
+    case peapSuccess:
+      if !bytes.Equal(inner, []uint8{ uint8(MSCHAPv2), msChapV2Success }) {
+        return Failed, nil, errors.New("unexpected inner EAP packet, EAP-MSCHAPv2 Success is expected")
+      }
+      return method.sendResult(session)
+    case peapResult:
+      return method.verifyResult(inner)
+    default:
+      return Failed, nil, nil
+  }
+}
+
+// sendChallenge sends EAP-MSCHAPv2 Challenge
+func (method *peapMethod) sendChallenge(session *Session) (MethodResult, []uint8, error) {
+  challenge, err := randomBytes(16)
+  if err != nil {
+    return Failed, nil, err
+  }
+
+  method.authChallenge = challenge
+  method.msChapID      = session.lastID + 1
+  method.phase         = peapChallenge
+
+  data := append([]uint8{ uint8(len(challenge)) }, challenge...)
+  data  = append(data, []uint8(MSCHAPV2_SERVER_NAME)...)
+
+  return method.sendInner(msChapV2Packet(msChapV2Challenge, method.msChapID, data))
+}
+
+// verifyResponse verifies EAP-MSCHAPv2 Response and sends EAP-MSCHAPv2 Success or Failure
+func (method *peapMethod) verifyResponse(inner []uint8) (MethodResult, []uint8, error) {
+  /*
+   *  Type(1) | OpCode(1) | MS-CHAPv2-ID(1) | MS-Length(2) | Value-Size(1) |
+   *  Peer-Challenge(16) | Reserved(8) | NT-Response(24) | Flags(1) | Name
+   */
+  if len(inner) < 55 || EapType(inner[0]) != MSCHAPv2 || inner[1] != msChapV2Response || inner[5] != 49 {
+    return Failed, nil, errors.New("unexpected inner EAP packet, EAP-MSCHAPv2 Response is expected")
+  }
+
+  // Response should echo MS-CHAPv2-ID of Challenge (RFC 2759)
+  if inner[2] != method.msChapID {
+    return Failed, nil, errors.New(fmt.Sprintf("EAP-MSCHAPv2 Response has MS-CHAPv2-ID %d, %d is expected", inner[2], method.msChapID))
+  }
+
+  peerChallenge := inner[6:22]
+  ntResponse    := inner[30:54]
+  name          := inner[55:]
+
+  password, ok := method.lookup(method.identity)
+  if ok {
+    expected := tools.MSChapV2NTResponse(&method.authChallenge, &peerChallenge, &name, &password)
+    ok        = subtle.ConstantTimeCompare(expected, ntResponse) == 1
+  }
+
+  if !ok {
+    method.phase = peapFailure
+
+    message := fmt.Sprintf("E=691 R=0 C=%s V=3 M=Authentication failed", strings.ToUpper(hex.EncodeToString(method.authChallenge)))
+    return method.sendInner(msChapV2Packet(msChapV2Failure, method.msChapID, []uint8(message)))
+  }
+
+  // Inner session key is MS-MPPE-Recv-Key followed by MS-MPPE-Send-Key of the server
+  sendKey, recvKey := tools.MSChapV2MPPEKeys(&password, &ntResponse)
+  method.isk        = append(recvKey, sendKey...)
+  method.phase      = peapSuccess
+
+  authResponse := tools.MSChapV2AuthenticatorResponse(&password, &ntResponse, &peerChallenge, &method.authChallenge, &name)
+  return method.sendInner(msChapV2Packet(msChapV2Success, method.msChapID, []uint8(authResponse + " M=OK")))
+}
+
+// sendResult sends EAP-TLV packet with Result TLV and Crypto-Binding TLV
+func (method *peapMethod) sendResult(session *Session) (MethodResult, []uint8, error) {
+  nonce, err := randomBytes(32)
+  if err != nil {
+    return Failed, nil, err
+  }
+  // Peer responds with nonce incremented by one
+  nonce[31]   &= 0xFE
+  method.nonce = nonce
+  method.phase = peapResult
+
+  _, cmk, err := method.compoundKeys()
+  if err != nil {
+    return Failed, nil, err
+  }
+
+  tlvs := peapResultTLV(tlvResultSuccess)
+  tlvs  = append(tlvs, peapCryptoBindingTLV(cmk, 0, nonce)...)
+
+  tlvPacket := InitialiseEapPacket(Request, session.lastID + 1, TLV, tlvs)
+  return method.sendInner(tlvPacket.ToBytes())
+}
+
+// verifyResult verifies EAP-TLV response of peer and derives MSK
+func (method *peapMethod) verifyResult(inner []uint8) (MethodResult, []uint8, error) {
+  tlvPacket, err := InitialiseEapPacketFromBytes(inner)
+  if err != nil {
+    return Failed, nil, err
+  }
+  if tlvPacket.Code() != Response || tlvPacket.Type() != TLV {
+    return Failed, nil, errors.New("unexpected inner EAP packet, EAP-TLV is expected")
+  }
+
+  tlvs, err := parsePeapTLVs(tlvPacket.Data())
+  if err != nil {
+    return Failed, nil, err
+  }
+
+  result, ok := tlvs[tlvResult]
+  if !ok || len(result) != 6 || binary.BigEndian.Uint16(result[4:6]) != tlvResultSuccess {
+    return Failed, nil, nil
+  }
+
+  cryptoBinding, ok := tlvs[tlvCryptoBinding]
+  if !ok {
+    // Crypto binding is optional, peers that don't support it are keyed from TLS session only
+    state    := method.tunnel.tlsConn.ConnectionState()
+    msk, err := tlsKeyingMaterial(&state, PEAP, "client EAP encryption")
+    if err != nil {
+      return Failed, nil, err
+    }
+    method.msk = msk
+    return Succeeded, nil, nil
+  }
+
+  ipmk, cmk, err := method.compoundKeys()
+  if err != nil {
+    return Failed, nil, err
+  }
+
+  expectedNonce     := append([]uint8{}, method.nonce...)
+  expectedNonce[31] |= 1
+
+  if len(cryptoBinding) != 60 || cryptoBinding[7] != 1 || !bytes.Equal(cryptoBinding[8:40], expectedNonce) {
+    return Failed, nil, errors.New("invalid Crypto-Binding TLV")
+  }
+  if !hmac.Equal(cryptoBinding[40:60], peapCompoundMAC(cmk, cryptoBinding)) {
+    return Failed, nil, errors.New("Crypto-Binding TLV Compound MAC mismatch")
+  }
+
+  method.msk = peapCompoundSessionKey(ipmk)
+  return Succeeded, nil, nil
+}
+
+// compoundKeys derives IPMK & CMK from TLS session and inner EAP-MSCHAPv2 keys
+func (method *peapMethod) compoundKeys() ([]uint8, []uint8, error) {
+  state   := method.tunnel.tlsConn.ConnectionState()
+  tk, err := tlsKeyingMaterial(&state, PEAP, "client EAP encryption")
+  if err != nil {
+    return nil, nil, err
+  }
+
+  ipmk, cmk := peapCompoundKeys(tk, method.isk)
+  return ipmk, cmk, nil
+}
+
+// sendInner encrypts inner EAP packet and sends it to peer
+func (method *peapMethod) sendInner(inner []uint8) (MethodResult, []uint8, error) {
+  record, err := method.tunnel.write(inner)
+  if err != nil {
+    return Failed, nil, err
+  }
+
+  method.fragments.send(record)
+  return Continue, method.fragments.next(), nil
+}
+
+// tunneledTLSConfig prepares TLS config for PEAP & EAP-TTLS: neither defines key derivation for
+// TLS 1.3 in the form supported here, so TLS 1.2 is the highest version offered
+func tunneledTLSConfig(config *tls.Config) *tls.Config {
+  tunneledConfig := config.Clone()
+  if tunneledConfig.MaxVersion == 0 || tunneledConfig.MaxVersion > tls.VersionTLS12 {
+    tunneledConfig.MaxVersion = tls.VersionTLS12
+  }
+  tunneledConfig.SessionTicketsDisabled = true
+
+  return tunneledConfig
+}
+
+// msChapV2Packet builds header-less EAP-MSCHAPv2 packet
+func msChapV2Packet(opCode, msChapID uint8, data []uint8) []uint8 {
+  packet := []uint8{ uint8(MSCHAPv2), opCode, msChapID, 0, 0 }
+  binary.BigEndian.PutUint16(packet[3:5], uint16(4 + len(data)))
+
+  return append(packet, data...)
+}
+
+// peapResultTLV builds mandatory Result TLV
+func peapResultTLV(status uint16) []uint8 {
+  tlv := make([]uint8, 6)
+  binary.BigEndian.PutUint16(tlv[0:2], tlvMandatory | tlvResult)
+  binary.BigEndian.PutUint16(tlv[2:4], 2)
+  binary.BigEndian.PutUint16(tlv[4:6], status)
+
+  return tlv
+}
+
+// peapCryptoBindingTLV builds Crypto-Binding TLV of given SubType (0 - request, 1 - response)
+func peapCryptoBindingTLV(cmk []uint8, subType uint8, nonce []uint8) []uint8 {
+  tlv := make([]uint8, 60)
+  binary.BigEndian.PutUint16(tlv[0:2], tlvMandatory | tlvCryptoBinding)
+  binary.BigEndian.PutUint16(tlv[2:4], 56)
+  // Reserved, Version & Received Version are all 0 for PEAPv0
+  tlv[7] = subType
+  copy(tlv[8:40], nonce)
+  copy(tlv[40:60], peapCompoundMAC(cmk, tlv))
+
+  return tlv
+}
+
+// parsePeapTLVs parses TLVs into map of TLV type to whole TLV (including its header)
+func parsePeapTLVs(data []uint8) (map[uint16][]uint8, error) {
+  tlvs := make(map[uint16][]uint8)
+
+  for len(data) > 0 {
+    if len(data) < 4 {
+      return nil, errors.New("malformed PEAP TLV")
+    }
+
+    length := 4 + int(binary.BigEndian.Uint16(data[2:4]))
+    if length > len(data) {
+      return nil, errors.New("malformed PEAP TLV")
+    }
+
+    tlvs[binary.BigEndian.Uint16(data[0:2]) & tlvTypeMask] = data[:length]
+    data = data[length:]
+  }
+
+  return tlvs, nil
+}
+
+// peapPRFPlus is PRF+ of PEAPv0 (MS-PEAP, section 3.1.5.6):
+// T(n) = HMAC-SHA1(key, T(n-1) | seed | n | 0x00 | 0x00)
+func peapPRFPlus(key, seed []uint8, length int) []uint8 {
+  var output, previous []uint8
+
+  for counter := 1; len(output) < length; counter++ {
+    mac := hmac.New(sha1.New, key)
+    mac.Write(previous)
+    mac.Write(seed)
+    mac.Write([]uint8{ uint8(counter), 0, 0 })
+
+    previous = mac.Sum(nil)
+    output   = append(output, previous...)
+  }
+
+  return output[:length]
+}
+
+// peapCompoundKeys derives IPMK (40 octets) & CMK (20 octets) from tunnel key and inner session key
+// (MS-PEAP, section 3.1.5.5)
+func peapCompoundKeys(tk, isk []uint8) ([]uint8, []uint8) {
+  paddedISK := make([]uint8, 32)
+  copy(paddedISK, isk)
+
+  seed := append([]uint8("Inner Methods Compound Keys"), paddedISK...)
+  imck := peapPRFPlus(tk[:40], seed, 60)
+
+  return imck[:40], imck[40:60]
+}
+
+// peapCompoundMAC calculates Compound MAC of Crypto-Binding TLV: HMAC-SHA1 of the TLV with zeroed
+// Compound MAC field, followed by EAP Type of PEAP
+func peapCompoundMAC(cmk, tlv []uint8) []uint8 {
+  buffer := make([]uint8, 61)
+  copy(buffer, tlv[:40])
+  buffer[60] = uint8(PEAP)
+
+  mac := hmac.New(sha1.New, cmk)
+  mac.Write(buffer)
+  return mac.Sum(nil)
+}
+
+// peapCompoundSessionKey derives MSK from IPMK, once crypto binding succeeded
+func peapCompoundSessionKey(ipmk []uint8) []uint8 {
+  // Label is null-terminated here, unlike the one used to derive IPMK & CMK
+  seed := append([]uint8("Session Key Generating Function"), 0)
+  return peapPRFPlus(ipmk, seed, 64)
+}
+
+// peapPeer is peer side of PEAPv0 with inner EAP-MSCHAPv2
+type peapPeer struct {
+  exchange      tlsPeerExchange
+  identity      []uint8
+  password      []uint8
+
+  authChallenge []uint8
+  peerChallenge []uint8
+  ntResponse    []uint8
+  authenticated bool
+  msk           []uint8
+}
+
+// PEAPPeer returns PeerMethodFactory of PEAPv0 method, that authenticates with EAP-MSCHAPv2 using
+// given inner identity & password, and answers Crypto-Binding TLV, if server sends one
+func PEAPPeer(config *tls.Config, identity string, password []uint8) PeerMethodFactory {
+  return func() PeerMethod {
+    return &peapPeer {
+      exchange: tlsPeerExchange { tunnel: newClientTLSTunnel(tunneledTLSConfig(config)) },
+      identity: []uint8(identity),
+      password: password,
+    }
+  }
+}
+
+// Type returns EAP Type of the method
+func (peer *peapPeer) Type() EapType {
+  return PEAP
+}
+
+// Process handles PEAP request: during TLS handshake it works as EAP-TLS, afterwards it decrypts
+// inner EAP packet and replies to it
+func (peer *peapPeer) Process(request *EapPacket) ([]uint8, error) {
+  return peer.exchange.process(request.Data(), peer.exchange.tunnel.handshakeAndRead, peer.processInner)
+}
+
+// MSK returns Master Session Key derived from TLS session and, if crypto binding is used, from
+// inner EAP-MSCHAPv2 keys
+func (peer *peapPeer) MSK() []uint8 {
+  return peer.msk
+}
+
+// Finished reports if inner method succeeded and server reported success in Result TLV
+func (peer *peapPeer) Finished() bool {
+  return peer.msk != nil
+}
+
+// Close stops TLS client
+func (peer *peapPeer) Close() error {
+  return peer.exchange.tunnel.Close()
+}
+
+// processInner handles decrypted inner EAP packet; EAP-TLV packets are sent in full, others without
+// EAP header
+func (peer *peapPeer) processInner(inner []uint8) ([]uint8, error) {
+  if len(inner) >= 5 && EapType(inner[4]) == TLV {
+    return peer.processResult(inner)
+  }
+
+  switch EapType(inner[0]) {
+    case Identity:
+      return append([]uint8{ uint8(Identity) }, peer.identity...), nil
+    case MSCHAPv2:
+      if len(inner) < 5 {
+        return nil, errors.New("malformed inner EAP-MSCHAPv2 packet")
+      }
+
+      switch inner[1] {
+        case msChapV2Challenge:
+          return peer.respondChallenge(inner)
+        case msChapV2Success:
+          expected := tools.MSChapV2AuthenticatorResponse(&peer.password, &peer.ntResponse, &peer.peerChallenge, &peer.authChallenge, &peer.identity)
+          if len(peer.ntResponse) == 0 || !strings.HasPrefix(string(inner[5:]), expected) {
+            return nil, errors.New("EAP-MSCHAPv2 Authenticator Response mismatch")
+          }
+          peer.authenticated = true
+          return []uint8{ uint8(MSCHAPv2), msChapV2Success }, nil
+        case msChapV2Failure:
+          return []uint8{ uint8(MSCHAPv2), msChapV2Failure }, nil
+      }
+  }
+
+  return nil, errors.New(fmt.Sprintf("unexpected inner EAP packet of type %d", inner[0]))
+}
+
+// respondChallenge answers EAP-MSCHAPv2 Challenge
+func (peer *peapPeer) respondChallenge(inner []uint8) ([]uint8, error) {
+  if len(inner) < 22 || inner[5] != 16 {
+    return nil, errors.New("malformed EAP-MSCHAPv2 Challenge")
+  }
+
+  peerChallenge, err := randomBytes(16)
+  if err != nil {
+    return nil, err
+  }
+
+  peer.authChallenge = append([]uint8{}, inner[6:22]...)
+  peer.peerChallenge = peerChallenge
+  peer.ntResponse    = tools.MSChapV2NTResponse(&peer.authChallenge, &peer.peerChallenge, &peer.identity, &peer.password)
+
+  data := []uint8{ 49 }
+  data  = append(data, peer.peerChallenge...)
+  data  = append(data, make([]uint8, 8)...)
+  data  = append(data, peer.ntResponse...)
+  data  = append(data, 0)
+  data  = append(data, peer.identity...)
+
+  return msChapV2Packet(msChapV2Response, inner[2], data), nil
+}
+
+// processResult answers EAP-TLV request and derives MSK, if server reports success
+func (peer *peapPeer) processResult(inner []uint8) ([]uint8, error) {
+  tlvRequest, err := InitialiseEapPacketFromBytes(inner)
+  if err != nil {
+    return nil, err
+  }
+
+  tlvs, err := parsePeapTLVs(tlvRequest.Data())
+  if err != nil {
+    return nil, err
+  }
+
+  result, ok := tlvs[tlvResult]
+  if !ok || len(result) != 6 || binary.BigEndian.Uint16(result[4:6]) != tlvResultSuccess || !peer.authenticated {
+    tlvResponse := InitialiseEapPacket(Response, tlvRequest.ID(), TLV, peapResultTLV(tlvResultFailure))
+    return tlvResponse.ToBytes(), nil
+  }
+
+  state   := peer.exchange.tunnel.tlsConn.ConnectionState()
+  tk, err := tlsKeyingMaterial(&state, PEAP, "client EAP encryption")
+  if err != nil {
+    return nil, err
+  }
+
+  response := peapResultTLV(tlvResultSuccess)
+
+  if cryptoBinding, ok := tlvs[tlvCryptoBinding]; ok {
+    // Inner session key is MS-MPPE-Recv-Key followed by MS-MPPE-Send-Key of the server
+    sendKey, recvKey := tools.MSChapV2MPPEKeys(&peer.password, &peer.ntResponse)
+    ipmk, cmk        := peapCompoundKeys(tk, append(recvKey, sendKey...))
+
+    if len(cryptoBinding) != 60 || cryptoBinding[7] != 0 {
+      return nil, errors.New("invalid Crypto-Binding TLV")
+    }
+    if !hmac.Equal(cryptoBinding[40:60], peapCompoundMAC(cmk, cryptoBinding)) {
+      return nil, errors.New("Crypto-Binding TLV Compound MAC mismatch")
+    }
+
+    nonce     := append([]uint8{}, cryptoBinding[8:40]...)
+    nonce[31] |= 1
+    response   = append(response, peapCryptoBindingTLV(cmk, 1, nonce)...)
+    peer.msk   = peapCompoundSessionKey(ipmk)
+  } else {
+    peer.msk = tk
+  }
+
+  tlvResponse := InitialiseEapPacket(Response, tlvRequest.ID(), TLV, response)
+  return tlvResponse.ToBytes(), nil
+}
@@ -0,0 +1,148 @@
+package eap
+
+import (
+  "strings"
+  "testing"
+  "time"
+
+  "github.com/stretchr/testify/assert"
+
+  "github.com/MikhailMS/go-radius/protocol"
+  "github.com/MikhailMS/go-radius/tools"
+)
+
+// testPeapPeer returns inner function of PEAPv0 peer, that authenticates with EAP-MSCHAPv2 and
+// expects crypto binding to be used, if cryptoBinding is true
+func testPeapPeer(t *testing.T, password string, cryptoBinding bool, msk *[]uint8) func(*tlsTunnel, []uint8) []uint8 {
+  username      := []uint8("testing")
+  passwordBytes := []uint8(password)
+  var authChallenge, peerChallenge, ntResponse []uint8
+
+  return func(peer *tlsTunnel, received []uint8) []uint8 {
+    if len(received) == 0 {
+      return nil
+    }
+
+    if len(received) >= 5 && EapType(received[4]) == TLV {
+      tlvRequest, _ := InitialiseEapPacketFromBytes(received)
+      tlvs, _       := parsePeapTLVs(tlvRequest.Data())
+
+      sendKey, recvKey := tools.MSChapV2MPPEKeys(&passwordBytes, &ntResponse)
+      state            := peer.tlsConn.ConnectionState()
+      tk, _            := tlsKeyingMaterial(&state, PEAP, "client EAP encryption")
+      ipmk, cmk        := peapCompoundKeys(tk, append(recvKey, sendKey...))
+
+      request := tlvs[tlvCryptoBinding]
+      assert.Equal(t, peapCompoundMAC(cmk, request), request[40:60], "Crypto-Binding TLV Compound MAC is not correct!")
+
+      response := peapResultTLV(tlvResultSuccess)
+      if cryptoBinding {
+        nonce     := append([]uint8{}, request[8:40]...)
+        nonce[31] |= 1
+        response   = append(response, peapCryptoBindingTLV(cmk, 1, nonce)...)
+        *msk       = peapCompoundSessionKey(ipmk)
+      } else {
+        *msk = tk
+      }
+
+      tlvResponse := InitialiseEapPacket(Response, tlvRequest.ID(), TLV, response)
+      return tlvResponse.ToBytes()
+    }
+
+    switch EapType(received[0]) {
+      case Identity:
+        return append([]uint8{ uint8(Identity) }, username...)
+      case MSCHAPv2:
+        switch received[1] {
+          case msChapV2Challenge:
+            authChallenge = received[6:22]
+            peerChallenge = make([]uint8, 16)
+            ntResponse    = tools.MSChapV2NTResponse(&authChallenge, &peerChallenge, &username, &passwordBytes)
+
+            data := []uint8{ 49 }
+            data  = append(data, peerChallenge...)
+            data  = append(data, make([]uint8, 8)...)
+            data  = append(data, ntResponse...)
+            data  = append(data, 0)
+            data  = append(data, username...)
+            return msChapV2Packet(msChapV2Response, received[2], data)
+          case msChapV2Success:
+            expected := tools.MSChapV2AuthenticatorResponse(&passwordBytes, &ntResponse, &peerChallenge, &authChallenge, &username)
+            assert.Equal(t, true, strings.HasPrefix(string(received[5:]), expected), "Authenticator Response is not correct!")
+            return []uint8{ uint8(MSCHAPv2), msChapV2Success }
+          default:
+            return []uint8{ uint8(MSCHAPv2), received[1] }
+        }
+    }
+
+    t.Fatalf("unexpected inner EAP packet: %v", received)
+    return nil
+  }
+}
+
+func TestPEAPMethod(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+
+  serverConfig, clientConfig := createTestTLSConfigs(t)
+  clientConfig.Certificates   = nil
+
+  for _, testCase := range []struct {
+    password      string
+    cryptoBinding bool
+    expectedCode  protocol.TypeCode
+  } {
+    { "password", true,  protocol.AccessAccept },
+    { "password", false, protocol.AccessAccept },
+    { "wrong",    true,  protocol.AccessReject },
+  } {
+    authenticator := InitialiseAuthenticator(dictionary, time.Minute)
+    authenticator.AddMethod(PEAP, PEAPMethod(serverConfig, testPasswordLookup))
+
+    var msk []uint8
+    inner := testPeapPeer(t, testCase.password, testCase.cryptoBinding, &msk)
+
+    request, reply, _, _ := runTestTLSPeer(t, authenticator, PEAP, clientConfig, inner)
+    assert.Equal(t, testCase.expectedCode, reply.Code(), "PEAP reply is not correct!")
+
+    if testCase.expectedCode != protocol.AccessAccept {
+      continue
+    }
+
+    authBytes       := request.Authenticator()
+    secret          := []uint8("secret")
+    encryptedKey, _ := reply.VendorSpecificValue(tools.MICROSOFT_VENDOR_ID, tools.MS_MPPE_RECV_KEY)
+    recvKey, _      := tools.DecryptMPPEKey(&encryptedKey, &authBytes, &secret)
+    assert.Equal(t, msk[:32], recvKey, "MS-MPPE-Recv-Key is not correct!")
+  }
+}
+
+func TestPEAPMethodMSChapIDMismatch(t *testing.T) {
+  username      := []uint8("testing")
+  password      := []uint8("password")
+  authChallenge := make([]uint8, 16)
+  peerChallenge := make([]uint8, 16)
+  ntResponse    := tools.MSChapV2NTResponse(&authChallenge, &peerChallenge, &username, &password)
+
+  method := &peapMethod { lookup: testPasswordLookup, phase: peapChallenge, identity: "testing", msChapID: 5, authChallenge: authChallenge }
+
+  data := []uint8{ 49 }
+  data  = append(data, peerChallenge...)
+  data  = append(data, make([]uint8, 8)...)
+  data  = append(data, ntResponse...)
+  data  = append(data, 0)
+  data  = append(data, username...)
+
+  result, _, err := method.verifyResponse(msChapV2Packet(msChapV2Response, 6, data))
+  assert.Equal(t, Failed,                                                      result,      "Response with wrong MS-CHAPv2-ID is not rejected!")
+  assert.Equal(t, "EAP-MSCHAPv2 Response has MS-CHAPv2-ID 6, 5 is expected", err.Error(), "Response with wrong MS-CHAPv2-ID is not rejected!")
+}
+
+func TestPeapPRFPlus(t *testing.T) {
+  key  := make([]uint8, 40)
+  seed := []uint8("Inner Methods Compound Keys")
+
+  output := peapPRFPlus(key, seed, 60)
+  assert.Equal(t, 60,          len(output),                "PRF+ output length is not correct!")
+  assert.Equal(t, output[:40], peapPRFPlus(key, seed, 40), "PRF+ output is not a prefix of longer output!")
+}
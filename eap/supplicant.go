@@ -0,0 +1,231 @@
+// Peer side of EAP conversation, that is driven through RADIUS Client as if it was sent by NAS
+package eap
+
+import (
+  "errors"
+  "fmt"
+  "io"
+
+  "github.com/MikhailMS/go-radius/client"
+  "github.com/MikhailMS/go-radius/protocol"
+)
+
+// MAX_EAP_ROUNDS is the maximum number of Access-Request/Access-Challenge round trips in single
+// conversation
+const MAX_EAP_ROUNDS = 64
+
+// PeerMethod is peer side of EAP authentication method
+//
+// New PeerMethod is created for every EAP conversation, so it could keep conversation state
+type PeerMethod interface {
+  // Type returns EAP Type of the method
+  Type() EapType
+  // Process handles EAP-Request of the method and returns Type-Data of EAP-Response
+  Process(request *EapPacket) ([]uint8, error)
+}
+
+// PeerKeyingMethod is PeerMethod, that derives keying material
+type PeerKeyingMethod interface {
+  PeerMethod
+  // MSK returns 64 octets long Master Session Key, once method is finished
+  MSK() []uint8
+}
+
+// PeerFinishingMethod is PeerMethod, that reports if it is finished; Access-Accept, that is received
+// before method is finished, fails the conversation, as server is not authenticated by the method
+type PeerFinishingMethod interface {
+  PeerMethod
+  // Finished reports if method has completed successfully
+  Finished() bool
+}
+
+// PeerMethodFactory creates new PeerMethod for EAP conversation
+type PeerMethodFactory func() PeerMethod
+
+// AuthenticationResult represents outcome of EAP conversation
+type AuthenticationResult struct {
+  accepted bool
+  request  protocol.RadiusPacket
+  reply    protocol.RadiusPacket
+  msk      []uint8
+}
+
+// Accepted reports if conversation ended with Access-Accept
+func (result *AuthenticationResult) Accepted() bool {
+  return result.accepted
+}
+
+// Request returns last Access-Request of conversation, which authenticator is needed to decrypt
+// MS-MPPE keys of Access-Accept
+func (result *AuthenticationResult) Request() protocol.RadiusPacket {
+  return result.request
+}
+
+// Reply returns final Access-Accept or Access-Reject
+func (result *AuthenticationResult) Reply() protocol.RadiusPacket {
+  return result.reply
+}
+
+// MSK returns Master Session Key derived by peer method, if method derives one and peer was accepted
+func (result *AuthenticationResult) MSK() []uint8 {
+  return result.msk
+}
+
+// Supplicant runs peer side of EAP conversations against RADIUS Server
+type Supplicant struct {
+  radClient *client.Client
+  identity  string
+  methods   []EapType
+  factories map[EapType]PeerMethodFactory
+}
+
+// InitialiseSupplicant initialises Supplicant, that sends Access-Requests with given Client and
+// introduces itself with given identity
+//
+// Please note that you would need to call **AddMethod** at least once to initialise Supplicant in
+// full
+func InitialiseSupplicant(radClient *client.Client, identity string) *Supplicant {
+  return &Supplicant {
+    radClient: radClient,
+    identity:  identity,
+    factories: make(map[EapType]PeerMethodFactory),
+  }
+}
+
+// AddMethod adds EAP method Supplicant could use; when server proposes method Supplicant doesn't
+// support, it replies with Nak listing added methods in the order they were added
+func (supplicant *Supplicant) AddMethod(eapType EapType, factory PeerMethodFactory) {
+  if _, ok := supplicant.factories[eapType]; !ok {
+    supplicant.methods = append(supplicant.methods, eapType)
+  }
+  supplicant.factories[eapType] = factory
+}
+
+// Authenticate runs EAP conversation till Access-Accept or Access-Reject is received
+//
+// Attributes are added to every Access-Request of the conversation; State of each Access-Challenge
+// is copied into the next Access-Request. If PeerMethod implements io.Closer, it is closed once
+// conversation is over; if it implements PeerFinishingMethod, Access-Accept is only accepted once
+// method is finished
+func (supplicant *Supplicant) Authenticate(attributes []protocol.RadiusAttribute) (AuthenticationResult, error) {
+  var method PeerMethod
+  var state  []uint8
+
+  defer func() {
+    closePeerMethod(method)
+  }()
+
+  eapResponse := CreateIdentityResponse(0, supplicant.identity)
+
+  for round := 0; round < MAX_EAP_ROUNDS; round++ {
+    request, reply, eapRequest, err := supplicant.exchange(attributes, &eapResponse, state)
+    if err != nil {
+      return AuthenticationResult{}, err
+    }
+
+    switch reply.Code() {
+      case protocol.AccessAccept:
+        if eapRequest.Code() != Success {
+          return AuthenticationResult{}, errors.New("Access-Accept carries no EAP-Success")
+        }
+        if finishingMethod, ok := method.(PeerFinishingMethod); ok && !finishingMethod.Finished() {
+          return AuthenticationResult{}, errors.New(fmt.Sprintf("Access-Accept is received before EAP method %d is finished", method.Type()))
+        }
+
+        result := AuthenticationResult { accepted: true, request: request, reply: reply }
+        if keyingMethod, ok := method.(PeerKeyingMethod); ok {
+          result.msk = keyingMethod.MSK()
+        }
+        return result, nil
+      case protocol.AccessReject:
+        return AuthenticationResult { accepted: false, request: request, reply: reply }, nil
+      case protocol.AccessChallenge:
+      default:
+        return AuthenticationResult{}, errors.New(fmt.Sprintf("unexpected reply code: %v", reply.Code()))
+    }
+
+    if eapRequest.Code() != Request {
+      return AuthenticationResult{}, errors.New("Access-Challenge carries no EAP-Request")
+    }
+    stateAttr := reply.AttributeByName("State")
+    state      = stateAttr.Value()
+
+    switch eapType := eapRequest.Type(); {
+      case eapType == Identity:
+        eapResponse = CreateIdentityResponse(eapRequest.ID(), supplicant.identity)
+      case eapType == Notification:
+        eapResponse = InitialiseEapPacket(Response, eapRequest.ID(), Notification, []uint8{})
+      case method != nil && eapType == method.Type():
+        data, err := method.Process(&eapRequest)
+        if err != nil {
+          return AuthenticationResult{}, err
+        }
+        eapResponse = InitialiseEapPacket(Response, eapRequest.ID(), eapType, data)
+      case supplicant.factories[eapType] != nil:
+        closePeerMethod(method)
+        method = supplicant.factories[eapType]()
+
+        data, err := method.Process(&eapRequest)
+        if err != nil {
+          return AuthenticationResult{}, err
+        }
+        eapResponse = InitialiseEapPacket(Response, eapRequest.ID(), eapType, data)
+      default:
+        eapResponse = CreateNak(eapRequest.ID(), supplicant.methods...)
+    }
+  }
+
+  return AuthenticationResult{}, errors.New("EAP conversation is not finished within allowed number of rounds")
+}
+
+// exchange sends EAP-Response inside Access-Request and returns it together with verified reply and
+// EAP packet reply carries
+func (supplicant *Supplicant) exchange(attributes []protocol.RadiusAttribute, eapResponse *EapPacket, state []uint8) (protocol.RadiusPacket, protocol.RadiusPacket, EapPacket, error) {
+  radPacket := supplicant.radClient.CreateAuthRadiusPacket()
+  radPacket.SetAttributes(append([]protocol.RadiusAttribute{}, attributes...))
+
+  if len(state) > 0 {
+    stateAttr, err := supplicant.radClient.CreateAttributeByName("State", &state)
+    if err != nil {
+      return protocol.RadiusPacket{}, protocol.RadiusPacket{}, EapPacket{}, err
+    }
+    radPacket.SetAttributes(append(radPacket.Attributes(), stateAttr))
+  }
+
+  dictionary := supplicant.radClient.Dictionary()
+  if err := SetEapMessage(&dictionary, &radPacket, eapResponse); err != nil {
+    return protocol.RadiusPacket{}, protocol.RadiusPacket{}, EapPacket{}, err
+  }
+  if err := radPacket.GenerateMessageAuthenticator(supplicant.radClient.Secret()); err != nil {
+    return protocol.RadiusPacket{}, protocol.RadiusPacket{}, EapPacket{}, err
+  }
+
+  replyBytes, err := supplicant.radClient.SendAndReceivePacket(&radPacket)
+  if err != nil {
+    return protocol.RadiusPacket{}, protocol.RadiusPacket{}, EapPacket{}, err
+  }
+
+  if ok, err := supplicant.radClient.VerifyReply(&radPacket, &replyBytes); !ok {
+    return protocol.RadiusPacket{}, protocol.RadiusPacket{}, EapPacket{}, err
+  }
+
+  reply, err := supplicant.radClient.InitialiseRadiusPacketFromBytes(&replyBytes)
+  if err != nil {
+    return protocol.RadiusPacket{}, protocol.RadiusPacket{}, EapPacket{}, err
+  }
+
+  eapRequest, err := EapPacketFromRadiusPacket(&reply)
+  if err != nil && reply.Code() != protocol.AccessReject {
+    // Access-Reject is final even if it carries no EAP-Failure
+    return protocol.RadiusPacket{}, protocol.RadiusPacket{}, EapPacket{}, err
+  }
+
+  return radPacket, reply, eapRequest, nil
+}
+
+// closePeerMethod closes PeerMethod, if it implements io.Closer
+func closePeerMethod(method PeerMethod) {
+  if closer, ok := method.(io.Closer); ok {
+    closer.Close()
+  }
+}
@@ -0,0 +1,128 @@
+package eap
+
+import (
+  "crypto/tls"
+  "testing"
+  "time"
+
+  "github.com/stretchr/testify/assert"
+
+  "github.com/MikhailMS/go-radius/protocol"
+  "github.com/MikhailMS/go-radius/tools"
+)
+
+func TestSupplicant(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+
+  serverConfig, clientConfig := createTestTLSConfigs(t)
+  tls12Config               := clientConfig.Clone()
+  tls12Config.MaxVersion     = tls.VersionTLS12
+
+  for _, testCase := range []struct {
+    name        string
+    peerType    EapType
+    peer        PeerMethodFactory
+    expectedMSK bool
+    accepted    bool
+  } {
+    { "EAP-MD5",       MD5Challenge, MD5ChallengePeer([]uint8("password")),                  false, true  },
+    { "EAP-MD5 wrong", MD5Challenge, MD5ChallengePeer([]uint8("wrong")),                     false, false },
+    { "EAP-TLS 1.3",   TLS,          TLSPeer(clientConfig),                                  true,  true  },
+    { "EAP-TLS 1.2",   TLS,          TLSPeer(tls12Config),                                   true,  true  },
+    { "PEAP",          PEAP,         PEAPPeer(clientConfig, "testing", []uint8("password")), true,  true  },
+    { "PEAP wrong",    PEAP,         PEAPPeer(clientConfig, "testing", []uint8("wrong")),    false, false },
+  } {
+    // Server proposes EAP-MD5 first, so peers of other methods have to Nak it
+    authenticator := InitialiseAuthenticator(dictionary, time.Minute)
+    authenticator.AddMethod(MD5Challenge, MD5ChallengeMethod(testPasswordLookup))
+    authenticator.AddMethod(TLS,          TLSMethod(serverConfig, nil))
+    authenticator.AddMethod(PEAP,         PEAPMethod(serverConfig, testPasswordLookup))
+
+    radClient  := startTestAuthenticator(t, authenticator)
+    supplicant := InitialiseSupplicant(&radClient, "testing")
+    supplicant.AddMethod(testCase.peerType, testCase.peer)
+
+    result, err := supplicant.Authenticate(nil)
+    assert.Equal(t, nil,               err,               "EAP conversation failed for " + testCase.name + "!")
+    assert.Equal(t, testCase.accepted, result.Accepted(), "EAP result is not correct for " + testCase.name + "!")
+
+    if !testCase.expectedMSK {
+      assert.Equal(t, 0, len(result.MSK()), "MSK is derived for " + testCase.name + "!")
+      continue
+    }
+
+    request         := result.Request()
+    reply           := result.Reply()
+    authBytes       := request.Authenticator()
+    secret          := []uint8("secret")
+    encryptedKey, _ := reply.VendorSpecificValue(tools.MICROSOFT_VENDOR_ID, tools.MS_MPPE_RECV_KEY)
+    recvKey, _      := tools.DecryptMPPEKey(&encryptedKey, &authBytes, &secret)
+
+    assert.Equal(t, 64,                len(result.MSK()), "MSK is not derived for " + testCase.name + "!")
+    assert.Equal(t, result.MSK()[:32], recvKey,           "MS-MPPE-Recv-Key doesn't match MSK for " + testCase.name + "!")
+  }
+}
+
+// earlySuccessMethod starts EAP-TLS or PEAP and reports success on the first EAP-Response, before
+// TLS handshake is finished
+type earlySuccessMethod struct {
+  eapType EapType
+}
+
+func (method *earlySuccessMethod) Type() EapType {
+  return method.eapType
+}
+
+func (method *earlySuccessMethod) Initiate(session *Session) ([]uint8, error) {
+  return []uint8{ tlsFlagStart }, nil
+}
+
+func (method *earlySuccessMethod) Process(session *Session, response *EapPacket) (MethodResult, []uint8, error) {
+  return Succeeded, nil, nil
+}
+
+func TestSupplicantEarlyAccept(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+
+  _, clientConfig := createTestTLSConfigs(t)
+
+  for _, testCase := range []struct {
+    name     string
+    peerType EapType
+    peer     PeerMethodFactory
+  } {
+    { "EAP-TLS", TLS,  TLSPeer(clientConfig) },
+    { "PEAP",    PEAP, PEAPPeer(clientConfig, "testing", []uint8("password")) },
+  } {
+    peerType      := testCase.peerType
+    authenticator := InitialiseAuthenticator(dictionary, time.Minute)
+    authenticator.AddMethod(peerType, func() Method { return &earlySuccessMethod { peerType } })
+
+    radClient  := startTestAuthenticator(t, authenticator)
+    supplicant := InitialiseSupplicant(&radClient, "testing")
+    supplicant.AddMethod(peerType, testCase.peer)
+
+    result, err := supplicant.Authenticate(nil)
+    assert.NotEqual(t, nil,   err,               "Access-Accept before handshake is finished is trusted for " + testCase.name + "!")
+    assert.Equal(t,    false, result.Accepted(), "Peer is accepted before handshake is finished for " + testCase.name + "!")
+  }
+}
+
+func TestMD5ChallengePeer(t *testing.T) {
+  password  := []uint8("password")
+  challenge := []uint8{ 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16 }
+
+  peer    := MD5ChallengePeer(password)()
+  request := InitialiseEapPacket(Request, 5, MD5Challenge, append([]uint8{ 16 }, challenge...))
+
+  data, err := peer.Process(&request)
+  assert.Equal(t, nil,                                          err,       "EAP-MD5 challenge is not answered!")
+  assert.Equal(t, uint8(16),                                    data[0],   "Value-Size is not correct!")
+  assert.Equal(t, tools.ChapResponse(5, &password, &challenge), data[1:], "EAP-MD5 response is not correct!")
+
+  malformed := InitialiseEapPacket(Request, 6, MD5Challenge, []uint8{ 16, 1, 2 })
+  _, err     = peer.Process(&malformed)
+  assert.Equal(t, "malformed EAP-Request/MD5-Challenge", err.Error(), "Malformed challenge is accepted!")
+}
@@ -0,0 +1,226 @@
+package eap
+
+import (
+  "crypto/tls"
+  "errors"
+  "io"
+)
+
+// CertificateVerifier is called once TLS handshake is finished, so certificate peer presented could
+// be matched with identity peer provided in EAP-Response/Identity
+type CertificateVerifier func(identity string, state tls.ConnectionState) error
+
+// tlsMethod is server side of EAP-TLS (RFC 5216 & RFC 9190)
+type tlsMethod struct {
+  config    *tls.Config
+  verifier  CertificateVerifier
+  tunnel    *tlsTunnel
+  fragments tlsFragments
+  msk       []uint8
+}
+
+// TLSMethod returns MethodFactory of EAP-TLS method
+//
+// EAP-TLS requires peer to present certificate, so if ClientAuth of config doesn't require it, it is
+// raised to tls.RequireAndVerifyClientCert. Verifier is optional and could be nil
+func TLSMethod(config *tls.Config, verifier CertificateVerifier) MethodFactory {
+  return func() Method {
+    return &tlsMethod { config: config, verifier: verifier }
+  }
+}
+
+// Type returns EAP Type of the method
+func (method *tlsMethod) Type() EapType {
+  return TLS
+}
+
+// Initiate starts TLS server and returns Type-Data of EAP-TLS Start packet
+func (method *tlsMethod) Initiate(session *Session) ([]uint8, error) {
+  config := method.config.Clone()
+  if config.ClientAuth < tls.RequireAnyClientCert {
+    config.ClientAuth = tls.RequireAndVerifyClientCert
+  }
+  // RFC 9190 doesn't allow NewSessionTicket after commitment message, so tickets are not issued
+  config.SessionTicketsDisabled = true
+
+  method.tunnel = newServerTLSTunnel(config)
+  if _, err := method.tunnel.start(eapTLSHandshake); err != nil {
+    return nil, err
+  }
+
+  return []uint8{ tlsFlagStart }, nil
+}
+
+// Process handles EAP-TLS response: reassembles TLS message from fragments, passes it to TLS server
+// and sends its reply in fragments
+func (method *tlsMethod) Process(session *Session, response *EapPacket) (MethodResult, []uint8, error) {
+  next, message, err := method.fragments.handle(response.Data())
+  if err != nil {
+    return Failed, nil, err
+  }
+  if next != nil {
+    return Continue, next, nil
+  }
+
+  if len(message) == 0 {
+    // Peer acknowledged final flight of TLS handshake
+    if method.tunnel.finished {
+      return method.finish(session)
+    }
+    return Failed, nil, errors.New("unexpected EAP-TLS acknowledgement")
+  }
+
+  output, err := method.tunnel.step(message)
+  if err != nil {
+    return Failed, nil, err
+  }
+  if len(output) == 0 {
+    if method.tunnel.finished {
+      return method.finish(session)
+    }
+    return Failed, nil, errors.New("TLS handshake produced no reply")
+  }
+
+  method.fragments.send(output)
+  return Continue, method.fragments.next(), nil
+}
+
+// MSK returns Master Session Key derived from TLS session
+func (method *tlsMethod) MSK() []uint8 {
+  return method.msk
+}
+
+// Close stops TLS server
+func (method *tlsMethod) Close() error {
+  if method.tunnel == nil {
+    return nil
+  }
+  return method.tunnel.Close()
+}
+
+// finish verifies peer certificate and derives keying material
+func (method *tlsMethod) finish(session *Session) (MethodResult, []uint8, error) {
+  state := method.tunnel.tlsConn.ConnectionState()
+
+  if method.verifier != nil {
+    if err := method.verifier(session.Identity(), state); err != nil {
+      return Failed, nil, err
+    }
+  }
+
+  msk, err := tlsKeyingMaterial(&state, TLS, "client EAP encryption")
+  if err != nil {
+    return Failed, nil, err
+  }
+  method.msk = msk
+
+  return Succeeded, nil, nil
+}
+
+// eapTLSHandshake runs TLS handshake; with TLS 1.3 it is followed by commitment message, that
+// tells peer no more handshake messages would be sent (RFC 9190, section 2.1.1)
+func eapTLSHandshake(tlsConn *tls.Conn) error {
+  if err := tlsConn.Handshake(); err != nil {
+    return err
+  }
+
+  if tlsConn.ConnectionState().Version == tls.VersionTLS13 {
+    _, err := tlsConn.Write([]uint8{ 0 })
+    return err
+  }
+  return nil
+}
+
+// tlsKeyingMaterial derives 64 octets long MSK from TLS session
+//
+// TLS 1.2 uses label of EAP method (RFC 5216, RFC 5281), TLS 1.3 uses EAP-TLS exporter label with
+// EAP Type as context (RFC 9190)
+func tlsKeyingMaterial(state *tls.ConnectionState, eapType EapType, label string) ([]uint8, error) {
+  var keyingMaterial []uint8
+  var err error
+
+  if state.Version == tls.VersionTLS13 {
+    keyingMaterial, err = state.ExportKeyingMaterial("EXPORTER_EAP_TLS_Key_Material", []uint8{ uint8(eapType) }, 128)
+  } else {
+    keyingMaterial, err = state.ExportKeyingMaterial(label, nil, 128)
+  }
+  if err != nil {
+    return nil, err
+  }
+
+  return keyingMaterial[:64], nil
+}
+
+// tlsPeer is peer side of EAP-TLS (RFC 5216 & RFC 9190)
+type tlsPeer struct {
+  exchange tlsPeerExchange
+  msk      []uint8
+}
+
+// TLSPeer returns PeerMethodFactory of EAP-TLS method; config has to carry certificate peer
+// presents to server
+func TLSPeer(config *tls.Config) PeerMethodFactory {
+  return func() PeerMethod {
+    return &tlsPeer { exchange: tlsPeerExchange { tunnel: newClientTLSTunnel(config) } }
+  }
+}
+
+// Type returns EAP Type of the method
+func (peer *tlsPeer) Type() EapType {
+  return TLS
+}
+
+// Process handles EAP-TLS request and derives keying material, once TLS handshake is finished
+func (peer *tlsPeer) Process(request *EapPacket) ([]uint8, error) {
+  if peer.exchange.tunnel.finished {
+    return nil, errors.New("unexpected EAP-TLS request, TLS handshake is finished")
+  }
+
+  data, err := peer.exchange.process(request.Data(), eapTLSPeerHandshake, nil)
+  if err != nil {
+    return nil, err
+  }
+
+  if peer.exchange.tunnel.finished {
+    state    := peer.exchange.tunnel.tlsConn.ConnectionState()
+    msk, err := tlsKeyingMaterial(&state, TLS, "client EAP encryption")
+    if err != nil {
+      return nil, err
+    }
+    peer.msk = msk
+  }
+  return data, nil
+}
+
+// MSK returns Master Session Key derived from TLS session
+func (peer *tlsPeer) MSK() []uint8 {
+  return peer.msk
+}
+
+// Finished reports if TLS handshake is finished and keying material is derived
+func (peer *tlsPeer) Finished() bool {
+  return peer.msk != nil
+}
+
+// Close stops TLS client
+func (peer *tlsPeer) Close() error {
+  return peer.exchange.tunnel.Close()
+}
+
+// eapTLSPeerHandshake runs TLS handshake; with TLS 1.3 it waits for commitment message of server
+func eapTLSPeerHandshake(tlsConn *tls.Conn) error {
+  if err := tlsConn.Handshake(); err != nil {
+    return err
+  }
+
+  if tlsConn.ConnectionState().Version == tls.VersionTLS13 {
+    commitment := make([]uint8, 1)
+    if _, err := io.ReadFull(tlsConn, commitment); err != nil {
+      return err
+    }
+    if commitment[0] != 0 {
+      return errors.New("unexpected application data, TLS 1.3 commitment message is expected")
+    }
+  }
+  return nil
+}
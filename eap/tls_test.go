@@ -0,0 +1,275 @@
+package eap
+
+import (
+  "crypto/rand"
+  "crypto/rsa"
+  "crypto/tls"
+  "crypto/x509"
+  "crypto/x509/pkix"
+  "errors"
+  "math/big"
+  "testing"
+  "time"
+
+  "github.com/stretchr/testify/assert"
+
+  "github.com/MikhailMS/go-radius/protocol"
+  "github.com/MikhailMS/go-radius/tools"
+)
+
+// createTestCertificate creates RSA certificate signed by parent (self-signed if parent is nil)
+func createTestCertificate(t *testing.T, commonName string, isCA bool, parent *tls.Certificate) tls.Certificate {
+  key, err := rsa.GenerateKey(rand.Reader, 2048)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  template := &x509.Certificate {
+    SerialNumber:          big.NewInt(time.Now().UnixNano()),
+    Subject:               pkix.Name { CommonName: commonName },
+    DNSNames:              []string { commonName },
+    NotBefore:             time.Now().Add(-time.Hour),
+    NotAfter:              time.Now().Add(time.Hour),
+    IsCA:                  isCA,
+    BasicConstraintsValid: true,
+    KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageCertSign,
+    ExtKeyUsage:           []x509.ExtKeyUsage { x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth },
+  }
+
+  parentCert, parentKey := template, interface{}(key)
+  var chain [][]uint8
+  if parent != nil {
+    parentCert = parent.Leaf
+    parentKey  = parent.PrivateKey
+    chain      = parent.Certificate
+  }
+
+  der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+  if err != nil {
+    t.Fatal(err)
+  }
+  leaf, _ := x509.ParseCertificate(der)
+
+  return tls.Certificate { Certificate: append([][]uint8{ der }, chain...), PrivateKey: key, Leaf: leaf }
+}
+
+// createTestTLSConfigs creates server & client TLS configs, that trust the same CA
+func createTestTLSConfigs(t *testing.T) (*tls.Config, *tls.Config) {
+  ca         := createTestCertificate(t, "Test CA", true, nil)
+  serverCert := createTestCertificate(t, "radius.example.com", false, &ca)
+  clientCert := createTestCertificate(t, "testing", false, &ca)
+
+  pool := x509.NewCertPool()
+  pool.AddCert(ca.Leaf)
+
+  serverConfig := &tls.Config { Certificates: []tls.Certificate { serverCert }, ClientCAs: pool }
+  clientConfig := &tls.Config { Certificates: []tls.Certificate { clientCert }, RootCAs: pool, ServerName: "radius.example.com" }
+
+  return serverConfig, clientConfig
+}
+
+// exchangeTestEap passes EAP packet inside Access-Request directly to Authenticator and returns
+// request, reply TypeCode & attributes
+func exchangeTestEap(t *testing.T, authenticator *Authenticator, eapPacket *EapPacket, state []uint8) (protocol.RadiusPacket, protocol.RadiusPacket) {
+  request := protocol.InitialiseRadiusPacket(protocol.AccessRequest)
+
+  if len(state) > 0 {
+    stateAttr, _ := protocol.CreateRadAttributeByName(&authenticator.dictionary, "State", &state)
+    request.SetAttributes([]protocol.RadiusAttribute { stateAttr })
+  }
+  SetEapMessage(&authenticator.dictionary, &request, eapPacket)
+
+  code, attributes, err := authenticator.HandleAccessRequest(&request, "secret")
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  // Reply is not signed, so Message-Authenticator is added to satisfy EapPacketFromRadiusPacket
+  msgAuth        := make([]uint8, 16)
+  msgAuthAttr, _ := protocol.CreateRadAttributeByID(&authenticator.dictionary, protocol.MESSAGE_AUTHENTICATOR_ID, &msgAuth)
+
+  reply := protocol.InitialiseRadiusPacket(code)
+  reply.SetAttributes(append(attributes, msgAuthAttr))
+
+  return request, reply
+}
+
+// runTestTLSPeer runs peer side of TLS based method conversation and returns last Access-Request,
+// reply to it and peer TLS connection
+//
+// For tunneled methods inner is called with peer TLS connection and decrypted data, it returns data
+// to be sent back inside the tunnel
+func runTestTLSPeer(t *testing.T, authenticator *Authenticator, eapType EapType, config *tls.Config, inner func(*tlsTunnel, []uint8) []uint8) (protocol.RadiusPacket, protocol.RadiusPacket, *tlsTunnel, bool) {
+  peer := newClientTLSTunnel(config)
+  work := func(tlsConn *tls.Conn) error { return tlsConn.Handshake() }
+  if inner != nil {
+    work = peer.handshakeAndRead
+  }
+  hello, _ := peer.start(work)
+  t.Cleanup(func() { peer.Close() })
+
+  peerFragments := tlsFragments{}
+  peerFragments.send(hello)
+
+  fragmented   := false
+  innerStarted := false
+  eapPacket    := CreateIdentityResponse(1, "testing")
+  var state []uint8
+
+  for {
+    request, reply := exchangeTestEap(t, authenticator, &eapPacket, state)
+    if reply.Code() != protocol.AccessChallenge {
+      return request, reply, peer, fragmented
+    }
+
+    eapRequest, err := EapPacketFromRadiusPacket(&reply)
+    if err != nil {
+      t.Fatal(err)
+    }
+    stateAttr := reply.AttributeByName("State")
+    state      = stateAttr.Value()
+
+    fragmented    = fragmented || eapRequest.Data()[0] & tlsFlagMore != 0
+    complete, err := peerFragments.receive(eapRequest.Data())
+    if err != nil {
+      t.Fatal(err)
+    }
+
+    var data []uint8
+    if complete {
+      message := peerFragments.take()
+      if len(message) > 0 && !peer.finished {
+        output, err := peer.step(message)
+        if err != nil {
+          t.Fatal(err)
+        }
+
+        // Inner function is also called once TLS handshake is finished, as EAP-TTLS peer sends
+        // its AVPs first
+        received := peer.takeReceived()
+        if inner != nil && peer.handshaken && (len(received) > 0 || !innerStarted) {
+          innerStarted = true
+
+          if data := inner(peer, received); len(data) > 0 {
+            record, err := peer.write(data)
+            if err != nil {
+              t.Fatal(err)
+            }
+            output = append(output, record...)
+          }
+        }
+        peerFragments.send(output)
+      }
+    }
+
+    if complete && peerFragments.pending() {
+      data = peerFragments.next()
+    } else {
+      data = peerFragments.ack()
+    }
+    eapPacket = InitialiseEapPacket(Response, eapRequest.ID(), eapType, data)
+  }
+}
+
+func TestTLSMethod(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+
+  serverConfig, clientConfig := createTestTLSConfigs(t)
+
+  for _, maxVersion := range []uint16 { tls.VersionTLS12, tls.VersionTLS13 } {
+    var verifiedIdentity string
+
+    authenticator := InitialiseAuthenticator(dictionary, time.Minute)
+    authenticator.AddMethod(TLS, TLSMethod(serverConfig, func(identity string, state tls.ConnectionState) error {
+      verifiedIdentity = identity
+      return nil
+    }))
+
+    peerConfig           := clientConfig.Clone()
+    peerConfig.MaxVersion = maxVersion
+
+    request, reply, peer, fragmented := runTestTLSPeer(t, authenticator, TLS, peerConfig, nil)
+    assert.Equal(t, protocol.AccessAccept, reply.Code(),     "EAP-TLS peer is not accepted!")
+    assert.Equal(t, "testing",             verifiedIdentity, "CertificateVerifier is not called!")
+    assert.Equal(t, true,                  fragmented,       "TLS handshake is not fragmented!")
+
+    eapSuccess, _ := EapPacketFromRadiusPacket(&reply)
+    assert.Equal(t, Success, eapSuccess.Code(), "EAP-Success is not sent!")
+
+    state       := peer.tlsConn.ConnectionState()
+    expected, _ := tlsKeyingMaterial(&state, TLS, "client EAP encryption")
+    authBytes   := request.Authenticator()
+    secret      := []uint8("secret")
+
+    encryptedKey, _ := reply.VendorSpecificValue(tools.MICROSOFT_VENDOR_ID, tools.MS_MPPE_RECV_KEY)
+    recvKey, _      := tools.DecryptMPPEKey(&encryptedKey, &authBytes, &secret)
+    assert.Equal(t, expected[:32], recvKey, "MS-MPPE-Recv-Key is not derived from TLS session!")
+
+    encryptedKey, _  = reply.VendorSpecificValue(tools.MICROSOFT_VENDOR_ID, tools.MS_MPPE_SEND_KEY)
+    sendKey, _      := tools.DecryptMPPEKey(&encryptedKey, &authBytes, &secret)
+    assert.Equal(t, expected[32:], sendKey, "MS-MPPE-Send-Key is not derived from TLS session!")
+  }
+}
+
+func TestTLSMethodVerifierRejects(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+
+  serverConfig, clientConfig := createTestTLSConfigs(t)
+
+  authenticator := InitialiseAuthenticator(dictionary, time.Minute)
+  authenticator.AddMethod(TLS, TLSMethod(serverConfig, func(identity string, state tls.ConnectionState) error {
+    if state.PeerCertificates[0].Subject.CommonName != "someone else" {
+      return errors.New("certificate does not match identity")
+    }
+    return nil
+  }))
+
+  _, reply, _, _ := runTestTLSPeer(t, authenticator, TLS, clientConfig, nil)
+  assert.Equal(t, protocol.AccessReject, reply.Code(), "Peer rejected by CertificateVerifier is accepted!")
+
+  eapFailure, _ := EapPacketFromRadiusPacket(&reply)
+  assert.Equal(t, Failure, eapFailure.Code(), "EAP-Failure is not sent!")
+}
+
+func TestTLSFragments(t *testing.T) {
+  message := make([]uint8, TLS_FRAGMENT_SIZE + 10)
+  for i := range message {
+    message[i] = uint8(i)
+  }
+
+  sender := tlsFragments{}
+  sender.send(message)
+
+  first := sender.next()
+  assert.Equal(t, tlsFlagLength | tlsFlagMore, first[0],   "First fragment flags are not correct!")
+  assert.Equal(t, []uint8{ 0, 0, 0x04, 0x0A }, first[1:5], "TLS Message Length is not correct!")
+  assert.Equal(t, 5 + TLS_FRAGMENT_SIZE,       len(first), "First fragment length is not correct!")
+
+  last := sender.next()
+  assert.Equal(t, uint8(0), last[0],          "Last fragment flags are not correct!")
+  assert.Equal(t, false,    sender.pending(), "Message is not fully sent!")
+
+  receiver    := tlsFragments{}
+  complete, _ := receiver.receive(first)
+  assert.Equal(t, false, complete, "Fragmented message is complete after first fragment!")
+
+  complete, _ = receiver.receive(last)
+  assert.Equal(t, true,    complete,        "Fragmented message is not complete after last fragment!")
+  assert.Equal(t, message, receiver.take(), "Reassembled message is not correct!")
+
+  _, err := receiver.receive([]uint8{ tlsFlagLength, 0xFF, 0xFF, 0xFF, 0xFF })
+  assert.Equal(t, "EAP-TLS message is too long", err.Error(), "Too long TLS message is accepted!")
+
+  // Reassembled message should be as long as announced in TLS Message Length
+  receiver = tlsFragments{}
+  receiver.receive(first)
+  _, err = receiver.receive(append(last, 0))
+  assert.Equal(t, "EAP-TLS message is too long", err.Error(), "TLS message longer than announced is accepted!")
+
+  receiver = tlsFragments{}
+  receiver.receive(first)
+  _, err = receiver.receive(last[:len(last) - 1])
+  assert.Equal(t, "EAP-TLS message is shorter than TLS Message Length", err.Error(), "TLS message shorter than announced is accepted!")
+}
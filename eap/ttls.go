@@ -0,0 +1,306 @@
+package eap
+
+import (
+  "bytes"
+  "crypto/subtle"
+  "crypto/tls"
+  "encoding/binary"
+  "errors"
+  "fmt"
+
+  "github.com/MikhailMS/go-radius/protocol"
+  "github.com/MikhailMS/go-radius/server"
+  "github.com/MikhailMS/go-radius/tools"
+)
+
+// Flags of Diameter AVP header (RFC 5281, section 10.1)
+const (
+  avpFlagVendor    uint8 = 0x80
+  avpFlagMandatory uint8 = 0x40
+)
+
+// IDs of RADIUS attributes carrying inner credentials; they are looked up by ID, as their names
+// differ between dictionaries
+const (
+  userNameID      uint8 = 1
+  userPasswordID  uint8 = 2
+  chapPasswordID  uint8 = 3
+  chapChallengeID uint8 = 60
+)
+
+// AttributeCreator creates RadiusAttribute by its ID; both client.Client & server.Server implement it
+type AttributeCreator interface {
+  CreateAttributeByID(attrID uint8, value *[]uint8) (protocol.RadiusAttribute, error)
+}
+
+// DiameterAVPsToAttributes decodes Diameter AVPs carried inside EAP-TTLS tunnel into RADIUS
+// attributes
+//
+// AVPs with Vendor-ID are converted into Vendor-Specific attributes. AVPs, that could not be
+// represented as RADIUS attributes, are skipped, unless they are marked as mandatory
+func DiameterAVPsToAttributes(creator AttributeCreator, avps []uint8) ([]protocol.RadiusAttribute, error) {
+  /*
+   *  0                   1                   2                   3
+      0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+     +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+     |                           AVP Code                            |
+     +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+     |V M r r r r r r|                  AVP Length                   |
+     +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+     |                        Vendor-ID (opt)                        |
+     +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+     |    Data ...
+     +-+-+-+-+-+-+-+-+
+   * Taken from https://tools.ietf.org/html/rfc5281#section-10.1
+  */
+  var attributes []protocol.RadiusAttribute
+
+  for len(avps) > 0 {
+    if len(avps) < 8 {
+      return nil, errors.New("malformed Diameter AVP")
+    }
+
+    code      := binary.BigEndian.Uint32(avps[0:4])
+    flags     := avps[4]
+    length    := int(binary.BigEndian.Uint32(avps[4:8]) & 0x00FFFFFF)
+    headerLen := 8
+    if flags & avpFlagVendor != 0 {
+      headerLen = 12
+    }
+
+    if length < headerLen || length > len(avps) {
+      return nil, errors.New("malformed Diameter AVP")
+    }
+
+    data := avps[headerLen:length]
+    var vendorID uint32
+    if flags & avpFlagVendor != 0 {
+      vendorID = binary.BigEndian.Uint32(avps[8:12])
+    }
+
+    // AVPs are padded to 4 octets boundary, padding is not included in AVP Length
+    padded := (length + 3) &^ 3
+    if padded > len(avps) {
+      padded = len(avps)
+    }
+    avps = avps[padded:]
+
+    var attr protocol.RadiusAttribute
+    var err error
+
+    switch {
+      case code > 255 || len(data) > 253:
+        err = errors.New("AVP could not be represented as RADIUS attribute")
+      case vendorID != 0:
+        vsaBytes := tools.VendorSpecificToBytes(vendorID, uint8(code), &data)
+        attr, err = creator.CreateAttributeByID(protocol.VENDOR_SPECIFIC_ID, &vsaBytes)
+      default:
+        value    := append([]uint8{}, data...)
+        attr, err = creator.CreateAttributeByID(uint8(code), &value)
+    }
+
+    if err != nil {
+      if flags & avpFlagMandatory != 0 {
+        return nil, errors.New(fmt.Sprintf("unsupported mandatory Diameter AVP %d (vendor %d)", code, vendorID))
+      }
+      continue
+    }
+    attributes = append(attributes, attr)
+  }
+
+  return attributes, nil
+}
+
+// DiameterAVP encodes single Diameter AVP with mandatory flag set, including padding; vendorID of 0
+// means AVP has no Vendor-ID
+func DiameterAVP(code uint32, vendorID uint32, data []uint8) []uint8 {
+  headerLen := 8
+  flags     := avpFlagMandatory
+  if vendorID != 0 {
+    headerLen = 12
+    flags    |= avpFlagVendor
+  }
+
+  length := headerLen + len(data)
+  avp    := make([]uint8, headerLen, (length + 3) &^ 3)
+
+  binary.BigEndian.PutUint32(avp[0:4], code)
+  binary.BigEndian.PutUint32(avp[4:8], uint32(length))
+  avp[4] = flags
+  if vendorID != 0 {
+    binary.BigEndian.PutUint32(avp[8:12], vendorID)
+  }
+
+  avp = append(avp, data...)
+  return append(avp, make([]uint8, cap(avp) - len(avp))...)
+}
+
+// ttlsMethod is server side of EAP-TTLSv0 (RFC 5281) with inner PAP, CHAP & MS-CHAPv2
+type ttlsMethod struct {
+  config      *tls.Config
+  radServer   *server.Server
+  lookup      PasswordLookup
+  tunnel      *tlsTunnel
+  fragments   tlsFragments
+  awaitingAck bool
+  msk         []uint8
+}
+
+// TTLSMethod returns MethodFactory of EAP-TTLS method, that authenticates inner PAP, CHAP &
+// MS-CHAPv2 credentials with verification helpers of radServer against cleartext password returned
+// by lookup
+//
+// TLS 1.2 is the highest version EAP-TTLS is offered over, so MaxVersion of config is lowered
+func TTLSMethod(config *tls.Config, radServer *server.Server, lookup PasswordLookup) MethodFactory {
+  return func() Method {
+    return &ttlsMethod { config: config, radServer: radServer, lookup: lookup }
+  }
+}
+
+// Type returns EAP Type of the method
+func (method *ttlsMethod) Type() EapType {
+  return TTLS
+}
+
+// Initiate starts TLS server and returns Type-Data of EAP-TTLS Start packet
+func (method *ttlsMethod) Initiate(session *Session) ([]uint8, error) {
+  method.tunnel = newServerTLSTunnel(tunneledTLSConfig(method.config))
+  if _, err := method.tunnel.start(method.tunnel.handshakeAndRead); err != nil {
+    return nil, err
+  }
+
+  return []uint8{ tlsFlagStart }, nil
+}
+
+// Process handles EAP-TTLS response: during TLS handshake it works as EAP-TLS, afterwards it
+// decrypts AVPs and verifies credentials they carry
+func (method *ttlsMethod) Process(session *Session, response *EapPacket) (MethodResult, []uint8, error) {
+  next, message, err := method.fragments.handle(response.Data())
+  if err != nil {
+    return Failed, nil, err
+  }
+  if next != nil {
+    return Continue, next, nil
+  }
+
+  if len(message) == 0 {
+    // Peer acknowledged MS-CHAP2-Success
+    if method.awaitingAck {
+      return method.finish()
+    }
+    return Failed, nil, errors.New("unexpected EAP-TTLS acknowledgement")
+  }
+
+  output, err := method.tunnel.step(message)
+  if err != nil {
+    return Failed, nil, err
+  }
+
+  if avps := method.tunnel.takeReceived(); len(avps) > 0 {
+    return method.processAVPs(avps)
+  }
+  if len(output) == 0 {
+    return Failed, nil, errors.New("TLS handshake produced no reply")
+  }
+
+  method.fragments.send(output)
+  return Continue, method.fragments.next(), nil
+}
+
+// MSK returns Master Session Key derived from TLS session
+func (method *ttlsMethod) MSK() []uint8 {
+  return method.msk
+}
+
+// Close stops TLS server
+func (method *ttlsMethod) Close() error {
+  if method.tunnel == nil {
+    return nil
+  }
+  return method.tunnel.Close()
+}
+
+// processAVPs verifies inner credentials
+func (method *ttlsMethod) processAVPs(avps []uint8) (MethodResult, []uint8, error) {
+  if method.awaitingAck {
+    return Failed, nil, errors.New("unexpected AVPs, acknowledgement is expected")
+  }
+
+  attributes, err := DiameterAVPsToAttributes(method.radServer, avps)
+  if err != nil {
+    return Failed, nil, err
+  }
+
+  request := protocol.InitialiseRadiusPacket(protocol.AccessRequest)
+  request.SetAttributes(attributes)
+
+  userName     := request.AttributeByID(userNameID)
+  password, ok := method.lookup(string(userName.Value()))
+  if !ok {
+    return Failed, nil, nil
+  }
+
+  state          := method.tunnel.tlsConn.ConnectionState()
+  challenge, err := state.ExportKeyingMaterial("ttls challenge", nil, 17)
+  if err != nil {
+    return Failed, nil, err
+  }
+
+  userPassword         := request.AttributeByID(userPasswordID)
+  chapPassword         := request.AttributeByID(chapPasswordID)
+  msChapV2, isMSChapV2 := request.VendorSpecificValue(tools.MICROSOFT_VENDOR_ID, tools.MS_CHAP2_RESPONSE)
+
+  switch {
+    case len(userPassword.Value()) > 0:
+      // Password is sent in clear inside the tunnel, but could be padded with zeros
+      if subtle.ConstantTimeCompare(bytes.TrimRight(userPassword.Value(), "\x00"), password) != 1 {
+        return Failed, nil, nil
+      }
+      return method.finish()
+    case len(chapPassword.Value()) > 0:
+      // CHAP-Challenge & CHAP Identifier must be derived from TLS session (RFC 5281, section 11.2.2)
+      chapChallenge := request.AttributeByID(chapChallengeID)
+      if !bytes.Equal(chapChallenge.Value(), challenge[:16]) || chapPassword.Value()[0] != challenge[16] {
+        return Failed, nil, errors.New("CHAP-Challenge is not derived from TLS session")
+      }
+      if err := method.radServer.VerifyChapPassword(&request, password); err != nil {
+        return Failed, nil, nil
+      }
+      return method.finish()
+    case isMSChapV2:
+      msChapChallenge, _ := request.VendorSpecificValue(tools.MICROSOFT_VENDOR_ID, tools.MS_CHAP_CHALLENGE)
+      if !bytes.Equal(msChapChallenge, challenge[:16]) || len(msChapV2) == 0 || msChapV2[0] != challenge[16] {
+        return Failed, nil, errors.New("MS-CHAP-Challenge is not derived from TLS session")
+      }
+
+      // Keying material is derived from TLS session, so MS-CHAPv2 MPPE keys are not needed
+      success, err := method.radServer.VerifyMSChapV2Response(&request, password)
+      if err != nil {
+        return Failed, nil, nil
+      }
+
+      // Peer has to verify MS-CHAP2-Success and acknowledge it with empty EAP-TTLS packet
+      record, err := method.tunnel.write(DiameterAVP(tools.MS_CHAP2_SUCCESS, tools.MICROSOFT_VENDOR_ID, success))
+      if err != nil {
+        return Failed, nil, err
+      }
+
+      method.awaitingAck = true
+      method.fragments.send(record)
+      return Continue, method.fragments.next(), nil
+    default:
+      return Failed, nil, errors.New("AVPs carry no supported credentials")
+  }
+}
+
+// finish derives keying material
+func (method *ttlsMethod) finish() (MethodResult, []uint8, error) {
+  state    := method.tunnel.tlsConn.ConnectionState()
+  msk, err := tlsKeyingMaterial(&state, TTLS, "ttls keying material")
+  if err != nil {
+    return Failed, nil, err
+  }
+  method.msk = msk
+
+  return Succeeded, nil, nil
+}
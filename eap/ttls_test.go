@@ -0,0 +1,139 @@
+package eap
+
+import (
+  "testing"
+  "time"
+
+  "github.com/stretchr/testify/assert"
+
+  "github.com/MikhailMS/go-radius/protocol"
+  "github.com/MikhailMS/go-radius/server"
+  "github.com/MikhailMS/go-radius/tools"
+)
+
+// testTtlsPeer returns inner function of EAP-TTLS peer, that sends credentials of given inner method
+func testTtlsPeer(t *testing.T, innerMethod string, password string) func(*tlsTunnel, []uint8) []uint8 {
+  username      := []uint8("testing")
+  passwordBytes := []uint8(password)
+  var authChallenge, peerChallenge, ntResponse []uint8
+  var ident uint8
+
+  return func(peer *tlsTunnel, received []uint8) []uint8 {
+    if len(received) > 0 {
+      // Server sends MS-CHAP2-Success, that peer verifies and acknowledges
+      expected := tools.MSChapV2AuthenticatorResponse(&passwordBytes, &ntResponse, &peerChallenge, &authChallenge, &username)
+      success  := append([]uint8{ ident }, []uint8(expected)...)
+      assert.Equal(t, DiameterAVP(tools.MS_CHAP2_SUCCESS, tools.MICROSOFT_VENDOR_ID, success), received, "MS-CHAP2-Success is not correct!")
+      return nil
+    }
+
+    state          := peer.tlsConn.ConnectionState()
+    challenge, err := state.ExportKeyingMaterial("ttls challenge", nil, 17)
+    if err != nil {
+      t.Fatal(err)
+    }
+
+    avps := DiameterAVP(uint32(userNameID), 0, username)
+
+    switch innerMethod {
+      case "PAP":
+        // Password is padded to multiple of 16 octets
+        padded := make([]uint8, 16)
+        copy(padded, passwordBytes)
+        avps = append(avps, DiameterAVP(uint32(userPasswordID), 0, padded)...)
+      case "CHAP":
+        chapChallenge := challenge[:16]
+        response      := tools.ChapResponse(challenge[16], &passwordBytes, &chapChallenge)
+        avps = append(avps, DiameterAVP(uint32(chapChallengeID), 0, chapChallenge)...)
+        avps = append(avps, DiameterAVP(uint32(chapPasswordID), 0, append([]uint8{ challenge[16] }, response...))...)
+      case "MSCHAPv2":
+        authChallenge = challenge[:16]
+        ident         = challenge[16]
+        peerChallenge = make([]uint8, 16)
+        ntResponse    = tools.MSChapV2NTResponse(&authChallenge, &peerChallenge, &username, &passwordBytes)
+
+        response := []uint8{ ident, 0 }
+        response  = append(response, peerChallenge...)
+        response  = append(response, make([]uint8, 8)...)
+        response  = append(response, ntResponse...)
+
+        avps = append(avps, DiameterAVP(tools.MS_CHAP_CHALLENGE, tools.MICROSOFT_VENDOR_ID, authChallenge)...)
+        avps = append(avps, DiameterAVP(tools.MS_CHAP2_RESPONSE, tools.MICROSOFT_VENDOR_ID, response)...)
+    }
+
+    return avps
+  }
+}
+
+func TestTTLSMethod(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+  radServer     := server.InitialiseServer(dictionary, map[string]string { "127.0.0.1": "secret" }, "127.0.0.1", 1, 2)
+
+  serverConfig, clientConfig := createTestTLSConfigs(t)
+  clientConfig.Certificates   = nil
+
+  for _, testCase := range []struct {
+    innerMethod  string
+    password     string
+    expectedCode protocol.TypeCode
+  } {
+    { "PAP",      "password", protocol.AccessAccept },
+    { "PAP",      "wrong",    protocol.AccessReject },
+    { "CHAP",     "password", protocol.AccessAccept },
+    { "CHAP",     "wrong",    protocol.AccessReject },
+    { "MSCHAPv2", "password", protocol.AccessAccept },
+    { "MSCHAPv2", "wrong",    protocol.AccessReject },
+  } {
+    authenticator := InitialiseAuthenticator(dictionary, time.Minute)
+    authenticator.AddMethod(TTLS, TTLSMethod(serverConfig, &radServer, testPasswordLookup))
+
+    inner := testTtlsPeer(t, testCase.innerMethod, testCase.password)
+
+    request, reply, peer, _ := runTestTLSPeer(t, authenticator, TTLS, clientConfig, inner)
+    assert.Equal(t, testCase.expectedCode, reply.Code(), "EAP-TTLS reply is not correct for " + testCase.innerMethod + "!")
+
+    if testCase.expectedCode != protocol.AccessAccept {
+      continue
+    }
+
+    state       := peer.tlsConn.ConnectionState()
+    expected, _ := tlsKeyingMaterial(&state, TTLS, "ttls keying material")
+    authBytes   := request.Authenticator()
+    secret      := []uint8("secret")
+
+    encryptedKey, _ := reply.VendorSpecificValue(tools.MICROSOFT_VENDOR_ID, tools.MS_MPPE_RECV_KEY)
+    recvKey, _      := tools.DecryptMPPEKey(&encryptedKey, &authBytes, &secret)
+    assert.Equal(t, expected[:32], recvKey, "MS-MPPE-Recv-Key is not derived from TLS session!")
+  }
+}
+
+func TestDiameterAVPsToAttributes(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+  radServer     := server.InitialiseServer(dictionary, map[string]string{}, "127.0.0.1", 1, 2)
+
+  challenge := []uint8{ 1, 2, 3, 4, 5, 6, 7, 8 }
+
+  avps := DiameterAVP(1, 0, []uint8("testing"))
+  avps  = append(avps, DiameterAVP(tools.MS_CHAP_CHALLENGE, tools.MICROSOFT_VENDOR_ID, challenge)...)
+
+  // 7 octets of data are padded to 8 octets
+  assert.Equal(t, 16, len(DiameterAVP(1, 0, []uint8("testing"))), "AVP is not padded!")
+
+  attributes, err := DiameterAVPsToAttributes(&radServer, avps)
+  assert.Equal(t, nil, err, "AVPs are not decoded!")
+  assert.Equal(t, 2,   len(attributes), "Not all AVPs are decoded!")
+
+  radPacket := protocol.InitialiseRadiusPacket(protocol.AccessRequest)
+  radPacket.SetAttributes(attributes)
+
+  userName := radPacket.AttributeByName("User-Name")
+  assert.Equal(t, []uint8("testing"), userName.Value(), "User-Name AVP is not decoded!")
+
+  value, _ := radPacket.VendorSpecificValue(tools.MICROSOFT_VENDOR_ID, tools.MS_CHAP_CHALLENGE)
+  assert.Equal(t, challenge, value, "Vendor-Specific AVP is not decoded!")
+
+  _, err = DiameterAVPsToAttributes(&radServer, DiameterAVP(1000, 0, []uint8{ 1 }))
+  assert.Equal(t, "unsupported mandatory Diameter AVP 1000 (vendor 0)", err.Error(), "Unsupported mandatory AVP is accepted!")
+}
@@ -0,0 +1,394 @@
+package eap
+
+import (
+  "crypto/tls"
+  "encoding/binary"
+  "errors"
+  "io"
+  "net"
+  "sync"
+  "time"
+)
+
+const (
+  // TLS_FRAGMENT_SIZE is the maximum number of TLS message octets sent in single EAP packet
+  TLS_FRAGMENT_SIZE = 1024
+  // MAX_TLS_MESSAGE_LENGTH is the maximum length of reassembled TLS message, that peer could send
+  MAX_TLS_MESSAGE_LENGTH = 65536
+)
+
+// Flags of EAP-TLS Type-Data (RFC 5216, section 3.1), shared by PEAP & EAP-TTLS, that also put
+// their version into 3 least significant bits
+const (
+  tlsFlagLength  uint8 = 0x80
+  tlsFlagMore    uint8 = 0x40
+  tlsFlagStart   uint8 = 0x20
+  tlsVersionMask uint8 = 0x07
+)
+
+// memoryConn is in-memory net.Conn, that connects crypto/tls to TLS messages carried in EAP
+// packets
+//
+// Every time TLS needs more input, Read notifies tlsTunnel via wants channel and blocks until next
+// TLS message is received from peer; everything TLS writes is buffered until tlsTunnel drains it
+type memoryConn struct {
+  input   chan []uint8
+  wants   chan struct{}
+  closed  chan struct{}
+  once    sync.Once
+
+  pending []uint8
+  mutex   sync.Mutex
+  output  []uint8
+}
+
+func newMemoryConn() *memoryConn {
+  return &memoryConn {
+    input:  make(chan []uint8),
+    wants:  make(chan struct{}),
+    closed: make(chan struct{}),
+  }
+}
+
+func (conn *memoryConn) Read(b []uint8) (int, error) {
+  for len(conn.pending) == 0 {
+    select {
+      case conn.wants <- struct{}{}:
+      case <-conn.closed:
+        return 0, io.EOF
+    }
+
+    select {
+      case data := <-conn.input:
+        conn.pending = data
+      case <-conn.closed:
+        return 0, io.EOF
+    }
+  }
+
+  n := copy(b, conn.pending)
+  conn.pending = conn.pending[n:]
+  return n, nil
+}
+
+func (conn *memoryConn) Write(b []uint8) (int, error) {
+  conn.mutex.Lock()
+  defer conn.mutex.Unlock()
+
+  conn.output = append(conn.output, b...)
+  return len(b), nil
+}
+
+func (conn *memoryConn) Close() error {
+  conn.once.Do(func() { close(conn.closed) })
+  return nil
+}
+
+// drain returns everything TLS has written since last call
+func (conn *memoryConn) drain() []uint8 {
+  conn.mutex.Lock()
+  defer conn.mutex.Unlock()
+
+  output     := conn.output
+  conn.output = nil
+  return output
+}
+
+func (conn *memoryConn) LocalAddr() net.Addr                { return nil }
+func (conn *memoryConn) RemoteAddr() net.Addr               { return nil }
+func (conn *memoryConn) SetDeadline(t time.Time) error      { return nil }
+func (conn *memoryConn) SetReadDeadline(t time.Time) error  { return nil }
+func (conn *memoryConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// tlsTunnel runs blocking crypto/tls code (handshake and, for tunneled methods, exchange of inner
+// messages) in its own goroutine and steps it one TLS message at a time
+type tlsTunnel struct {
+  conn       *memoryConn
+  tlsConn    *tls.Conn
+  done       chan error
+  finished   bool
+
+  // Fields below are written by the goroutine of tlsTunnel before it asks for more input, so they
+  // are safe to access once step returns
+  handshaken bool
+  received   []uint8
+}
+
+// newServerTLSTunnel creates tlsTunnel for server side of TLS connection
+func newServerTLSTunnel(config *tls.Config) *tlsTunnel {
+  conn := newMemoryConn()
+  return &tlsTunnel { conn: conn, tlsConn: tls.Server(conn, config), done: make(chan error, 1) }
+}
+
+// newClientTLSTunnel creates tlsTunnel for client (peer) side of TLS connection
+func newClientTLSTunnel(config *tls.Config) *tlsTunnel {
+  conn := newMemoryConn()
+  return &tlsTunnel { conn: conn, tlsConn: tls.Client(conn, config), done: make(chan error, 1) }
+}
+
+// start runs work in separate goroutine and returns TLS data it has written before it needed
+// input from peer or finished
+func (tunnel *tlsTunnel) start(work func(tlsConn *tls.Conn) error) ([]uint8, error) {
+  go func() {
+    tunnel.done <- work(tunnel.tlsConn)
+  }()
+
+  return tunnel.wait()
+}
+
+// step passes TLS message received from peer to tlsTunnel and returns TLS data written in response
+func (tunnel *tlsTunnel) step(message []uint8) ([]uint8, error) {
+  if tunnel.finished {
+    return nil, errors.New("TLS tunnel is finished")
+  }
+
+  tunnel.conn.input <- message
+  return tunnel.wait()
+}
+
+// wait blocks until work needs more input or is finished
+func (tunnel *tlsTunnel) wait() ([]uint8, error) {
+  select {
+    case <-tunnel.conn.wants:
+      return tunnel.conn.drain(), nil
+    case err := <-tunnel.done:
+      tunnel.finished = true
+      return tunnel.conn.drain(), err
+  }
+}
+
+// write encrypts application data and returns TLS records to be sent to peer
+//
+// tls.Conn allows Write to be called while its Read is blocked in another goroutine
+func (tunnel *tlsTunnel) write(data []uint8) ([]uint8, error) {
+  if _, err := tunnel.tlsConn.Write(data); err != nil {
+    return nil, err
+  }
+  return tunnel.conn.drain(), nil
+}
+
+// takeReceived returns application data decrypted since last call
+func (tunnel *tlsTunnel) takeReceived() []uint8 {
+  received       := tunnel.received
+  tunnel.received = nil
+  return received
+}
+
+// handshakeAndRead is work of tunneled methods: it runs TLS handshake and then keeps reading
+// application data, which is collected for takeReceived
+func (tunnel *tlsTunnel) handshakeAndRead(tlsConn *tls.Conn) error {
+  if err := tlsConn.Handshake(); err != nil {
+    return err
+  }
+  tunnel.handshaken = true
+
+  buffer := make([]uint8, 16384)
+  for {
+    n, err := tlsConn.Read(buffer)
+    if err != nil {
+      return err
+    }
+    tunnel.received = append(tunnel.received, buffer[:n]...)
+  }
+}
+
+// Close stops goroutine of tlsTunnel, if it is still running
+func (tunnel *tlsTunnel) Close() error {
+  return tunnel.conn.Close()
+}
+
+// tlsFragments reassembles TLS messages received in EAP-TLS fragments and splits TLS messages
+// into fragments for sending
+type tlsFragments struct {
+  version  uint8
+  incoming []uint8
+  expected int
+  outgoing []uint8
+  total    int
+}
+
+// receive adds fragment from Type-Data of received EAP packet and reports if TLS message is
+// complete, which is also true for Start and acknowledgement packets, that carry no TLS data
+//
+// TLS Message Length, announced with the first fragment, limits reassembled message, which should
+// be exactly that long once the last fragment is received
+func (fragments *tlsFragments) receive(data []uint8) (bool, error) {
+  if len(data) < 1 {
+    return false, errors.New("EAP-TLS packet has no Flags")
+  }
+
+  flags   := data[0]
+  payload := data[1:]
+
+  if flags & tlsFlagLength != 0 {
+    if len(payload) < 4 {
+      return false, errors.New("EAP-TLS packet has no TLS Message Length")
+    }
+
+    length := binary.BigEndian.Uint32(payload[:4])
+    if length > MAX_TLS_MESSAGE_LENGTH {
+      return false, errors.New("EAP-TLS message is too long")
+    }
+
+    // Some peers repeat TLS Message Length in every fragment, so it only has to stay the same
+    if len(fragments.incoming) == 0 {
+      fragments.expected = int(length)
+    } else if int(length) != fragments.expected {
+      return false, errors.New("EAP-TLS message length has changed between fragments")
+    }
+    payload = payload[4:]
+  }
+
+  limit := MAX_TLS_MESSAGE_LENGTH
+  if fragments.expected > 0 {
+    limit = fragments.expected
+  }
+  if len(fragments.incoming) + len(payload) > limit {
+    return false, errors.New("EAP-TLS message is too long")
+  }
+  fragments.incoming = append(fragments.incoming, payload...)
+
+  if flags & tlsFlagMore != 0 {
+    return false, nil
+  }
+
+  if fragments.expected > 0 && len(fragments.incoming) != fragments.expected {
+    return false, errors.New("EAP-TLS message is shorter than TLS Message Length")
+  }
+  return true, nil
+}
+
+// handle processes Type-Data of received EAP packet
+//
+// If received fragment is not the last one, or peer acknowledged fragment of queued message, Type-Data
+// of the next request/response is returned; otherwise reassembled TLS message is returned, which
+// is empty if peer acknowledged the last fragment
+func (fragments *tlsFragments) handle(data []uint8) ([]uint8, []uint8, error) {
+  complete, err := fragments.receive(data)
+  if err != nil {
+    return nil, nil, err
+  }
+  if !complete {
+    return fragments.ack(), nil, nil
+  }
+
+  message := fragments.take()
+  if len(message) == 0 && fragments.pending() {
+    return fragments.next(), nil, nil
+  }
+  return nil, message, nil
+}
+
+// take returns reassembled TLS message
+func (fragments *tlsFragments) take() []uint8 {
+  message            := fragments.incoming
+  fragments.incoming  = nil
+  fragments.expected  = 0
+  return message
+}
+
+// send queues TLS message to be sent in fragments
+func (fragments *tlsFragments) send(message []uint8) {
+  fragments.outgoing = message
+  fragments.total    = len(message)
+}
+
+// pending reports if there are fragments of queued TLS message, that are not sent yet
+func (fragments *tlsFragments) pending() bool {
+  return len(fragments.outgoing) > 0
+}
+
+// next returns Type-Data with next fragment of queued TLS message
+//
+// TLS Message Length is included in the first fragment of message, that doesn't fit into single
+// EAP packet
+func (fragments *tlsFragments) next() []uint8 {
+  flags := fragments.version
+  size  := len(fragments.outgoing)
+
+  if size > TLS_FRAGMENT_SIZE {
+    size   = TLS_FRAGMENT_SIZE
+    flags |= tlsFlagMore
+  }
+
+  data := []uint8{ flags }
+  if len(fragments.outgoing) == fragments.total && flags & tlsFlagMore != 0 {
+    data[0] |= tlsFlagLength
+
+    length := make([]uint8, 4)
+    binary.BigEndian.PutUint32(length, uint32(fragments.total))
+    data = append(data, length...)
+  }
+
+  data               = append(data, fragments.outgoing[:size]...)
+  fragments.outgoing = fragments.outgoing[size:]
+  return data
+}
+
+// ack returns Type-Data, that acknowledges received fragment
+func (fragments *tlsFragments) ack() []uint8 {
+  return []uint8{ fragments.version }
+}
+
+// tlsPeerExchange is client side of TLS carried in EAP-TLS framing, shared by peer methods
+type tlsPeerExchange struct {
+  tunnel    *tlsTunnel
+  fragments tlsFragments
+  started   bool
+}
+
+// process handles Type-Data of EAP-Request and returns Type-Data of EAP-Response
+//
+// Start packet runs work in TLS client; TLS messages are passed to TLS client, and application data
+// it decrypts is handed to inner (if any), which returns application data to be sent back
+func (exchange *tlsPeerExchange) process(data []uint8, work func(tlsConn *tls.Conn) error, inner func(received []uint8) ([]uint8, error)) ([]uint8, error) {
+  if !exchange.started {
+    if len(data) < 1 || data[0] & tlsFlagStart == 0 {
+      return nil, errors.New("EAP-TLS Start is expected")
+    }
+    exchange.started = true
+
+    hello, err := exchange.tunnel.start(work)
+    if err != nil {
+      return nil, err
+    }
+    exchange.fragments.send(hello)
+    return exchange.fragments.next(), nil
+  }
+
+  next, message, err := exchange.fragments.handle(data)
+  if err != nil {
+    return nil, err
+  }
+  if next != nil {
+    return next, nil
+  }
+  if len(message) == 0 {
+    return nil, errors.New("unexpected EAP-TLS acknowledgement")
+  }
+
+  output, err := exchange.tunnel.step(message)
+  if err != nil {
+    return nil, err
+  }
+
+  if received := exchange.tunnel.takeReceived(); inner != nil && len(received) > 0 {
+    reply, err := inner(received)
+    if err != nil {
+      return nil, err
+    }
+    if len(reply) > 0 {
+      record, err := exchange.tunnel.write(reply)
+      if err != nil {
+        return nil, err
+      }
+      output = append(output, record...)
+    }
+  }
+
+  if len(output) == 0 {
+    return exchange.fragments.ack(), nil
+  }
+  exchange.fragments.send(output)
+  return exchange.fragments.next(), nil
+}
@@ -94,7 +94,7 @@ func (dv *DictionaryValue) Value() string {
 // Represents a VENDOR from RADIUS dictionary file
 type DictionaryVendor struct {
   name string
-  id   uint8
+  id   uint32
 }
 // =============================
 
@@ -207,10 +207,11 @@ func parseValue(parsedLine []string, vendorName string, values *[]DictionaryValu
 }
 
 func parseVendor(parsedLine []string, vendors *[]DictionaryVendor) {
-  value, err := strconv.ParseUint(parsedLine[2], 10, 8) // Doesn't really converts to uint8, require further cast
+  // Vendor-Id is 4 octets long, as defined in RFC 2865
+  value, err := strconv.ParseUint(parsedLine[2], 10, 32) // Doesn't really converts to uint32, require further cast
   if err != nil {
     panic(err)
   }
 
-  *vendors = append(*vendors, DictionaryVendor{parsedLine[1], uint8(value)})
+  *vendors = append(*vendors, DictionaryVendor{parsedLine[1], uint32(value)})
 }
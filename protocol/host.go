@@ -3,8 +3,6 @@ package protocol
 
 import (
   "fmt"
-  "crypto/md5"
-  "crypto/hmac"
   "errors"
 )
 
@@ -145,36 +143,28 @@ func (host *Host) VerifyPacketAttributes(packet *[]uint8) error {
   return nil
 }
 
-// VerifyMessageauthenticator verifies Message-Authenticator value
+// VerifyMessageAuthenticator verifies Message-Authenticator value of a request
+//
+// Verification is done over the bytes as they were received, so attributes, that are not
+// present in dictionary, do not affect the result
 func (host *Host) VerifyMessageAuthenticator(secret string, packet *[]uint8) error {
-  // Step 1. Get Message-Authenticator from packet
-  radPacket, err := InitialiseRadiusPacketFromBytes(&host.dictionary, packet)
-  if err != nil {
-    return err
-  }
-
-  originalMsgAuth, err := radPacket.MessageAuthenticator()
-  if err != nil {
-    return err
-  }
-
-  // Step 2. Set Message-Authenticator in packet to [0; 16]
-  zeroedAuthenticator := make([]uint8, 16)
-  radPacket.OverrideMessageAuthenticator(zeroedAuthenticator)
-
-  // Step 3. Calculate HMAC-MD5 for the packet
-  packetBytes, ok := radPacket.ToBytes()
-  if !ok {
-    return errors.New("Failed to convert RadiusPacket to bytes")
-  }
+  return verifyMessageAuthenticator(secret, *packet, nil)
+}
 
-  calculatedHash := hmac.New(md5.New, []uint8(secret))
-  calculatedHash.Write(packetBytes)
+// VerifyReplyMessageAuthenticator verifies Message-Authenticator value of a reply, which is
+// calculated over the authenticator of the request
+func (host *Host) VerifyReplyMessageAuthenticator(secret string, reply *[]uint8, requestAuthenticator []uint8) error {
+  return verifyMessageAuthenticator(secret, *reply, requestAuthenticator)
+}
 
-  // Step 4. Compare calculated hash with the one extracted in Step 1
-  if hmac.Equal(originalMsgAuth, calculatedHash.Sum(nil)) {
-    return nil
-  }
-  return errors.New("Packet Message-Authenticator mismatch")
+// VerifyReplyAuthenticator verifies authenticator of a reply, which is calculated over the
+// authenticator of the request
+func (host *Host) VerifyReplyAuthenticator(secret string, reply *[]uint8, requestAuthenticator []uint8) error {
+  return verifyResponseAuthenticator(secret, *reply, requestAuthenticator)
 }
 
+// VerifyRequestAuthenticator verifies authenticator of Accounting-Request, CoA-Request or
+// Disconnect-Request, which is calculated over 16 zero octets instead of random value
+func (host *Host) VerifyRequestAuthenticator(secret string, packet *[]uint8) error {
+  return verifyRequestAuthenticator(secret, *packet)
+}
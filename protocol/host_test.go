@@ -1,6 +1,7 @@
 package protocol
 
 import (
+  "errors"
   "testing"
 
   "github.com/stretchr/testify/assert"
@@ -58,7 +59,7 @@ func TestVerifyPacketAttributes(t *testing.T) {
   dictPath      := "../dict_examples/integration_dict"
   dictionary, _ := DictionaryFromFile(dictPath)
 
-  packetBytes := []uint8 { 4, 43, 0, 83, 215, 189, 213, 172, 57, 94, 141, 70, 134, 121, 101, 57, 187, 220, 227, 73, 4, 6, 192, 168, 1, 10, 5, 6, 0, 0, 0, 0, 32, 10, 116, 114, 105, 108, 108, 105, 97, 110, 30, 19, 48, 48, 45, 48, 52, 45, 53, 70, 45, 48, 48, 45, 48, 70, 45, 68, 49, 31, 19, 48, 48, 45, 48, 49, 45, 50, 52, 45, 56, 48, 45, 66, 51, 45, 57, 67, 8, 6, 10, 0, 0, 100 }
+  packetBytes := []uint8 { 4, 43, 0, 86, 215, 189, 213, 172, 57, 94, 141, 70, 134, 121, 101, 57, 187, 220, 227, 73, 4, 6, 192, 168, 1, 10, 5, 6, 0, 0, 0, 0, 32, 10, 116, 114, 105, 108, 108, 105, 97, 110, 30, 19, 48, 48, 45, 48, 52, 45, 53, 70, 45, 48, 48, 45, 48, 70, 45, 68, 49, 31, 19, 48, 48, 45, 48, 49, 45, 50, 52, 45, 56, 48, 45, 66, 51, 45, 57, 67, 8, 6, 10, 0, 0, 100 }
   
   host        := InitialiseHost(1812, 1813, 3799, dictionary)
 
@@ -70,7 +71,7 @@ func TestVerifyPacketAttributesFail(t *testing.T) {
   dictPath      := "../dict_examples/integration_dict"
   dictionary, _ := DictionaryFromFile(dictPath)
 
-  packetBytes := []uint8 { 4, 43, 0, 82, 215, 189, 213, 172, 57, 94, 141, 70, 134, 121, 101, 57, 187, 220, 227, 73, 4, 5, 192, 168, 10, 5, 6, 0, 0, 0, 0, 32, 10, 116, 114, 105, 108, 108, 105, 97, 110, 30, 19, 48, 48, 45, 48, 52, 45, 53, 70, 45, 48, 48, 45, 48, 70, 45, 68, 49, 31, 19, 48, 48, 45, 48, 49, 45, 50, 52, 45, 56, 48, 45, 66, 51, 45, 57, 67, 8, 6, 10, 0, 0, 100 }
+  packetBytes := []uint8 { 4, 43, 0, 85, 215, 189, 213, 172, 57, 94, 141, 70, 134, 121, 101, 57, 187, 220, 227, 73, 4, 5, 192, 168, 10, 5, 6, 0, 0, 0, 0, 32, 10, 116, 114, 105, 108, 108, 105, 97, 110, 30, 19, 48, 48, 45, 48, 52, 45, 53, 70, 45, 48, 48, 45, 48, 70, 45, 68, 49, 31, 19, 48, 48, 45, 48, 49, 45, 50, 52, 45, 56, 48, 45, 66, 51, 45, 57, 67, 8, 6, 10, 0, 0, 100 }
   host        := InitialiseHost(1812, 1813, 3799, dictionary)
 
   err := host.VerifyPacketAttributes(&packetBytes)
@@ -94,11 +95,12 @@ func TestVerifyMessageAuthenticatorWoAuthenticator(t *testing.T) {
   dictionary, _ := DictionaryFromFile(dictPath)
   secret        := "secret"
 
-  packetBytes := []uint8 { 4, 43, 0, 83, 215, 189, 213, 172, 57, 94, 141, 70, 134, 121, 101, 57, 187, 220, 227, 73, 4, 6, 192, 168, 1, 10, 5, 6, 0, 0, 0, 0, 32, 10, 116, 114, 105, 108, 108, 105, 97, 110, 30, 19, 48, 48, 45, 48, 52, 45, 53, 70, 45, 48, 48, 45, 48, 70, 45, 68, 49, 31, 19, 48, 48, 45, 48, 49, 45, 50, 52, 45, 56, 48, 45, 66, 51, 45, 57, 67, 8, 6, 10, 0, 0, 100 }
+  packetBytes := []uint8 { 4, 43, 0, 86, 215, 189, 213, 172, 57, 94, 141, 70, 134, 121, 101, 57, 187, 220, 227, 73, 4, 6, 192, 168, 1, 10, 5, 6, 0, 0, 0, 0, 32, 10, 116, 114, 105, 108, 108, 105, 97, 110, 30, 19, 48, 48, 45, 48, 52, 45, 53, 70, 45, 48, 48, 45, 48, 70, 45, 68, 49, 31, 19, 48, 48, 45, 48, 49, 45, 50, 52, 45, 56, 48, 45, 66, 51, 45, 57, 67, 8, 6, 10, 0, 0, 100 }
   host        := InitialiseHost(1812, 1813, 3799, dictionary)
 
   err := host.VerifyMessageAuthenticator(secret, &packetBytes)
   assert.Equal(t, "Message-Authenticator attribute not found in packet", err.Error(), "Invalid packed is verified!")
+  assert.Equal(t, true, errors.Is(err, ErrNoMessageAuthenticator), "Missing Message-Authenticator is not reported!")
 }
 
 func TestVerifyMessageAuthenticatorError(t *testing.T) {
@@ -112,3 +114,31 @@ func TestVerifyMessageAuthenticatorError(t *testing.T) {
   err := host.VerifyMessageAuthenticator(secret, &packetBytes)
   assert.Equal(t, "Packet Message-Authenticator mismatch", err.Error(), "Invalid packed is verified!")
 }
+
+func TestVerifyMessageAuthenticatorUnknownAttribute(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := DictionaryFromFile(dictPath)
+  secret        := "secret"
+
+  userName         := []uint8("testing")
+  messageAuthBytes := make([]uint8, 16)
+
+  userNameAttr, _ := CreateRadAttributeByName(&dictionary, "User-Name",             &userName)
+  msgAuthAttr, _  := CreateRadAttributeByName(&dictionary, "Message-Authenticator", &messageAuthBytes)
+  unknownAttr     := RadiusAttribute { 250, "", []uint8 { 1, 2, 3, 4 } }
+  attributes      := []RadiusAttribute { userNameAttr, unknownAttr, msgAuthAttr }
+
+  radPacket := InitialiseRadiusPacket(AccessRequest)
+  radPacket.SetAttributes(attributes)
+  radPacket.GenerateMessageAuthenticator(secret)
+
+  packetBytes, _ := radPacket.ToBytes()
+  host           := InitialiseHost(1812, 1813, 3799, dictionary)
+
+  err := host.VerifyMessageAuthenticator(secret, &packetBytes)
+  assert.Equal(t, nil, err, "Valid packet is not verified!")
+
+  packetBytes[len(packetBytes) - 1] ^= 1
+  err = host.VerifyMessageAuthenticator(secret, &packetBytes)
+  assert.Equal(t, "Packet Message-Authenticator mismatch", err.Error(), "Invalid packet is verified!")
+}
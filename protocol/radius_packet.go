@@ -8,13 +8,25 @@ import (
 
   "crypto/hmac"
   "crypto/md5"
+  "crypto/rand"
   "encoding/binary"
-  "math/rand"
   "unicode/utf8"
 
   "github.com/MikhailMS/go-radius/tools"
 )
 
+// MESSAGE_AUTHENTICATOR_ID is the attribute type of Message-Authenticator as defined in RFC 3579
+const MESSAGE_AUTHENTICATOR_ID = 80
+
+// EAP_MESSAGE_ID is the attribute type of EAP-Message as defined in RFC 3579
+const EAP_MESSAGE_ID = 79
+
+// VENDOR_SPECIFIC_ID is the attribute type of Vendor-Specific as defined in RFC 2865
+const VENDOR_SPECIFIC_ID = 26
+
+// ErrNoMessageAuthenticator is returned, when packet has no Message-Authenticator attribute
+var ErrNoMessageAuthenticator = errors.New("Message-Authenticator attribute not found in packet")
+
 // RadiusMsgType represents allowed types of RADIUS messages/packets
 //
 // Mainly used in RADIUS Server implementation to distinguish between sockets and functions, that should
@@ -300,32 +312,81 @@ type RadiusPacket struct {
   code          TypeCode
   authenticator []uint8
   attributes    []RadiusAttribute
+  // raw holds packet bytes exactly as they were received, empty for locally built packets
+  raw           []uint8
+}
+
+// PacketIDSource provides ID and authenticator for newly created RadiusPacket
+//
+// Default source is backed by crypto/rand; custom source could be injected, for example, to produce
+// deterministic packets in tests
+type PacketIDSource interface {
+  // PacketID returns ID for a new RadiusPacket
+  PacketID() uint8
+  // PacketAuthenticator returns 16 bytes long authenticator for a new RadiusPacket
+  PacketAuthenticator() []uint8
+}
+
+// CryptoRandSource is PacketIDSource, that uses cryptographically secure random number generator
+type CryptoRandSource struct {}
+
+// PacketID returns random uint8 ID
+func (CryptoRandSource) PacketID() uint8 {
+  return createPacketId()
+}
+
+// PacketAuthenticator returns random authenticator
+func (CryptoRandSource) PacketAuthenticator() []uint8 {
+  return createPacketAuthenticator()
 }
 
 // InitialisePacket initialises RADIUS packet with random ID and authenticator
 func InitialiseRadiusPacket(code TypeCode) RadiusPacket {
-  return RadiusPacket {createPacketId(), code, createPacketAuthenticator(), []RadiusAttribute{}}
+  return RadiusPacket {createPacketId(), code, createPacketAuthenticator(), []RadiusAttribute{}, nil}
+}
+
+// InitialiseRadiusPacketWithSource initialises RADIUS packet with ID and authenticator taken from
+// given source
+func InitialiseRadiusPacketWithSource(code TypeCode, source PacketIDSource) RadiusPacket {
+  return RadiusPacket {source.PacketID(), code, source.PacketAuthenticator(), []RadiusAttribute{}, nil}
 }
 
 // InitialisePacketFromBytes initialises RADIUS packet from raw bytes
+//
+// A copy of given bytes, up to Length field of the header, is kept in RadiusPacket and is available
+// via *RawBytes()*; octets past Length are padding and are ignored (RFC 2865, section 3)
 func InitialiseRadiusPacketFromBytes(dictionary *Dictionary, bytes *[]uint8) (RadiusPacket, error) {
   var attributes []RadiusAttribute
- 
-  code, ok := typeCodeFromUint8((*bytes)[0])
+
+  packet, err := trimToLength(*bytes)
+  if err != nil {
+    return RadiusPacket{}, err
+  }
+
+  packetBytes := make([]uint8, len(packet))
+  copy(packetBytes, packet)
+
+  code, ok := typeCodeFromUint8(packetBytes[0])
   if !ok {
     return RadiusPacket{}, errors.New("Invalid TypeCode")
   }
-  id   := (*bytes)[1]
-  authenticator := (*bytes)[4:20]
+  id   := packetBytes[1]
+  authenticator := packetBytes[4:20]
 
   lastIndex := 20
 
   for {
-    if lastIndex == len(*bytes) { break }
-    
-    attrID     := (*bytes)[lastIndex]
-    attrLength := int((*bytes)[lastIndex + 1])
-    attrValue  := (*bytes)[(lastIndex + 2):(lastIndex + attrLength)]
+    if lastIndex == len(packetBytes) { break }
+
+    if lastIndex + 2 > len(packetBytes) {
+      return RadiusPacket{}, errors.New("malformed attribute header")
+    }
+    attrID     := packetBytes[lastIndex]
+    attrLength := int(packetBytes[lastIndex + 1])
+    if attrLength < 2 || lastIndex + attrLength > len(packetBytes) {
+      return RadiusPacket{}, errors.New(fmt.Sprintf("attribute with ID: %d has invalid length", attrID))
+    }
+    attrValue  := packetBytes[(lastIndex + 2):(lastIndex + attrLength)]
 
     _tmpAttr, ok := CreateRadAttributeByID(dictionary, attrID, &attrValue)
     if !ok {
@@ -335,7 +396,7 @@ func InitialiseRadiusPacketFromBytes(dictionary *Dictionary, bytes *[]uint8) (Ra
     lastIndex += attrLength
   }
 
-  return RadiusPacket {id, code, authenticator, attributes}, nil
+  return RadiusPacket {id, code, authenticator, attributes, packetBytes}, nil
 }
 
 // SetAttributes sets attrbiutes for RadiusPacket
@@ -365,7 +426,7 @@ func (radPacket *RadiusPacket) OverrideMessageAuthenticator(newMessageAuth []uin
     }
   }
 
-  return errors.New("Message-Authenticator attribute not found in packet")
+  return ErrNoMessageAuthenticator
 }
 
 // Generates HMAC-MD5 hash for Message-Authenticator attribute
@@ -399,6 +460,39 @@ func (radPacket *RadiusPacket) GenerateMessageAuthenticator(secret string) error
   return nil
 }
 
+// GenerateRequestAuthenticator calculates Request Authenticator of Accounting-Request, CoA-Request
+// or Disconnect-Request (RFC 2866 & RFC 5176), which depends on packet ID & attributes
+//
+// If RadiusPacket has Message-Authenticator, it is generated first over Request Authenticator set
+// to zeros, as defined in RFC 5176
+func (radPacket *RadiusPacket) GenerateRequestAuthenticator(secret string) error {
+  switch radPacket.code {
+    case AccountingRequest, CoARequest, DisconnectRequest:
+    default:
+      return errors.New("Request Authenticator could only be generated for Accounting-Request, CoA-Request or Disconnect-Request")
+  }
+
+  radPacket.authenticator = make([]uint8, 16)
+
+  if _, err := radPacket.MessageAuthenticator(); err == nil {
+    if err := radPacket.GenerateMessageAuthenticator(secret); err != nil {
+      return err
+    }
+  }
+
+  packetBytes, ok := radPacket.ToBytes()
+  if !ok {
+    return errors.New("failed to convert RadiusPacket to bytes")
+  }
+
+  md5Hash := md5.New()
+  md5Hash.Write(packetBytes)
+  md5Hash.Write([]uint8(secret))
+
+  radPacket.authenticator = md5Hash.Sum(nil)
+  return nil
+}
+
 // MessageAuthenticator returns Message-Authenticator value, if exists in RadiusPacket
 // otherwise returns an error
 func (radPacket *RadiusPacket) MessageAuthenticator() ([]uint8, error) {
@@ -408,7 +502,37 @@ func (radPacket *RadiusPacket) MessageAuthenticator() ([]uint8, error) {
     }
   }
 
-  return nil, errors.New("Message-Authenticator attribute not found in packet")
+  return nil, ErrNoMessageAuthenticator
+}
+
+// VerifyMessageAuthenticator verifies Message-Authenticator value against the bytes RadiusPacket was
+// initialised from
+//
+// requestAuthenticator should be nil when verifying a request; when verifying a reply it should be
+// the authenticator of the request, as Message-Authenticator of a reply is calculated over it
+func (radPacket *RadiusPacket) VerifyMessageAuthenticator(secret string, requestAuthenticator []uint8) error {
+  if len(radPacket.raw) == 0 {
+    return errors.New("RadiusPacket was not initialised from bytes")
+  }
+
+  return verifyMessageAuthenticator(secret, radPacket.raw, requestAuthenticator)
+}
+
+// VerifyResponseAuthenticator verifies authenticator of a reply against the bytes RadiusPacket was
+// initialised from
+func (radPacket *RadiusPacket) VerifyResponseAuthenticator(secret string, requestAuthenticator []uint8) error {
+  if len(radPacket.raw) == 0 {
+    return errors.New("RadiusPacket was not initialised from bytes")
+  }
+
+  return verifyResponseAuthenticator(secret, radPacket.raw, requestAuthenticator)
+}
+
+// RawBytes returns bytes RadiusPacket was initialised from
+//
+// Returns empty slice if RadiusPacket was not built from bytes
+func (radPacket *RadiusPacket) RawBytes() []uint8 {
+  return radPacket.raw
 }
 
 // ID returns RadiusPacket id
@@ -453,6 +577,20 @@ func (radPacket *RadiusPacket) AttributeByID(attrID uint8) RadiusAttribute {
   return RadiusAttribute{}
 }
 
+// VendorSpecificValue returns value of Vendor-Specific sub-attribute with given vendor ID & type
+func (radPacket *RadiusPacket) VendorSpecificValue(vendorID uint32, vendorType uint8) ([]uint8, bool) {
+  for _, attr := range radPacket.attributes {
+    if attr.ID() == VENDOR_SPECIFIC_ID {
+      value, ok := tools.BytesToVendorSpecific(attr.value, vendorID, vendorType)
+      if ok {
+        return value, true
+      }
+    }
+  }
+
+  return []uint8{}, false
+}
+
 // ToBytes converts RadiusPacket into ready-to-be-sent bytes slice
 func (radPacket *RadiusPacket) ToBytes() ([]uint8, bool) {
   /* Prepare packet for a transmission to server/client
@@ -500,19 +638,165 @@ func (radPacket *RadiusPacket) ToBytes() ([]uint8, bool) {
 
 // createPacketId creates random uint8 ID for RadiusPacket
 func createPacketId() uint8 {
-  return uint8(rand.Intn(256))
+  return createRandomBytes(1)[0]
 }
 
 // createPacketAuthenticator creates an uint8 slice of length 16
 // filled with random numbers
 func createPacketAuthenticator() []uint8 {
-  var authenticator []uint8
+  return createRandomBytes(16)
+}
 
-  for i := 0; i < 16; i++ {
-    authenticator = append(authenticator, uint8(rand.Intn(256)))
+// createRandomBytes reads given number of bytes from crypto/rand
+//
+// Panics if system's random number generator fails, as it is not safe to build a packet without
+// unpredictable authenticator
+func createRandomBytes(length int) []uint8 {
+  output := make([]uint8, length)
+
+  if _, err := rand.Read(output); err != nil {
+    panic(fmt.Sprintf("failed to read random bytes: %s", err))
   }
+  return output
+}
 
-  return authenticator
+// VerifyPacketStructure verifies RADIUS packet on the wire level: its Code, Length field and bounds
+// of attributes
+//
+// Dictionary is not needed, so attributes, that are unknown to it, don't affect the result
+func VerifyPacketStructure(packet []uint8) error {
+  packet, err := trimToLength(packet)
+  if err != nil {
+    return err
+  }
+
+  if _, ok := typeCodeFromUint8(packet[0]); !ok {
+    return errors.New("Invalid TypeCode")
+  }
+
+  lastIndex := 20
+
+  for lastIndex < len(packet) {
+    if lastIndex + 2 > len(packet) {
+      return errors.New("malformed attribute header")
+    }
+
+    attrLength := int(packet[lastIndex + 1])
+    if attrLength < 2 || lastIndex + attrLength > len(packet) {
+      return errors.New(fmt.Sprintf("attribute with ID: %d has invalid length", packet[lastIndex]))
+    }
+    lastIndex += attrLength
+  }
+  return nil
+}
+
+// trimToLength returns packet bytes up to Length field of the header, dropping padding
+//
+// Packet, which Length is shorter than RADIUS header or longer than received bytes, is rejected
+func trimToLength(packet []uint8) ([]uint8, error) {
+  if len(packet) < 20 {
+    return nil, errors.New("packet is shorter than RADIUS header")
+  }
+
+  length := int(binary.BigEndian.Uint16(packet[2:4]))
+  if length < 20 || length > len(packet) {
+    return nil, errors.New(fmt.Sprintf("packet has invalid Length %d", length))
+  }
+  return packet[:length], nil
+}
+
+// messageAuthenticatorIndex returns position of Message-Authenticator value inside packet bytes
+// together with its length
+//
+// Attributes are walked on the wire level, so the dictionary is not needed and attributes unknown to
+// it don't affect the result
+func messageAuthenticatorIndex(packet []uint8) (int, int, error) {
+  lastIndex := 20
+
+  for lastIndex < len(packet) {
+    if lastIndex + 2 > len(packet) {
+      return 0, 0, errors.New("malformed attribute header")
+    }
+
+    attrLength := int(packet[lastIndex + 1])
+    if attrLength < 2 || lastIndex + attrLength > len(packet) {
+      return 0, 0, errors.New(fmt.Sprintf("attribute with ID: %d has invalid length", packet[lastIndex]))
+    }
+
+    if packet[lastIndex] == MESSAGE_AUTHENTICATOR_ID {
+      return lastIndex + 2, attrLength - 2, nil
+    }
+    lastIndex += attrLength
+  }
+
+  return 0, 0, ErrNoMessageAuthenticator
+}
+
+// verifyMessageAuthenticator calculates HMAC-MD5 over packet bytes with Message-Authenticator
+// zeroed and compares it with Message-Authenticator found in packet
+func verifyMessageAuthenticator(secret string, packet []uint8, requestAuthenticator []uint8) error {
+  packet, err := trimToLength(packet)
+  if err != nil {
+    return err
+  }
+
+  index, length, err := messageAuthenticatorIndex(packet)
+  if err != nil {
+    return err
+  }
+
+  originalMsgAuth := make([]uint8, length)
+  copy(originalMsgAuth, packet[index:index + length])
+
+  // Work on a copy, so caller's bytes are never modified
+  packetCopy := make([]uint8, len(packet))
+  copy(packetCopy, packet)
+
+  for i := index; i < index + length; i++ {
+    packetCopy[i] = 0
+  }
+  if requestAuthenticator != nil {
+    copy(packetCopy[4:20], requestAuthenticator)
+  }
+
+  calculatedHash := hmac.New(md5.New, []uint8(secret))
+  calculatedHash.Write(packetCopy)
+
+  if hmac.Equal(originalMsgAuth, calculatedHash.Sum(nil)) {
+    return nil
+  }
+  return errors.New("Packet Message-Authenticator mismatch")
+}
+
+// verifyRequestAuthenticator calculates authenticator of Accounting-Request, CoA-Request or
+// Disconnect-Request as per RFC 2866 & RFC 5176 and compares it with the one found in packet
+func verifyRequestAuthenticator(secret string, packet []uint8) error {
+  if len(packet) < 20 {
+    return errors.New("packet is shorter than RADIUS header")
+  }
+
+  return verifyResponseAuthenticator(secret, packet, make([]uint8, 16))
+}
+
+// verifyResponseAuthenticator calculates authenticator of a reply as per RFC 2865 and compares
+// it with the one found in packet
+func verifyResponseAuthenticator(secret string, packet []uint8, requestAuthenticator []uint8) error {
+  packet, err := trimToLength(packet)
+  if err != nil {
+    return err
+  }
+
+  md5Hash := md5.New()
+
+  md5Hash.Write(packet[0:4])            // Append reply type code, reply ID and reply length
+  md5Hash.Write(requestAuthenticator)   // Append request authenticator
+  md5Hash.Write(packet[20:])            // Append reply attributes
+  md5Hash.Write([]uint8(secret))        // Append secret
+
+  if hmac.Equal(packet[4:20], md5Hash.Sum(nil)) {
+    return nil
+  }
+  return errors.New("Packet authenticator mismatch")
 }
 
 // packetLengthToBytes converts uint16 into []uint8 (of length 2)
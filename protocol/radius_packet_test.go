@@ -1,6 +1,7 @@
 package protocol
 
 import (
+  "crypto/md5"
   "testing"
 
   "github.com/stretchr/testify/assert"
@@ -52,7 +53,7 @@ func TestCreateRadAttributeByIDNonExisting(t *testing.T) {
 }
 
 func TestInitialiseRadPacketFromBytes(t *testing.T) {
-  radPacketBytes := []uint8 { 4, 43, 0, 83, 215, 189, 213, 172, 57, 94, 141, 70, 134, 121, 101, 57, 187, 220, 227, 73, 4, 6, 192, 168, 1, 10, 5, 6, 0, 0, 0, 0, 32, 10, 116, 114, 105, 108, 108, 105, 97, 110, 30, 19, 48, 48, 45, 48, 52, 45, 53, 70, 45, 48, 48, 45, 48, 70, 45, 68, 49, 31, 19, 48, 48, 45, 48, 49, 45, 50, 52, 45, 56, 48, 45, 66, 51, 45, 57, 67, 8, 6, 10, 0, 0, 100 }
+  radPacketBytes := []uint8 { 4, 43, 0, 86, 215, 189, 213, 172, 57, 94, 141, 70, 134, 121, 101, 57, 187, 220, 227, 73, 4, 6, 192, 168, 1, 10, 5, 6, 0, 0, 0, 0, 32, 10, 116, 114, 105, 108, 108, 105, 97, 110, 30, 19, 48, 48, 45, 48, 52, 45, 53, 70, 45, 48, 48, 45, 48, 70, 45, 68, 49, 31, 19, 48, 48, 45, 48, 49, 45, 50, 52, 45, 56, 48, 45, 66, 51, 45, 57, 67, 8, 6, 10, 0, 0, 100 }
 
   dictPath      := "../dict_examples/integration_dict"
   dictionary, _ := DictionaryFromFile(dictPath)
@@ -79,6 +80,7 @@ func TestInitialiseRadPacketFromBytes(t *testing.T) {
   expectedPacket.SetAttributes(attributes)
   expectedPacket.OverrideID(43)
   expectedPacket.OverrideAuthenticator(authenticator)
+  expectedPacket.raw = radPacketBytes
 
   packetFromBytes, _ := InitialiseRadiusPacketFromBytes(&dictionary, &radPacketBytes)
   assert.Equal(t, expectedPacket, packetFromBytes, "Radius Packets are not same!")
@@ -102,6 +104,30 @@ func TestOverrideAuthenticator(t *testing.T) {
   assert.Equal(t, expectedAuthenticator, radPacket.Authenticator(), "Radius Packet Authenticator was not changed!")
 }
 
+func TestGenerateRequestAuthenticator(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := DictionaryFromFile(dictPath)
+
+  userName        := []uint8("testing")
+  msgAuth         := make([]uint8, 16)
+  userNameAttr, _ := CreateRadAttributeByName(&dictionary, "User-Name", &userName)
+  msgAuthAttr, _  := CreateRadAttributeByID(&dictionary, MESSAGE_AUTHENTICATOR_ID, &msgAuth)
+
+  radPacket := InitialiseRadiusPacket(AccountingRequest)
+  radPacket.SetAttributes([]RadiusAttribute { userNameAttr, msgAuthAttr })
+
+  err := radPacket.GenerateRequestAuthenticator("secret")
+  assert.Equal(t, nil, err, "Request Authenticator is not generated!")
+
+  packetBytes, _ := radPacket.ToBytes()
+  assert.Equal(t, nil, verifyRequestAuthenticator("secret", packetBytes),                      "Request Authenticator is not valid!")
+  assert.Equal(t, nil, verifyMessageAuthenticator("secret", packetBytes, make([]uint8, 16)), "Message-Authenticator is not valid!")
+
+  accessRequest := InitialiseRadiusPacket(AccessRequest)
+  err            = accessRequest.GenerateRequestAuthenticator("secret")
+  assert.NotEqual(t, nil, err, "Request Authenticator is generated for Access-Request!")
+}
+
 func TestRadiusPacketToBytes(t *testing.T) {
   expectedPacketBytes := []uint8 { 1, 50, 0, 29, 0, 25, 100, 56, 13, 0, 67, 34, 39, 12, 88, 153, 0, 1, 2, 3, 1, 9, 116, 101, 115, 116, 105, 110, 103 }
   
@@ -166,3 +192,103 @@ func TestGenerateMessageAuthenticator(t *testing.T) {
   msgAuthenticator, _ := radPacket.MessageAuthenticator()
   assert.Equal(t, expectedMessageAuthenticatorBytes, msgAuthenticator, "Radius Packet Message Authenticator was not set to correct bytes!")
 }
+
+func TestVerifyReplyFromRawBytes(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := DictionaryFromFile(dictPath)
+  secret        := "secret"
+
+  requestAuthenticator := []uint8 { 152, 137, 115, 14, 56, 250, 103, 56, 57, 57, 104, 246, 226, 80, 71, 167 }
+  messageAuthBytes     := make([]uint8, 16)
+  msgAuthAttr, _       := CreateRadAttributeByName(&dictionary, "Message-Authenticator", &messageAuthBytes)
+
+  // Reply Message-Authenticator is calculated over request authenticator
+  reply := InitialiseRadiusPacket(AccessAccept)
+  reply.SetAttributes([]RadiusAttribute { msgAuthAttr })
+  reply.OverrideID(220)
+  reply.OverrideAuthenticator(requestAuthenticator)
+  reply.GenerateMessageAuthenticator(secret)
+
+  replyBytes, _ := reply.ToBytes()
+  hash          := md5.New()
+  hash.Write(replyBytes)
+  hash.Write([]uint8(secret))
+  copy(replyBytes[4:20], hash.Sum(nil))
+
+  replyPacket, err := InitialiseRadiusPacketFromBytes(&dictionary, &replyBytes)
+  assert.Equal(t, nil, err, "Reply is not parsed!")
+  assert.Equal(t, replyBytes, replyPacket.RawBytes(), "Raw bytes are not retained!")
+
+  assert.Equal(t, nil, replyPacket.VerifyMessageAuthenticator(secret, requestAuthenticator), "Valid Message-Authenticator is not verified!")
+  assert.Equal(t, nil, replyPacket.VerifyResponseAuthenticator(secret, requestAuthenticator), "Valid authenticator is not verified!")
+
+  err = replyPacket.VerifyResponseAuthenticator("wrong", requestAuthenticator)
+  assert.Equal(t, "Packet authenticator mismatch", err.Error(), "Invalid authenticator is verified!")
+}
+
+func TestPaddedDatagram(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := DictionaryFromFile(dictPath)
+  secret        := "secret"
+
+  requestAuthenticator := []uint8 { 152, 137, 115, 14, 56, 250, 103, 56, 57, 57, 104, 246, 226, 80, 71, 167 }
+  messageAuthBytes     := make([]uint8, 16)
+  msgAuthAttr, _       := CreateRadAttributeByName(&dictionary, "Message-Authenticator", &messageAuthBytes)
+
+  reply := InitialiseRadiusPacket(AccessAccept)
+  reply.SetAttributes([]RadiusAttribute { msgAuthAttr })
+  reply.OverrideAuthenticator(requestAuthenticator)
+  reply.GenerateMessageAuthenticator(secret)
+
+  replyBytes, _ := reply.ToBytes()
+  hash          := md5.New()
+  hash.Write(replyBytes)
+  hash.Write([]uint8(secret))
+  copy(replyBytes[4:20], hash.Sum(nil))
+
+  // Octets past Length field are padding and are ignored
+  paddedBytes := append(append([]uint8{}, replyBytes...), 0, 0, 0, 0)
+
+  replyPacket, err := InitialiseRadiusPacketFromBytes(&dictionary, &paddedBytes)
+  assert.Equal(t, nil,        err,                           "Padded reply is not parsed!")
+  assert.Equal(t, replyBytes, replyPacket.RawBytes(),        "Padding is kept in raw bytes!")
+  assert.Equal(t, 1,          len(replyPacket.Attributes()), "Padding is parsed as attributes!")
+
+  assert.Equal(t, nil, verifyResponseAuthenticator(secret, paddedBytes, requestAuthenticator), "Authenticator of padded reply is not verified!")
+  assert.Equal(t, nil, verifyMessageAuthenticator(secret, paddedBytes, requestAuthenticator),  "Message-Authenticator of padded reply is not verified!")
+
+  // Length shorter than header or longer than datagram is rejected
+  for _, length := range []uint8 { 19, uint8(len(paddedBytes) + 1) } {
+    invalidBytes   := append([]uint8{}, paddedBytes...)
+    invalidBytes[3] = length
+
+    _, err := InitialiseRadiusPacketFromBytes(&dictionary, &invalidBytes)
+    assert.NotEqual(t, nil, err, "Packet with invalid Length is parsed!")
+    assert.NotEqual(t, nil, verifyResponseAuthenticator(secret, invalidBytes, requestAuthenticator), "Packet with invalid Length is verified!")
+  }
+}
+
+func TestVerifyPacketStructure(t *testing.T) {
+  // Attribute 26 is unknown to any dictionary here, but is well-formed
+  packet := append([]uint8{ 1, 5, 0, 26 }, make([]uint8, 16)...)
+  packet  = append(packet, 26, 6, 0, 0, 0, 1)
+  assert.Equal(t, nil, VerifyPacketStructure(packet), "Well-formed packet is not verified!")
+
+  invalidCode   := append([]uint8{}, packet...)
+  invalidCode[0] = 0
+  assert.Equal(t, "Invalid TypeCode", VerifyPacketStructure(invalidCode).Error(), "Packet with invalid Code is verified!")
+
+  invalidAttr    := append([]uint8{}, packet...)
+  invalidAttr[21] = 7
+  assert.Equal(t, "attribute with ID: 26 has invalid length", VerifyPacketStructure(invalidAttr).Error(), "Packet with invalid attribute length is verified!")
+
+  assert.NotEqual(t, nil, VerifyPacketStructure(packet[:24]), "Packet shorter than its Length is verified!")
+}
+
+func TestInitialiseRadiusPacketRandomAuthenticator(t *testing.T) {
+  radPacket      := InitialiseRadiusPacket(AccessRequest)
+  otherRadPacket := InitialiseRadiusPacket(AccessRequest)
+
+  assert.Equal(t, 16, len(radPacket.Authenticator()), "Radius Packet Authenticator has invalid length!")
+  assert.NotEqual(t, radPacket.Authenticator(), otherRadPacket.Authenticator(), "Radius Packet Authenticators are same!")
+}
@@ -0,0 +1,77 @@
+package protocol
+
+import (
+  "encoding/binary"
+  "errors"
+  "fmt"
+  "io"
+)
+
+// RADSEC_SECRET is the shared secret of RADIUS over TLS (RFC 6614, section 2.3), where packets
+// are protected by TLS instead
+const RADSEC_SECRET = "radsec"
+
+// RADIUS_DTLS_SECRET is the shared secret of RADIUS over DTLS (RFC 7360, section 2.1)
+const RADIUS_DTLS_SECRET = "radius/dtls"
+
+// Limits of RADIUS packet length (RFC 2865, section 3)
+const (
+  MIN_PACKET_LENGTH = 20
+  MAX_PACKET_LENGTH = 4096
+)
+
+// ReadDatagramPacket reads single RADIUS packet from datagram transport (RADIUS over DTLS), where
+// every datagram carries one packet; datagrams, that are too short to be RADIUS packet, are skipped
+func ReadDatagramPacket(reader io.Reader) ([]uint8, error) {
+  buffer := make([]uint8, MAX_PACKET_LENGTH)
+
+  for {
+    n, err := reader.Read(buffer)
+    if err != nil {
+      return nil, err
+    }
+
+    if n >= MIN_PACKET_LENGTH {
+      return buffer[:n], nil
+    }
+  }
+}
+
+// ReadStreamPacket reads single RADIUS packet from stream transport (RADIUS over TLS or TCP), where
+// packets are framed by Length field of their header (RFC 6613, section 2.2)
+//
+// Packet with Length outside of allowed limits is malformed; as framing is lost afterwards, stream
+// should be closed. The same applies to error, that interrupts packet after some of its bytes are
+// read: it is never reported as timeout, so read deadline, that is used as idle timeout, doesn't
+// let the next read start in the middle of packet
+func ReadStreamPacket(reader io.Reader) ([]uint8, error) {
+  header := make([]uint8, 4)
+  if n, err := io.ReadFull(reader, header); err != nil {
+    if n > 0 {
+      return nil, truncatedPacketError(err)
+    }
+    return nil, err
+  }
+
+  length := int(binary.BigEndian.Uint16(header[2:4]))
+  if length < MIN_PACKET_LENGTH || length > MAX_PACKET_LENGTH {
+    return nil, errors.New(fmt.Sprintf("malformed packet length %d", length))
+  }
+
+  packet := make([]uint8, length)
+  copy(packet, header)
+
+  if _, err := io.ReadFull(reader, packet[4:]); err != nil {
+    return nil, truncatedPacketError(err)
+  }
+  return packet, nil
+}
+
+// truncatedPacketError converts error, that interrupted packet after some of its bytes were read,
+// into error, that is not timeout
+func truncatedPacketError(err error) error {
+  if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+    return io.ErrUnexpectedEOF
+  }
+  return errors.New(fmt.Sprintf("packet is truncated: %s", err))
+}
@@ -0,0 +1,87 @@
+package protocol
+
+import (
+  "bytes"
+  "errors"
+  "io"
+  "net"
+  "testing"
+  "time"
+
+  "github.com/stretchr/testify/assert"
+)
+
+func TestReadStreamPacket(t *testing.T) {
+  first  := append([]uint8{ 1, 5, 0, 22 }, append(make([]uint8, 16), 1, 2)...)
+  second := append([]uint8{ 4, 6, 0, 20 }, make([]uint8, 16)...)
+  stream := bytes.NewReader(append(append([]uint8{}, first...), second...))
+
+  packet, err := ReadStreamPacket(stream)
+  assert.Equal(t, nil,   err,    "First packet is not read!")
+  assert.Equal(t, first, packet, "First packet is not framed correctly!")
+
+  packet, err = ReadStreamPacket(stream)
+  assert.Equal(t, nil,    err,    "Second packet is not read!")
+  assert.Equal(t, second, packet, "Second packet is not framed correctly!")
+
+  _, err = ReadStreamPacket(stream)
+  assert.Equal(t, io.EOF, err, "End of stream is not reported!")
+}
+
+func TestReadStreamPacketMalformed(t *testing.T) {
+  _, err := ReadStreamPacket(bytes.NewReader([]uint8{ 1, 5, 0, 19 }))
+  assert.Equal(t, "malformed packet length 19", err.Error(), "Too short packet is accepted!")
+
+  _, err = ReadStreamPacket(bytes.NewReader([]uint8{ 1, 5, 0x10, 1 }))
+  assert.Equal(t, "malformed packet length 4097", err.Error(), "Too long packet is accepted!")
+
+  _, err = ReadStreamPacket(bytes.NewReader([]uint8{ 1, 5, 0, 20, 1, 2 }))
+  assert.Equal(t, io.ErrUnexpectedEOF, err, "Truncated packet is accepted!")
+}
+
+func TestReadStreamPacketTimeout(t *testing.T) {
+  local, remote := net.Pipe()
+  defer local.Close()
+  defer remote.Close()
+
+  // Timeout before packet starts could be retried, while timeout in the middle of packet could not
+  local.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+  _, err := ReadStreamPacket(local)
+
+  var netErr net.Error
+  assert.Equal(t, true, errors.As(err, &netErr) && netErr.Timeout(), "Timeout before packet is not reported!")
+
+  go remote.Write([]uint8{ 1, 5, 0, 20, 1, 2 })
+
+  local.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+  _, err = ReadStreamPacket(local)
+  assert.NotEqual(t, nil,   err,                                         "Truncated packet is accepted!")
+  assert.Equal(t,    false, errors.As(err, &netErr) && netErr.Timeout(), "Timeout in the middle of packet is reported as idle timeout!")
+}
+
+func TestReadDatagramPacket(t *testing.T) {
+  packet := append([]uint8{ 2, 5, 0, 20 }, make([]uint8, 16)...)
+  reader := &testDatagramReader { datagrams: [][]uint8 { { 1, 2, 3 }, packet } }
+
+  read, err := ReadDatagramPacket(reader)
+  assert.Equal(t, nil,    err,  "Datagram is not read!")
+  assert.Equal(t, packet, read, "Too short datagram is not skipped!")
+
+  _, err = ReadDatagramPacket(reader)
+  assert.Equal(t, io.EOF, err, "End of datagrams is not reported!")
+}
+
+// testDatagramReader returns one datagram per Read
+type testDatagramReader struct {
+  datagrams [][]uint8
+}
+
+func (reader *testDatagramReader) Read(buffer []uint8) (int, error) {
+  if len(reader.datagrams) == 0 {
+    return 0, io.EOF
+  }
+
+  n                := copy(buffer, reader.datagrams[0])
+  reader.datagrams  = reader.datagrams[1:]
+  return n, nil
+}
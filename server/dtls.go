@@ -0,0 +1,144 @@
+// RADIUS over DTLS (RFC 7360) transport of Runtime
+package server
+
+import (
+  "context"
+  "crypto/tls"
+  "crypto/x509"
+  "errors"
+  "fmt"
+  "log"
+  "net"
+  "strconv"
+  "sync"
+  "time"
+
+  "github.com/pion/dtls/v2"
+  "github.com/pion/transport/v2/udp"
+
+  "github.com/MikhailMS/go-radius/protocol"
+)
+
+// RADIUS_DTLS_PORT is the default port of RADIUS over DTLS (RFC 7360, section 2.2)
+const RADIUS_DTLS_PORT = 2083
+
+// ListenAndServeDTLS starts RADIUS/DTLS listener on Server address & given port, and blocks until
+// listener is closed
+func (runtime *Runtime) ListenAndServeDTLS(port uint16, config *dtls.Config) error {
+  localAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(runtime.server.Server(), strconv.Itoa(int(port))))
+  if err != nil {
+    return err
+  }
+
+  listener, err := udp.Listen("udp", localAddr)
+  if err != nil {
+    return err
+  }
+
+  return runtime.ServeDTLS(listener, config)
+}
+
+// ServeDTLS accepts UDP associations from given listener (see github.com/pion/transport/v2/udp),
+// establishes DTLS sessions over them and serves requests received within sessions, until listener
+// is closed
+//
+// Client certificate is always required and verified against ClientCAs of config; clients are
+// identified by CertificateMapper, as for RadSec. All RADIUS Message Types are served over the same
+// session, protected with RADIUS_DTLS_SECRET. As with UDP, malformed packets are silently dropped
+func (runtime *Runtime) ServeDTLS(listener net.Listener, config *dtls.Config) error {
+  dtlsConfig           := *config
+  dtlsConfig.ClientAuth = dtls.RequireAndVerifyClientCert
+
+  runtime.trackConn(listener)
+
+  for {
+    conn, err := listener.Accept()
+    if err != nil {
+      if errors.Is(err, net.ErrClosed) || errors.Is(err, udp.ErrClosedListener) {
+        return nil
+      }
+      return err
+    }
+
+    go runtime.serveDTLSConn(conn, &dtlsConfig)
+  }
+}
+
+// serveDTLSConn establishes DTLS session over UDP association, identifies client by its certificate
+// and serves its requests
+func (runtime *Runtime) serveDTLSConn(conn net.Conn, config *dtls.Config) {
+  ctx, cancel := context.WithTimeout(context.Background(), RADSEC_HANDSHAKE_TIMEOUT)
+  defer cancel()
+
+  dtlsConn, err := dtls.ServerWithContext(ctx, conn, config)
+  if err != nil {
+    log.Println(fmt.Sprintf("WARNING: DTLS handshake with %s failed: %s", conn.RemoteAddr().String(), err))
+    conn.Close()
+    return
+  }
+
+  runtime.trackConn(dtlsConn)
+  defer runtime.untrackConn(dtlsConn)
+  defer dtlsConn.Close()
+
+  var certificates []*x509.Certificate
+  for _, rawCertificate := range dtlsConn.ConnectionState().PeerCertificates {
+    certificate, err := x509.ParseCertificate(rawCertificate)
+    if err != nil {
+      log.Println(fmt.Sprintf("WARNING: closed DTLS session with %s: %s", conn.RemoteAddr().String(), err))
+      return
+    }
+    certificates = append(certificates, certificate)
+  }
+
+  mapper := runtime.mapper
+  if mapper == nil {
+    mapper = runtime.mapCertificate
+  }
+
+  remoteHost, ok := mapper(tls.ConnectionState { PeerCertificates: certificates })
+  if !ok {
+    log.Println(fmt.Sprintf("WARNING: closed DTLS session with %s: certificate is not mapped to allowed host", conn.RemoteAddr().String()))
+    return
+  }
+
+  runtime.serveDatagrams(dtlsConn, remoteHost, protocol.RADIUS_DTLS_SECRET)
+}
+
+// serveDatagrams reads requests, one per datagram, from DTLS session and writes replies back,
+// until session is closed or becomes idle; session is idle only once idle timeout passes after its
+// last request is answered
+func (runtime *Runtime) serveDatagrams(conn net.Conn, remoteHost, secret string) {
+  var writeMutex sync.Mutex
+  var activity   streamActivity
+
+  for {
+    if runtime.idleTimeout > 0 {
+      conn.SetReadDeadline(time.Now().Add(runtime.idleTimeout))
+    }
+
+    request, err := protocol.ReadDatagramPacket(conn)
+    if err != nil {
+      var netErr net.Error
+      if errors.As(err, &netErr) && netErr.Timeout() && !activity.isIdle(runtime.idleTimeout) {
+        continue
+      }
+      return
+    }
+
+    activity.start()
+    go func() {
+      defer activity.done()
+
+      reply, err := runtime.handle(streamMsgType(request), request, conn.RemoteAddr(), remoteHost, secret)
+      if err != nil {
+        log.Println(fmt.Sprintf("WARNING: dropped request from %s: %s", conn.RemoteAddr().String(), err))
+        return
+      }
+
+      writeMutex.Lock()
+      defer writeMutex.Unlock()
+      conn.Write(reply)
+    }()
+  }
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+  "context"
+  "crypto/tls"
+  "crypto/x509"
+  "net"
+  "testing"
+
+  "github.com/pion/dtls/v2"
+  "github.com/pion/transport/v2/udp"
+  "github.com/stretchr/testify/assert"
+
+  "github.com/MikhailMS/go-radius/client"
+  "github.com/MikhailMS/go-radius/protocol"
+)
+
+// startTestDTLS starts RADIUS/DTLS Runtime, that accepts "testing" user with "password", and
+// returns its port & DTLS config of client with certificate for given name
+func startTestDTLS(t *testing.T, dictionary protocol.Dictionary, clientName string) (uint16, *dtls.Config) {
+  ca         := createTestCertificate(t, "Test CA", true, nil)
+  serverCert := createTestCertificate(t, "radius.example.com", false, &ca)
+  clientCert := createTestCertificate(t, clientName, false, &ca)
+
+  pool := x509.NewCertPool()
+  pool.AddCert(ca.Leaf)
+
+  allowedHosts := map[string]string { "nas.example.com": protocol.RADIUS_DTLS_SECRET }
+  server       := InitialiseServer(dictionary, allowedHosts, "127.0.0.1", 1, 2)
+  runtime      := InitialiseRuntime(&server)
+
+  runtime.SetHandler(protocol.AUTH, func(request *Request) (protocol.TypeCode, []protocol.RadiusAttribute, error) {
+    password, err := server.UserPassword(request.Packet(), request.RemoteHost())
+    if err != nil || string(password) != "password" {
+      return protocol.AccessReject, nil, nil
+    }
+    return protocol.AccessAccept, nil, nil
+  })
+
+  listener, err := udp.Listen("udp", &net.UDPAddr { IP: net.ParseIP("127.0.0.1") })
+  if err != nil {
+    t.Fatal(err)
+  }
+  go runtime.ServeDTLS(listener, &dtls.Config { Certificates: []tls.Certificate { serverCert }, ClientCAs: pool })
+  t.Cleanup(func() { runtime.Close() })
+
+  clientConfig := &dtls.Config { Certificates: []tls.Certificate { clientCert }, RootCAs: pool, ServerName: "radius.example.com" }
+  return uint16(listener.Addr().(*net.UDPAddr).Port), clientConfig
+}
+
+func TestDTLS(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+
+  port, clientConfig := startTestDTLS(t, dictionary, "nas.example.com")
+  radClient          := client.InitialiseDTLSClient(dictionary, "127.0.0.1", port, clientConfig, 1, 2)
+  defer radClient.Close()
+
+  _, err := radClient.Ping(protocol.AUTH)
+  assert.Equal(t, nil, err, "Status-Server is not answered over DTLS!")
+
+  for _, password := range []string { "password", "wrong" } {
+    result, err := radClient.AuthenticatePAP(context.Background(), "testing", []uint8(password), nil)
+    assert.Equal(t, nil,                    err,               "Access-Request is not answered over DTLS!")
+    assert.Equal(t, password == "password", result.Accepted(), "Access-Request is not handled correctly over DTLS!")
+  }
+}
+
+func TestDTLSUnmappedCertificate(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+
+  port, clientConfig := startTestDTLS(t, dictionary, "unknown.example.com")
+  radClient          := client.InitialiseDTLSClient(dictionary, "127.0.0.1", port, clientConfig, 0, 1)
+  defer radClient.Close()
+
+  _, err := radClient.AuthenticatePAP(context.Background(), "testing", []uint8("password"), nil)
+  assert.NotEqual(t, nil, err, "Request is answered for unmapped client certificate!")
+}
@@ -0,0 +1,481 @@
+// Two-factor authentication of Access-Request: password followed by HOTP/TOTP one-time password,
+// that is requested with Access-Challenge
+package server
+
+import (
+  "crypto/rand"
+  "crypto/subtle"
+  "errors"
+  "fmt"
+  "sync"
+  "time"
+
+  "github.com/MikhailMS/go-radius/protocol"
+  "github.com/MikhailMS/go-radius/tools"
+)
+
+// Defaults of MFAHandler, that could be changed with its setters
+const (
+  MFA_OTP_WINDOW   = 1
+  MFA_MAX_FAILURES = 5
+  MFA_LOCKOUT      = 15 * time.Minute
+)
+
+// IDs of attributes used by MFAHandler & UserPassword; they are looked up by ID, as their names differ between
+// dictionaries
+const (
+  userNameID     uint8 = 1
+  userPasswordID uint8 = 2
+  replyMessageID uint8 = 18
+  stateID        uint8 = 24
+  promptID       uint8 = 76
+)
+
+// OTPKind is kind of one-time password
+type OTPKind int
+
+const (
+  HOTP OTPKind = iota
+  TOTP
+)
+
+// OTPSecret is second factor of the user
+//
+// Counter of HOTP secret is the next counter value, that is expected; counter of TOTP secret is
+// the last time step, that was accepted, so the same code could not be used twice
+type OTPSecret struct {
+  kind    OTPKind
+  key     []uint8
+  digits  int
+  period  time.Duration
+  counter uint64
+}
+
+// InitialiseHOTPSecret initialises HOTP (RFC 4226) secret, which one-time passwords have 6 to 9
+// digits
+func InitialiseHOTPSecret(key []uint8, digits int, counter uint64) (OTPSecret, error) {
+  if digits < tools.OTP_MIN_DIGITS || digits > tools.OTP_MAX_DIGITS {
+    return OTPSecret{}, errors.New(fmt.Sprintf("one-time password should have %d to %d digits, got %d", tools.OTP_MIN_DIGITS, tools.OTP_MAX_DIGITS, digits))
+  }
+  return OTPSecret { kind: HOTP, key: key, digits: digits, counter: counter }, nil
+}
+
+// InitialiseTOTPSecret initialises TOTP (RFC 6238) secret with given time step period, that has
+// not been used yet; period should be at least one second
+func InitialiseTOTPSecret(key []uint8, digits int, period time.Duration) (OTPSecret, error) {
+  if period < time.Second {
+    return OTPSecret{}, errors.New("TOTP period should be at least one second")
+  }
+
+  secret, err := InitialiseHOTPSecret(key, digits, 0)
+  if err != nil {
+    return OTPSecret{}, err
+  }
+  secret.kind   = TOTP
+  secret.period = period
+  return secret, nil
+}
+
+// Kind returns kind of one-time password
+func (secret *OTPSecret) Kind() OTPKind {
+  return secret.kind
+}
+
+// Counter returns HOTP counter or last accepted TOTP time step
+func (secret *OTPSecret) Counter() uint64 {
+  return secret.counter
+}
+
+// OTPStore keeps OTP secrets of users
+type OTPStore interface {
+  // OTPSecret returns OTP secret of the user
+  OTPSecret(username string) (OTPSecret, bool)
+  // SetCounter stores counter of OTP secret of the user, once one-time password is accepted
+  SetCounter(username string, counter uint64) error
+}
+
+// MemoryOTPStore is OTPStore, that keeps secrets in memory
+type MemoryOTPStore struct {
+  mutex   sync.Mutex
+  secrets map[string]OTPSecret
+}
+
+// InitialiseMemoryOTPStore initialises empty MemoryOTPStore
+func InitialiseMemoryOTPStore() *MemoryOTPStore {
+  return &MemoryOTPStore { secrets: make(map[string]OTPSecret) }
+}
+
+// Add adds (or replaces) OTP secret of the user
+func (store *MemoryOTPStore) Add(username string, secret OTPSecret) {
+  store.mutex.Lock()
+  defer store.mutex.Unlock()
+
+  store.secrets[username] = secret
+}
+
+// OTPSecret returns OTP secret of the user
+func (store *MemoryOTPStore) OTPSecret(username string) (OTPSecret, bool) {
+  store.mutex.Lock()
+  defer store.mutex.Unlock()
+
+  secret, ok := store.secrets[username]
+  return secret, ok
+}
+
+// SetCounter stores counter of OTP secret of the user
+func (store *MemoryOTPStore) SetCounter(username string, counter uint64) error {
+  store.mutex.Lock()
+  defer store.mutex.Unlock()
+
+  secret, ok := store.secrets[username]
+  if !ok {
+    return errors.New("no OTP secret for user " + username)
+  }
+  secret.counter          = counter
+  store.secrets[username] = secret
+  return nil
+}
+
+// PasswordLookup returns cleartext password of the user
+type PasswordLookup func(username string) ([]uint8, bool)
+
+type mfaChallenge struct {
+  username string
+  created  time.Time
+}
+
+// mfaReply is reply to answer of Access-Challenge, that is sent again, if Access-Request is
+// retransmitted
+type mfaReply struct {
+  code       protocol.TypeCode
+  attributes []protocol.RadiusAttribute
+  created    time.Time
+}
+
+type mfaFailures struct {
+  count       int
+  lockedUntil time.Time
+}
+
+// MFAHandler authenticates Access-Requests with password and then with one-time password, that is
+// requested with Access-Challenge
+//
+// Failures of both factors are counted per user; once their number reaches the limit, the user is
+// locked out for a while
+//
+// Reply to answer of Access-Challenge is kept for timeout, so retransmitted Access-Request (with the
+// same State and Request Authenticator) gets the same reply, instead of being rejected as replay.
+// Answer, that arrives while one-time password of the same user is verified, is dropped
+//
+// PasswordLookup & OTPStore are called without lock held, so requests of different users are
+// handled concurrently
+type MFAHandler struct {
+  server      *Server
+  passwords   PasswordLookup
+  store       OTPStore
+  timeout     time.Duration
+  window      int
+  maxFailures int
+  lockout     time.Duration
+  now         func() time.Time
+
+  mutex       sync.Mutex
+  challenges  map[string]mfaChallenge
+  replies     map[string]mfaReply
+  failures    map[string]*mfaFailures
+  verifying   map[string]bool
+}
+
+// InitialiseMFAHandler initialises MFAHandler, that checks password returned by passwords and OTP
+// secret from store; user has to answer Access-Challenge within timeout
+func InitialiseMFAHandler(server *Server, passwords PasswordLookup, store OTPStore, timeout time.Duration) *MFAHandler {
+  return &MFAHandler {
+    server:      server,
+    passwords:   passwords,
+    store:       store,
+    timeout:     timeout,
+    window:      MFA_OTP_WINDOW,
+    maxFailures: MFA_MAX_FAILURES,
+    lockout:     MFA_LOCKOUT,
+    now:         time.Now,
+    challenges:  make(map[string]mfaChallenge),
+    replies:     make(map[string]mfaReply),
+    failures:    make(map[string]*mfaFailures),
+    verifying:   make(map[string]bool),
+  }
+}
+
+// **Optional**
+//
+// SetWindow sets number of HOTP counter values ahead of expected one, or TOTP time steps around
+// current one, that are accepted
+func (handler *MFAHandler) SetWindow(window int) {
+  handler.mutex.Lock()
+  defer handler.mutex.Unlock()
+
+  handler.window = window
+}
+
+// **Optional**
+//
+// SetLockout sets number of failures after which user is locked out and for how long
+func (handler *MFAHandler) SetLockout(maxFailures int, lockout time.Duration) {
+  handler.mutex.Lock()
+  defer handler.mutex.Unlock()
+
+  handler.maxFailures = maxFailures
+  handler.lockout     = lockout
+}
+
+// **Optional**
+//
+// SetClock sets function, that returns current time for TOTP time steps, timeouts of
+// Access-Challenges and lockouts; time.Now is used by default
+//
+// Please note that it should be called before MFAHandler starts handling requests
+func (handler *MFAHandler) SetClock(now func() time.Time) {
+  handler.now = now
+}
+
+// Handler returns Handler, that could be set on Runtime for AUTH requests
+func (handler *MFAHandler) Handler() Handler {
+  return func(request *Request) (protocol.TypeCode, []protocol.RadiusAttribute, error) {
+    return handler.HandleAccessRequest(request.Packet(), request.Secret())
+  }
+}
+
+// HandleAccessRequest handles Access-Request: without State it verifies password and replies with
+// Access-Challenge; with State it verifies one-time password carried in User-Password
+func (handler *MFAHandler) HandleAccessRequest(request *protocol.RadiusPacket, secret string) (protocol.TypeCode, []protocol.RadiusAttribute, error) {
+  userName := request.AttributeByID(userNameID)
+  username := string(userName.Value())
+  if len(username) == 0 {
+    return protocol.AccessReject, nil, errors.New("User-Name attribute is missing")
+  }
+
+  state    := request.AttributeByID(stateID)
+  replyKey := string(state.Value()) + string(request.Authenticator())
+
+  handler.mutex.Lock()
+  handler.expireChallenges()
+
+  if reply, ok := handler.replies[replyKey]; ok && len(state.Value()) > 0 {
+    handler.mutex.Unlock()
+    // Caller could append to attributes, e.g. Message-Authenticator, so cached ones are not shared
+    return reply.code, append([]protocol.RadiusAttribute(nil), reply.attributes...), nil
+  }
+
+  locked := handler.isLocked(username)
+  handler.mutex.Unlock()
+
+  if locked {
+    return handler.reject("Too many failed attempts, try again later")
+  }
+
+  password, err := decryptUserPassword(request, secret)
+  if err != nil {
+    return protocol.AccessReject, nil, err
+  }
+
+  if len(state.Value()) == 0 {
+    return handler.verifyPassword(username, password)
+  }
+
+  handler.mutex.Lock()
+  challenge, ok := handler.challenges[string(state.Value())]
+  if !ok || challenge.username != username {
+    handler.mutex.Unlock()
+    return handler.reject("")
+  }
+  if handler.verifying[username] {
+    handler.mutex.Unlock()
+    return protocol.AccessReject, nil, errors.New("one-time password of the user is being verified, Access-Request is dropped")
+  }
+  handler.verifying[username] = true
+  handler.mutex.Unlock()
+
+  code, attributes, err := handler.verifyOTP(username, password)
+
+  handler.mutex.Lock()
+  defer handler.mutex.Unlock()
+
+  // Every Access-Challenge could be answered only once
+  delete(handler.challenges, string(state.Value()))
+  delete(handler.verifying, username)
+  if err == nil {
+    handler.replies[replyKey] = mfaReply { code, append([]protocol.RadiusAttribute(nil), attributes...), handler.now() }
+  }
+  return code, attributes, err
+}
+
+// verifyPassword verifies the first factor and replies with Access-Challenge for one-time password
+func (handler *MFAHandler) verifyPassword(username string, password []uint8) (protocol.TypeCode, []protocol.RadiusAttribute, error) {
+  expected, ok := handler.passwords(username)
+  if !ok || subtle.ConstantTimeCompare(expected, password) != 1 {
+    handler.registerFailure(username)
+    return handler.reject("")
+  }
+
+  if _, ok := handler.store.OTPSecret(username); !ok {
+    return handler.reject("")
+  }
+
+  state := make([]uint8, 16)
+  if _, err := rand.Read(state); err != nil {
+    return protocol.AccessReject, nil, err
+  }
+  handler.mutex.Lock()
+  handler.challenges[string(state)] = mfaChallenge { username: username, created: handler.now() }
+  handler.mutex.Unlock()
+
+  message := []uint8("Enter one-time password")
+  prompt  := tools.IntegerToBytes(1)
+
+  attributes, err := handler.createAttributes([]mfaAttribute {
+    { replyMessageID, message },
+    { stateID,        state },
+    { promptID,       prompt },
+  })
+  if err != nil {
+    return protocol.AccessReject, nil, err
+  }
+  return protocol.AccessChallenge, attributes, nil
+}
+
+// verifyOTP verifies one-time password and moves counter of OTP secret past it
+func (handler *MFAHandler) verifyOTP(username string, otp []uint8) (protocol.TypeCode, []protocol.RadiusAttribute, error) {
+  secret, ok := handler.store.OTPSecret(username)
+  if !ok {
+    return handler.reject("")
+  }
+
+  handler.mutex.Lock()
+  window := handler.window
+  handler.mutex.Unlock()
+
+  var first, last uint64
+  switch secret.kind {
+    case HOTP:
+      first = secret.counter
+      last  = secret.counter + uint64(window)
+    case TOTP:
+      current, err := tools.TOTPStep(handler.now(), secret.period)
+      if err != nil {
+        return protocol.AccessReject, nil, err
+      }
+      if current > uint64(window) {
+        first = current - uint64(window)
+      }
+      last = current + uint64(window)
+
+      // Time steps, that are not later than the last accepted one, are replays
+      if first <= secret.counter {
+        first = secret.counter + 1
+      }
+  }
+
+  for counter := first; counter <= last; counter++ {
+    code, err := tools.HOTP(&secret.key, counter, secret.digits)
+    if err != nil {
+      return protocol.AccessReject, nil, err
+    }
+    if subtle.ConstantTimeCompare([]uint8(code), otp) != 1 {
+      continue
+    }
+
+    next := counter
+    if secret.kind == HOTP {
+      next = counter + 1
+    }
+    if err := handler.store.SetCounter(username, next); err != nil {
+      return protocol.AccessReject, nil, err
+    }
+
+    handler.mutex.Lock()
+    delete(handler.failures, username)
+    handler.mutex.Unlock()
+
+    return protocol.AccessAccept, nil, nil
+  }
+
+  handler.registerFailure(username)
+  return handler.reject("")
+}
+
+// reject returns Access-Reject with optional Reply-Message
+func (handler *MFAHandler) reject(message string) (protocol.TypeCode, []protocol.RadiusAttribute, error) {
+  if len(message) == 0 {
+    return protocol.AccessReject, nil, nil
+  }
+
+  attributes, err := handler.createAttributes([]mfaAttribute {{ replyMessageID, []uint8(message) }})
+  if err != nil {
+    return protocol.AccessReject, nil, err
+  }
+  return protocol.AccessReject, attributes, nil
+}
+
+// isLocked reports if the user is locked out
+//
+// Should be called with handler mutex held
+func (handler *MFAHandler) isLocked(username string) bool {
+  failures, ok := handler.failures[username]
+  return ok && handler.now().Before(failures.lockedUntil)
+}
+
+// registerFailure counts failure of the user and locks user out, once limit is reached
+func (handler *MFAHandler) registerFailure(username string) {
+  handler.mutex.Lock()
+  defer handler.mutex.Unlock()
+
+  failures, ok := handler.failures[username]
+  if !ok {
+    failures                   = &mfaFailures{}
+    handler.failures[username] = failures
+  }
+
+  failures.count++
+  if handler.maxFailures > 0 && failures.count >= handler.maxFailures {
+    failures.count       = 0
+    failures.lockedUntil = handler.now().Add(handler.lockout)
+  }
+}
+
+// expireChallenges removes Access-Challenges, that were not answered within timeout, and replies,
+// that were kept for longer than timeout
+//
+// Should be called with handler mutex held
+func (handler *MFAHandler) expireChallenges() {
+  now := handler.now()
+  for state, challenge := range handler.challenges {
+    if now.Sub(challenge.created) > handler.timeout {
+      delete(handler.challenges, state)
+    }
+  }
+
+  for key, reply := range handler.replies {
+    if now.Sub(reply.created) > handler.timeout {
+      delete(handler.replies, key)
+    }
+  }
+}
+
+type mfaAttribute struct {
+  id    uint8
+  value []uint8
+}
+
+// createAttributes creates attributes by their IDs
+func (handler *MFAHandler) createAttributes(values []mfaAttribute) ([]protocol.RadiusAttribute, error) {
+  var attributes []protocol.RadiusAttribute
+
+  for _, value := range values {
+    attr, err := handler.server.CreateAttributeByID(value.id, &value.value)
+    if err != nil {
+      return nil, err
+    }
+    attributes = append(attributes, attr)
+  }
+
+  return attributes, nil
+}
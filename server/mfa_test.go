@@ -0,0 +1,286 @@
+package server
+
+import (
+  "sync/atomic"
+  "testing"
+  "time"
+
+  "github.com/stretchr/testify/assert"
+
+  "github.com/MikhailMS/go-radius/client"
+  "github.com/MikhailMS/go-radius/protocol"
+  "github.com/MikhailMS/go-radius/tools"
+)
+
+var testOTPKey = []uint8("12345678901234567890")
+
+func testMFAPasswordLookup(username string) ([]uint8, bool) {
+  if username == "testing" {
+    return []uint8("password"), true
+  }
+  return nil, false
+}
+
+// testHOTP returns HOTP of testOTPKey for given counter
+func testHOTP(t *testing.T, counter uint64) string {
+  code, err := tools.HOTP(&testOTPKey, counter, 6)
+  if err != nil {
+    t.Fatal(err)
+  }
+  return code
+}
+
+// testTOTP returns TOTP of testOTPKey with 30 seconds period for given timestamp
+func testTOTP(t *testing.T, timestamp time.Time) string {
+  code, err := tools.TOTP(&testOTPKey, timestamp, 30 * time.Second, 6)
+  if err != nil {
+    t.Fatal(err)
+  }
+  return code
+}
+
+// testClock is clock of MFAHandler, that is moved by the test while requests are handled
+type testClock struct {
+  unix atomic.Int64
+}
+
+func newTestClock(timestamp time.Time) *testClock {
+  clock := &testClock{}
+  clock.unix.Store(timestamp.Unix())
+  return clock
+}
+
+func (clock *testClock) now() time.Time {
+  return time.Unix(clock.unix.Load(), 0)
+}
+
+func (clock *testClock) add(duration time.Duration) {
+  clock.unix.Add(int64(duration / time.Second))
+}
+
+// startTestMFA starts Runtime with MFAHandler, that is set up by configure, and returns Client
+// connected to it
+func startTestMFA(t *testing.T, store OTPStore, configure func(handler *MFAHandler)) client.Client {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+  allowedHosts  := map[string]string { "127.0.0.1": "secret" }
+
+  server  := InitialiseServer(dictionary, allowedHosts, "127.0.0.1", 1, 2)
+  handler := InitialiseMFAHandler(&server, testMFAPasswordLookup, store, time.Minute)
+  if configure != nil {
+    configure(handler)
+  }
+  runtime := InitialiseRuntime(&server)
+  runtime.SetHandler(protocol.AUTH, handler.Handler())
+
+  radClient := client.InitialiseClient(dictionary, "127.0.0.1", "secret", 1, 2)
+  radClient.SetPort(protocol.AUTH, startTestRuntime(t, runtime, protocol.AUTH))
+
+  return radClient
+}
+
+// authenticateTestMFA sends password and then answers Access-Challenge with OTP
+func authenticateTestMFA(t *testing.T, radClient *client.Client, password, otp string) protocol.RadiusPacket {
+  request       := radClient.CreateAuthRadiusPacket()
+  authenticator := request.Authenticator()
+  secret        := []uint8("secret")
+  userName      := []uint8("testing")
+  passwordBytes := []uint8(password)
+  encrypted     := tools.EncryptData(&passwordBytes, &authenticator, &secret)
+
+  userNameAttr, _ := radClient.CreateAttributeByID(userNameID, &userName)
+  passwordAttr, _ := radClient.CreateAttributeByID(userPasswordID, &encrypted)
+  request.SetAttributes([]protocol.RadiusAttribute { userNameAttr, passwordAttr })
+
+  reply, err := radClient.Converse(&request, func(challenge *client.Challenge) ([]uint8, error) {
+    assert.Equal(t, "Enter one-time password", challenge.ReplyMessage(), "OTP is not requested!")
+    return []uint8(otp), nil
+  })
+  if err != nil {
+    t.Fatal(err)
+  }
+  return reply
+}
+
+func TestMFAHandlerHOTP(t *testing.T) {
+  secret, _ := InitialiseHOTPSecret(testOTPKey, 6, 0)
+  store     := InitialiseMemoryOTPStore()
+  store.Add("testing", secret)
+
+  radClient := startTestMFA(t, store, nil)
+
+  // Code of counter 1 is accepted within window, code of counter 0 is not valid after it
+  reply := authenticateTestMFA(t, &radClient, "password", testHOTP(t, 1))
+  assert.Equal(t, protocol.AccessAccept, reply.Code(), "HOTP within window is not accepted!")
+
+  reply = authenticateTestMFA(t, &radClient, "password", testHOTP(t, 1))
+  assert.Equal(t, protocol.AccessReject, reply.Code(), "Replayed HOTP is accepted!")
+
+  reply = authenticateTestMFA(t, &radClient, "password", testHOTP(t, 0))
+  assert.Equal(t, protocol.AccessReject, reply.Code(), "HOTP behind counter is accepted!")
+
+  secret, _ = store.OTPSecret("testing")
+  assert.Equal(t, uint64(2), secret.Counter(), "HOTP counter is not moved!")
+}
+
+func TestMFAHandlerTOTP(t *testing.T) {
+  secret, _ := InitialiseTOTPSecret(testOTPKey, 6, 30 * time.Second)
+  store     := InitialiseMemoryOTPStore()
+  store.Add("testing", secret)
+
+  now       := time.Unix(1234567890, 0)
+  clock     := newTestClock(now)
+  radClient := startTestMFA(t, store, func(handler *MFAHandler) {
+    handler.SetClock(clock.now)
+  })
+
+  reply := authenticateTestMFA(t, &radClient, "password", testTOTP(t, now))
+  assert.Equal(t, protocol.AccessAccept, reply.Code(), "TOTP is not accepted!")
+
+  reply = authenticateTestMFA(t, &radClient, "password", testTOTP(t, now))
+  assert.Equal(t, protocol.AccessReject, reply.Code(), "Replayed TOTP is accepted!")
+
+  reply = authenticateTestMFA(t, &radClient, "password", testTOTP(t, now.Add(time.Hour)))
+  assert.Equal(t, protocol.AccessReject, reply.Code(), "TOTP outside of window is accepted!")
+
+  reply = authenticateTestMFA(t, &radClient, "password", testTOTP(t, now.Add(30 * time.Second)))
+  assert.Equal(t, protocol.AccessAccept, reply.Code(), "TOTP of the next time step is not accepted!")
+}
+
+func TestMFAHandlerLockout(t *testing.T) {
+  secret, _ := InitialiseHOTPSecret(testOTPKey, 6, 0)
+  store     := InitialiseMemoryOTPStore()
+  store.Add("testing", secret)
+
+  clock     := newTestClock(time.Unix(1234567890, 0))
+  radClient := startTestMFA(t, store, func(handler *MFAHandler) {
+    handler.SetLockout(3, time.Minute)
+    handler.SetClock(clock.now)
+  })
+
+  authenticateTestMFA(t, &radClient, "wrong",    testHOTP(t, 0))
+  authenticateTestMFA(t, &radClient, "password", "000000")
+  authenticateTestMFA(t, &radClient, "wrong",    testHOTP(t, 0))
+
+  reply        := authenticateTestMFA(t, &radClient, "password", testHOTP(t, 0))
+  replyMessage := reply.AttributeByID(replyMessageID)
+  assert.Equal(t, protocol.AccessReject,                        reply.Code(),               "Locked out user is accepted!")
+  assert.Equal(t, "Too many failed attempts, try again later", string(replyMessage.Value()), "Lockout is not reported!")
+
+  clock.add(2 * time.Minute)
+  reply = authenticateTestMFA(t, &radClient, "password", testHOTP(t, 0))
+  assert.Equal(t, protocol.AccessAccept, reply.Code(), "User is not unlocked after lockout!")
+}
+
+// testMFARequest creates Access-Request of the user with given password and State, if it is given
+func testMFARequest(server *Server, username, password string, state []uint8) protocol.RadiusPacket {
+  request       := protocol.InitialiseRadiusPacket(protocol.AccessRequest)
+  authenticator := request.Authenticator()
+  secret        := []uint8("secret")
+  userName      := []uint8(username)
+  passwordBytes := []uint8(password)
+  encrypted     := tools.EncryptData(&passwordBytes, &authenticator, &secret)
+
+  userNameAttr, _ := server.CreateAttributeByID(userNameID, &userName)
+  passwordAttr, _ := server.CreateAttributeByID(userPasswordID, &encrypted)
+  attributes      := []protocol.RadiusAttribute { userNameAttr, passwordAttr }
+  if len(state) > 0 {
+    stateAttr, _ := server.CreateAttributeByID(stateID, &state)
+    attributes    = append(attributes, stateAttr)
+  }
+  request.SetAttributes(attributes)
+
+  return request
+}
+
+func TestMFAHandlerRetransmission(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+  server        := InitialiseServer(dictionary, map[string]string { "127.0.0.1": "secret" }, "127.0.0.1", 1, 2)
+
+  secret, _ := InitialiseTOTPSecret(testOTPKey, 6, 30 * time.Second)
+  store     := InitialiseMemoryOTPStore()
+  store.Add("testing", secret)
+
+  now     := time.Unix(1234567890, 0)
+  handler := InitialiseMFAHandler(&server, testMFAPasswordLookup, store, time.Minute)
+  handler.SetClock(func() time.Time { return now })
+
+  request        := testMFARequest(&server, "testing", "password", nil)
+  code, reply, _ := handler.HandleAccessRequest(&request, "secret")
+  assert.Equal(t, protocol.AccessChallenge, code, "Password is not answered with Access-Challenge!")
+
+  var state []uint8
+  for _, attr := range reply {
+    if attr.ID() == stateID {
+      state = attr.Value()
+    }
+  }
+
+  // Retransmitted answer gets the same Access-Accept, while new Access-Request with the same TOTP is
+  // a replay
+  answer := testMFARequest(&server, "testing", testTOTP(t, now), state)
+  for i := 0; i < 2; i++ {
+    code, _, err := handler.HandleAccessRequest(&answer, "secret")
+    assert.Equal(t, nil,                   err,  "Answer of Access-Challenge is not handled!")
+    assert.Equal(t, protocol.AccessAccept, code, "Retransmitted answer of Access-Challenge is not accepted!")
+  }
+
+  replay    := testMFARequest(&server, "testing", testTOTP(t, now), state)
+  code, _, _ = handler.HandleAccessRequest(&replay, "secret")
+  assert.Equal(t, protocol.AccessReject, code, "Replayed TOTP is accepted!")
+}
+
+func TestOTPSecretValidation(t *testing.T) {
+  _, err := InitialiseTOTPSecret(testOTPKey, 6, 500 * time.Millisecond)
+  assert.Equal(t, "TOTP period should be at least one second", err.Error(), "Sub-second TOTP period is accepted!")
+
+  for _, digits := range []int{ 5, 10 } {
+    _, err = InitialiseHOTPSecret(testOTPKey, digits, 0)
+    assert.NotEqual(t, nil, err, "HOTP secret with unsupported number of digits is accepted!")
+
+    _, err = InitialiseTOTPSecret(testOTPKey, digits, 30 * time.Second)
+    assert.NotEqual(t, nil, err, "TOTP secret with unsupported number of digits is accepted!")
+  }
+}
+
+func TestMFAHandlerConcurrentUsers(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+  server        := InitialiseServer(dictionary, map[string]string { "127.0.0.1": "secret" }, "127.0.0.1", 1, 2)
+
+  secret, _ := InitialiseHOTPSecret(testOTPKey, 6, 0)
+  store     := InitialiseMemoryOTPStore()
+  store.Add("testing", secret)
+
+  // Password lookup of "slow" user blocks, until the test lets it go
+  entered   := make(chan struct{}, 1)
+  release   := make(chan struct{})
+  passwords := func(username string) ([]uint8, bool) {
+    if username == "slow" {
+      entered <- struct{}{}
+      <-release
+    }
+    return testMFAPasswordLookup(username)
+  }
+  handler := InitialiseMFAHandler(&server, passwords, store, time.Minute)
+  handler.SetLockout(3, time.Minute)
+  handler.SetWindow(2)
+
+  done := make(chan struct{})
+  go func() {
+    defer close(done)
+
+    request := testMFARequest(&server, "slow", "password", nil)
+    handler.HandleAccessRequest(&request, "secret")
+  }()
+  <-entered
+
+  // Other user is not held back by slow password lookup
+  request    := testMFARequest(&server, "testing", "password", nil)
+  code, _, _ := handler.HandleAccessRequest(&request, "secret")
+  assert.Equal(t, protocol.AccessChallenge, code, "Password is not answered, while other user is handled!")
+
+  close(release)
+  <-done
+}
@@ -0,0 +1,144 @@
+// MS-CHAPv1 & MS-CHAPv2 verification of Access-Request on RADIUS Server side
+package server
+
+import (
+  "crypto/subtle"
+  "errors"
+
+  "github.com/MikhailMS/go-radius/protocol"
+  "github.com/MikhailMS/go-radius/tools"
+)
+
+// VerifyMSChapV1 verifies MS-CHAPv1 Access-Request (RFC 2433) against cleartext password
+//
+// On success returns attributes, that should be added to Access-Accept: MS-CHAP-MPPE-Keys
+// encrypted with the secret, MS-MPPE-Encryption-Policy & MS-MPPE-Encryption-Types
+func (server *Server) VerifyMSChapV1(request *protocol.RadiusPacket, password []uint8, secret string) ([]protocol.RadiusAttribute, error) {
+  challenge, ok := request.VendorSpecificValue(tools.MICROSOFT_VENDOR_ID, tools.MS_CHAP_CHALLENGE)
+  if !ok || len(challenge) != 8 {
+    return nil, errors.New("MS-CHAP-Challenge attribute is missing or malformed")
+  }
+
+  // Ident (1) | Flags (1) | LM-Response (24) | NT-Response (24)
+  response, ok := request.VendorSpecificValue(tools.MICROSOFT_VENDOR_ID, tools.MS_CHAP_RESPONSE)
+  if !ok || len(response) != 50 {
+    return nil, errors.New("MS-CHAP-Response attribute is missing or malformed")
+  }
+
+  if response[1] & 0x01 == 0 {
+    return nil, errors.New("MS-CHAP-Response without NT-Response is not supported")
+  }
+
+  expectedResponse := tools.MSChapV1Response(&challenge, &password)
+  if subtle.ConstantTimeCompare(expectedResponse, response[26:50]) != 1 {
+    return nil, errors.New("MS-CHAP-Response mismatch")
+  }
+
+  // MS-CHAP-MPPE-Keys is encrypted the same way as User-Password (RFC 2548)
+  authenticator := request.Authenticator()
+  secretBytes   := []uint8(secret)
+  mppeKeys      := tools.MSChapV1MPPEKeys(&password)
+  mppeKeys       = tools.EncryptData(&mppeKeys, &authenticator, &secretBytes)
+
+  return server.createMicrosoftAttributes([]microsoftAttribute {
+    { tools.MS_CHAP_MPPE_KEYS,         mppeKeys },
+    { tools.MS_MPPE_ENCRYPTION_POLICY, tools.IntegerToBytes(1) },
+    { tools.MS_MPPE_ENCRYPTION_TYPES,  tools.IntegerToBytes(6) },
+  })
+}
+
+// VerifyMSChapV2 verifies MS-CHAPv2 Access-Request (RFC 2759) against cleartext password
+//
+// On success returns attributes, that should be added to Access-Accept: MS-CHAP2-Success with
+// authenticator response, MS-MPPE-Send-Key & MS-MPPE-Recv-Key encrypted with the secret,
+// MS-MPPE-Encryption-Policy & MS-MPPE-Encryption-Types
+func (server *Server) VerifyMSChapV2(request *protocol.RadiusPacket, password []uint8, secret string) ([]protocol.RadiusAttribute, error) {
+  success, err := server.VerifyMSChapV2Response(request, password)
+  if err != nil {
+    return nil, err
+  }
+
+  response, _ := request.VendorSpecificValue(tools.MICROSOFT_VENDOR_ID, tools.MS_CHAP2_RESPONSE)
+  ntResponse  := response[26:50]
+
+  sendKey, recvKey := tools.MSChapV2MPPEKeys(&password, &ntResponse)
+
+  authenticator := request.Authenticator()
+  secretBytes   := []uint8(secret)
+
+  encryptedSendKey, err := tools.EncryptMPPEKey(&sendKey, &authenticator, &secretBytes)
+  if err != nil {
+    return nil, err
+  }
+
+  encryptedRecvKey, err := tools.EncryptMPPEKey(&recvKey, &authenticator, &secretBytes)
+  if err != nil {
+    return nil, err
+  }
+
+  return server.createMicrosoftAttributes([]microsoftAttribute {
+    { tools.MS_CHAP2_SUCCESS,          success },
+    { tools.MS_MPPE_SEND_KEY,          encryptedSendKey },
+    { tools.MS_MPPE_RECV_KEY,          encryptedRecvKey },
+    { tools.MS_MPPE_ENCRYPTION_POLICY, tools.IntegerToBytes(1) },
+    { tools.MS_MPPE_ENCRYPTION_TYPES,  tools.IntegerToBytes(6) },
+  })
+}
+
+// VerifyMSChapV2Response verifies MS-CHAP2-Response of Access-Request (RFC 2759) against
+// cleartext password and returns value of MS-CHAP2-Success, without deriving MPPE keys
+//
+// It is useful, when MS-CHAPv2 is carried over another protocol, e.g. inside EAP-TTLS tunnel,
+// that has its own keying material
+func (server *Server) VerifyMSChapV2Response(request *protocol.RadiusPacket, password []uint8) ([]uint8, error) {
+  authChallenge, ok := request.VendorSpecificValue(tools.MICROSOFT_VENDOR_ID, tools.MS_CHAP_CHALLENGE)
+  if !ok || len(authChallenge) != 16 {
+    return nil, errors.New("MS-CHAP-Challenge attribute is missing or malformed")
+  }
+
+  // Ident (1) | Flags (1) | Peer-Challenge (16) | Reserved (8) | NT-Response (24)
+  response, ok := request.VendorSpecificValue(tools.MICROSOFT_VENDOR_ID, tools.MS_CHAP2_RESPONSE)
+  if !ok || len(response) != 50 {
+    return nil, errors.New("MS-CHAP2-Response attribute is missing or malformed")
+  }
+
+  userNameAttr := request.AttributeByName("User-Name")
+  userName     := userNameAttr.Value()
+  if len(userName) == 0 {
+    return nil, errors.New("User-Name attribute is missing")
+  }
+
+  peerChallenge := response[2:18]
+  ntResponse    := response[26:50]
+
+  expectedResponse := tools.MSChapV2NTResponse(&authChallenge, &peerChallenge, &userName, &password)
+  if subtle.ConstantTimeCompare(expectedResponse, ntResponse) != 1 {
+    return nil, errors.New("MS-CHAP2-Response mismatch")
+  }
+
+  authResponse := tools.MSChapV2AuthenticatorResponse(&password, &ntResponse, &peerChallenge, &authChallenge, &userName)
+  return append([]uint8{ response[0] }, []uint8(authResponse)...), nil
+}
+
+// microsoftAttribute represents Microsoft Vendor-Specific sub-attribute
+type microsoftAttribute struct {
+  vendorType uint8
+  value      []uint8
+}
+
+// createMicrosoftAttributes wraps each Microsoft sub-attribute into its own Vendor-Specific attribute
+func (server *Server) createMicrosoftAttributes(msAttributes []microsoftAttribute) ([]protocol.RadiusAttribute, error) {
+  var attributes []protocol.RadiusAttribute
+
+  for _, msAttr := range msAttributes {
+    vsaBytes := tools.VendorSpecificToBytes(tools.MICROSOFT_VENDOR_ID, msAttr.vendorType, &msAttr.value)
+
+    vsaAttr, err := server.CreateAttributeByID(protocol.VENDOR_SPECIFIC_ID, &vsaBytes)
+    if err != nil {
+      return nil, err
+    }
+    attributes = append(attributes, vsaAttr)
+  }
+
+  return attributes, nil
+}
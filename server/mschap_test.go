@@ -0,0 +1,100 @@
+package server
+
+import (
+  "testing"
+
+  "github.com/stretchr/testify/assert"
+
+  "github.com/MikhailMS/go-radius/protocol"
+  "github.com/MikhailMS/go-radius/tools"
+)
+
+func createMicrosoftAttribute(server *Server, vendorType uint8, value []uint8) protocol.RadiusAttribute {
+  vsaBytes   := tools.VendorSpecificToBytes(tools.MICROSOFT_VENDOR_ID, vendorType, &value)
+  vsaAttr, _ := server.CreateAttributeByID(protocol.VENDOR_SPECIFIC_ID, &vsaBytes)
+
+  return vsaAttr
+}
+
+func TestVerifyMSChapV2(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+  allowedHosts  := map[string]string { "127.0.0.1": "secret" }
+
+  server := InitialiseServer(dictionary, allowedHosts, "127.0.0.1", 1, 2)
+
+  // Test vectors are taken from RFC 2759 (section 9.2)
+  userName      := []uint8("User")
+  authChallenge := []uint8{ 0x5B, 0x5D, 0x7C, 0x7D, 0x7B, 0x3F, 0x2F, 0x3E, 0x3C, 0x2C, 0x60, 0x21, 0x32, 0x26, 0x26, 0x28 }
+  peerChallenge := []uint8{ 0x21, 0x40, 0x23, 0x24, 0x25, 0x5E, 0x26, 0x2A, 0x28, 0x29, 0x5F, 0x2B, 0x3A, 0x33, 0x7C, 0x7E }
+  ntResponse    := []uint8{ 0x82, 0x30, 0x9E, 0xCD, 0x8D, 0x70, 0x8B, 0x5E, 0xA0, 0x8F, 0xAA, 0x39, 0x81, 0xCD, 0x83, 0x54, 0x42, 0x33, 0x11, 0x4A, 0x3D, 0x85, 0xD6, 0xDF }
+  expectedSendKey := []uint8{ 0x8B, 0x7C, 0xDC, 0x14, 0x9B, 0x99, 0x3A, 0x1B, 0xA1, 0x18, 0xCB, 0x15, 0x3F, 0x56, 0xDC, 0xCB }
+
+  response := []uint8{ 7, 0 }
+  response  = append(response, peerChallenge...)
+  response  = append(response, make([]uint8, 8)...)
+  response  = append(response, ntResponse...)
+
+  userNameAttr, _ := server.CreateAttributeByName("User-Name", &userName)
+  attributes      := []protocol.RadiusAttribute {
+    userNameAttr,
+    createMicrosoftAttribute(&server, tools.MS_CHAP_CHALLENGE, authChallenge),
+    createMicrosoftAttribute(&server, tools.MS_CHAP2_RESPONSE, response),
+  }
+
+  radPacket := protocol.InitialiseRadiusPacket(protocol.AccessRequest)
+  radPacket.SetAttributes(attributes)
+
+  replyAttributes, err := server.VerifyMSChapV2(&radPacket, []uint8("clientPass"), "secret")
+  assert.Equal(t, nil, err, "Valid MS-CHAP2-Response is not verified!")
+
+  reply := protocol.InitialiseRadiusPacket(protocol.AccessAccept)
+  reply.SetAttributes(replyAttributes)
+
+  success, _ := reply.VendorSpecificValue(tools.MICROSOFT_VENDOR_ID, tools.MS_CHAP2_SUCCESS)
+  assert.Equal(t, append([]uint8{ 7 }, []uint8("S=407A5589115FD0D6209F510FE9C04566932CDA56")...), success, "MS-CHAP2-Success is not correct!")
+
+  authenticator   := radPacket.Authenticator()
+  secret          := []uint8("secret")
+  encryptedKey, _ := reply.VendorSpecificValue(tools.MICROSOFT_VENDOR_ID, tools.MS_MPPE_SEND_KEY)
+  sendKey, _      := tools.DecryptMPPEKey(&encryptedKey, &authenticator, &secret)
+  assert.Equal(t, expectedSendKey, sendKey, "MS-MPPE-Send-Key is not correct!")
+
+  _, err = server.VerifyMSChapV2(&radPacket, []uint8("wrong"), "secret")
+  assert.Equal(t, "MS-CHAP2-Response mismatch", err.Error(), "Invalid MS-CHAP2-Response is verified!")
+
+  // MS-CHAP2-Response could be verified without deriving MPPE keys
+  responseSuccess, err := server.VerifyMSChapV2Response(&radPacket, []uint8("clientPass"))
+  assert.Equal(t, nil,     err,             "Valid MS-CHAP2-Response is not verified!")
+  assert.Equal(t, success, responseSuccess, "MS-CHAP2-Success is not correct!")
+}
+
+func TestVerifyMSChapV1(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+  allowedHosts  := map[string]string { "127.0.0.1": "secret" }
+
+  server := InitialiseServer(dictionary, allowedHosts, "127.0.0.1", 1, 2)
+
+  password  := []uint8("clientPass")
+  challenge := []uint8{ 0xD0, 0x2E, 0x43, 0x86, 0xBC, 0xE9, 0x12, 0x26 }
+
+  response := []uint8{ 7, 1 }
+  response  = append(response, make([]uint8, 24)...)
+  response  = append(response, tools.MSChapV1Response(&challenge, &password)...)
+
+  attributes := []protocol.RadiusAttribute {
+    createMicrosoftAttribute(&server, tools.MS_CHAP_CHALLENGE, challenge),
+    createMicrosoftAttribute(&server, tools.MS_CHAP_RESPONSE,  response),
+  }
+
+  radPacket := protocol.InitialiseRadiusPacket(protocol.AccessRequest)
+  radPacket.SetAttributes(attributes)
+
+  replyAttributes, err := server.VerifyMSChapV1(&radPacket, password, "secret")
+  assert.Equal(t, nil, err, "Valid MS-CHAP-Response is not verified!")
+  assert.Equal(t, 3, len(replyAttributes), "MPPE attributes are not returned!")
+
+  _, err = server.VerifyMSChapV1(&radPacket, []uint8("wrong"), "secret")
+  assert.Equal(t, "MS-CHAP-Response mismatch", err.Error(), "Invalid MS-CHAP-Response is verified!")
+}
@@ -0,0 +1,124 @@
+// RadSec (RADIUS over TLS, RFC 6614) transport of Runtime
+package server
+
+import (
+  "crypto/tls"
+  "errors"
+  "fmt"
+  "log"
+  "net"
+  "strconv"
+  "time"
+
+  "github.com/MikhailMS/go-radius/protocol"
+)
+
+// RADSEC_PORT is the default port of RadSec (RFC 6614, section 2.1)
+const RADSEC_PORT = 2083
+
+// RADSEC_HANDSHAKE_TIMEOUT is time RadSec client has to complete TLS handshake
+const RADSEC_HANDSHAKE_TIMEOUT = 10 * time.Second
+
+// CertificateMapper maps verified TLS connection of RadSec client to allowed host, which is then
+// available as RemoteHost of its requests; connection is closed if no host is returned
+type CertificateMapper func(state tls.ConnectionState) (string, bool)
+
+// **Optional**
+//
+// SetCertificateMapper sets CertificateMapper, that identifies RadSec clients
+//
+// By default DNS names, Common Name & IP addresses of client certificate are looked up in allowed
+// hosts of Server, so RadSec clients are allowed by their certificates instead of IP addresses.
+// Such hosts should be allowed with RADSEC_SECRET, so **UserPassword** works for their requests
+func (runtime *Runtime) SetCertificateMapper(mapper CertificateMapper) {
+  runtime.mapper = mapper
+}
+
+// ListenAndServeTLS starts RadSec listener on Server address & given port, and blocks until
+// listener is closed
+func (runtime *Runtime) ListenAndServeTLS(port uint16, config *tls.Config) error {
+  listener, err := net.Listen("tcp", net.JoinHostPort(runtime.server.Server(), strconv.Itoa(int(port))))
+  if err != nil {
+    return err
+  }
+
+  return runtime.ServeTLS(listener, config)
+}
+
+// ServeTLS accepts RadSec connections from given listener and serves requests received over them,
+// until listener is closed
+//
+// Client certificate is always required and verified against ClientCAs of config. All RADIUS
+// Message Types are served over the same connection, protected with RADSEC_SECRET
+func (runtime *Runtime) ServeTLS(listener net.Listener, config *tls.Config) error {
+  tlsConfig           := config.Clone()
+  tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+  if tlsConfig.MinVersion < tls.VersionTLS12 {
+    tlsConfig.MinVersion = tls.VersionTLS12
+  }
+
+  runtime.trackConn(listener)
+  tlsListener := tls.NewListener(listener, tlsConfig)
+
+  for {
+    conn, err := tlsListener.Accept()
+    if err != nil {
+      if errors.Is(err, net.ErrClosed) {
+        return nil
+      }
+      return err
+    }
+
+    go runtime.serveTLSConn(conn.(*tls.Conn))
+  }
+}
+
+// serveTLSConn completes TLS handshake, identifies RadSec client by its certificate and serves
+// its requests
+func (runtime *Runtime) serveTLSConn(conn *tls.Conn) {
+  runtime.trackConn(conn)
+  defer runtime.untrackConn(conn)
+  defer conn.Close()
+
+  conn.SetDeadline(time.Now().Add(RADSEC_HANDSHAKE_TIMEOUT))
+  if err := conn.Handshake(); err != nil {
+    log.Println(fmt.Sprintf("WARNING: RadSec handshake with %s failed: %s", conn.RemoteAddr().String(), err))
+    return
+  }
+  conn.SetDeadline(time.Time{})
+
+  mapper := runtime.mapper
+  if mapper == nil {
+    mapper = runtime.mapCertificate
+  }
+
+  remoteHost, ok := mapper(conn.ConnectionState())
+  if !ok {
+    log.Println(fmt.Sprintf("WARNING: closed RadSec connection from %s: certificate is not mapped to allowed host", conn.RemoteAddr().String()))
+    return
+  }
+
+  runtime.serveStream(conn, streamMsgType, remoteHost, protocol.RADSEC_SECRET)
+}
+
+// mapCertificate looks up DNS names, Common Name & IP addresses of client certificate in allowed
+// hosts of Server
+func (runtime *Runtime) mapCertificate(state tls.ConnectionState) (string, bool) {
+  if len(state.PeerCertificates) == 0 {
+    return "", false
+  }
+  certificate := state.PeerCertificates[0]
+
+  names := append([]string{}, certificate.DNSNames...)
+  names  = append(names, certificate.Subject.CommonName)
+  for _, ip := range certificate.IPAddresses {
+    names = append(names, ip.String())
+  }
+
+  for _, name := range names {
+    if len(name) > 0 && runtime.server.IsHostAllowed(name) {
+      return name, true
+    }
+  }
+  return "", false
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+  "context"
+  "crypto/rand"
+  "crypto/rsa"
+  "crypto/tls"
+  "crypto/x509"
+  "crypto/x509/pkix"
+  "errors"
+  "math/big"
+  "net"
+  "sync"
+  "testing"
+  "time"
+
+  "github.com/stretchr/testify/assert"
+
+  "github.com/MikhailMS/go-radius/client"
+  "github.com/MikhailMS/go-radius/protocol"
+)
+
+// createTestCertificate creates RSA certificate signed by parent (self-signed if parent is nil)
+func createTestCertificate(t *testing.T, commonName string, isCA bool, parent *tls.Certificate) tls.Certificate {
+  key, err := rsa.GenerateKey(rand.Reader, 2048)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  template := &x509.Certificate {
+    SerialNumber:          big.NewInt(time.Now().UnixNano()),
+    Subject:               pkix.Name { CommonName: commonName },
+    DNSNames:              []string { commonName },
+    NotBefore:             time.Now().Add(-time.Hour),
+    NotAfter:              time.Now().Add(time.Hour),
+    IsCA:                  isCA,
+    BasicConstraintsValid: true,
+    KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageCertSign,
+    ExtKeyUsage:           []x509.ExtKeyUsage { x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth },
+  }
+
+  parentCert, parentKey := template, interface{}(key)
+  var chain [][]uint8
+  if parent != nil {
+    parentCert = parent.Leaf
+    parentKey  = parent.PrivateKey
+    chain      = parent.Certificate
+  }
+
+  der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+  if err != nil {
+    t.Fatal(err)
+  }
+  leaf, _ := x509.ParseCertificate(der)
+
+  return tls.Certificate { Certificate: append([][]uint8{ der }, chain...), PrivateKey: key, Leaf: leaf }
+}
+
+// startTestRadSec starts RadSec Runtime, that accepts "testing" user with "password", and returns
+// its port & TLS config of client with certificate for given name
+func startTestRadSec(t *testing.T, dictionary protocol.Dictionary, clientName string) (uint16, *tls.Config) {
+  ca         := createTestCertificate(t, "Test CA", true, nil)
+  serverCert := createTestCertificate(t, "radius.example.com", false, &ca)
+  clientCert := createTestCertificate(t, clientName, false, &ca)
+
+  pool := x509.NewCertPool()
+  pool.AddCert(ca.Leaf)
+
+  allowedHosts := map[string]string { "nas.example.com": protocol.RADSEC_SECRET }
+  server       := InitialiseServer(dictionary, allowedHosts, "127.0.0.1", 1, 2)
+  runtime      := InitialiseRuntime(&server)
+
+  runtime.SetHandler(protocol.AUTH, func(request *Request) (protocol.TypeCode, []protocol.RadiusAttribute, error) {
+    if request.RemoteHost() != "nas.example.com" || request.Secret() != protocol.RADSEC_SECRET {
+      return protocol.AccessReject, nil, errors.New("RadSec client is not identified")
+    }
+
+    password, err := server.UserPassword(request.Packet(), request.RemoteHost())
+    if err != nil || string(password) != "password" {
+      return protocol.AccessReject, nil, nil
+    }
+    return protocol.AccessAccept, nil, nil
+  })
+
+  listener, err := net.Listen("tcp", "127.0.0.1:0")
+  if err != nil {
+    t.Fatal(err)
+  }
+  go runtime.ServeTLS(listener, &tls.Config { Certificates: []tls.Certificate { serverCert }, ClientCAs: pool })
+  t.Cleanup(func() { runtime.Close() })
+
+  clientConfig := &tls.Config { Certificates: []tls.Certificate { clientCert }, RootCAs: pool, ServerName: "radius.example.com" }
+  return uint16(listener.Addr().(*net.TCPAddr).Port), clientConfig
+}
+
+func TestRadSec(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+
+  port, clientConfig := startTestRadSec(t, dictionary, "nas.example.com")
+  radClient          := client.InitialiseRadSecClient(dictionary, "127.0.0.1", port, clientConfig, 2)
+  defer radClient.Close()
+
+  _, err := radClient.Ping(protocol.AUTH)
+  assert.Equal(t, nil, err, "Status-Server is not answered over RadSec!")
+
+  // Requests are multiplexed over the same connection
+  var wg sync.WaitGroup
+  for _, password := range []string { "password", "wrong", "password", "wrong" } {
+    wg.Add(1)
+    go func(password string) {
+      defer wg.Done()
+
+      result, err := radClient.AuthenticatePAP(context.Background(), "testing", []uint8(password), nil)
+      assert.Equal(t, nil,                    err,               "Access-Request is not answered over RadSec!")
+      assert.Equal(t, password == "password", result.Accepted(), "Access-Request is not handled correctly over RadSec!")
+    }(password)
+  }
+  wg.Wait()
+}
+
+func TestRadSecUnmappedCertificate(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+
+  port, clientConfig := startTestRadSec(t, dictionary, "unknown.example.com")
+  radClient          := client.InitialiseRadSecClient(dictionary, "127.0.0.1", port, clientConfig, 2)
+  defer radClient.Close()
+
+  _, err := radClient.AuthenticatePAP(context.Background(), "testing", []uint8("password"), nil)
+  assert.NotEqual(t, nil, err, "Request is answered for unmapped client certificate!")
+
+  clientConfig.Certificates = nil
+  radClient                 = client.InitialiseRadSecClient(dictionary, "127.0.0.1", port, clientConfig, 2)
+  defer radClient.Close()
+
+  _, err = radClient.AuthenticatePAP(context.Background(), "testing", []uint8("password"), nil)
+  assert.NotEqual(t, nil, err, "Request is answered without client certificate!")
+}
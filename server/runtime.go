@@ -0,0 +1,339 @@
+// RADIUS Server runtime, that receives requests from network and passes them to handlers
+package server
+
+import (
+  "errors"
+  "fmt"
+  "io"
+  "log"
+  "net"
+  "strconv"
+  "sync"
+  "time"
+
+  "github.com/MikhailMS/go-radius/protocol"
+)
+
+// Request represents RADIUS request received by Runtime, that is passed to Handler
+type Request struct {
+  packet     protocol.RadiusPacket
+  remoteAddr net.Addr
+  remoteHost string
+  secret     string
+  msgType    protocol.RadiusMsgType
+}
+
+// Packet returns RadiusPacket of the request
+func (request *Request) Packet() *protocol.RadiusPacket {
+  return &request.packet
+}
+
+// RemoteAddr returns network address request was received from
+func (request *Request) RemoteAddr() net.Addr {
+  return request.remoteAddr
+}
+
+// RemoteHost returns allowed host request was received from
+func (request *Request) RemoteHost() string {
+  return request.remoteHost
+}
+
+// Secret returns secret shared with the host request was received from
+func (request *Request) Secret() string {
+  return request.secret
+}
+
+// MsgType returns type of the socket request was received on
+func (request *Request) MsgType() protocol.RadiusMsgType {
+  return request.msgType
+}
+
+// Handler processes RADIUS request and returns TypeCode & attributes of the reply
+//
+// If Handler returns an error, request is dropped and no reply is sent
+type Handler func(request *Request) (protocol.TypeCode, []protocol.RadiusAttribute, error)
+
+// Runtime receives RADIUS requests, verifies them and passes them to Handler, set for given
+// RADIUS Message Type
+//
+// Status-Server requests (RFC 5997) are answered by Runtime itself on AUTH & ACCT sockets
+type Runtime struct {
+  server      *Server
+  handlers    map[protocol.RadiusMsgType]Handler
+  mapper      CertificateMapper
+  idleTimeout time.Duration
+
+  mutex       sync.Mutex
+  conns       []io.Closer
+}
+
+// InitialiseRuntime initialises Runtime for given Server
+//
+// Please note that you would need to call **SetHandler** for each RADIUS Message Type Runtime
+// should serve
+func InitialiseRuntime(server *Server) *Runtime {
+  return &Runtime { server: server, handlers: make(map[protocol.RadiusMsgType]Handler), idleTimeout: STREAM_IDLE_TIMEOUT }
+}
+
+// SetHandler sets Handler, that processes requests of specific RADIUS Message Type
+func (runtime *Runtime) SetHandler(msgType protocol.RadiusMsgType, handler Handler) {
+  runtime.handlers[msgType] = handler
+}
+
+// Server returns Server Runtime is running for
+func (runtime *Runtime) Server() *Server {
+  return runtime.server
+}
+
+// HandleRequest runs request through Runtime pipeline and returns reply bytes
+//
+// This function is transport agnostic, so could be used to serve requests received over any
+// transport
+func (runtime *Runtime) HandleRequest(msgType protocol.RadiusMsgType, request []uint8, remoteAddr net.Addr) ([]uint8, error) {
+  remoteHost := hostFromAddr(remoteAddr)
+
+  if !runtime.server.IsHostAllowed(remoteHost) {
+    return nil, errors.New(fmt.Sprintf("host %s is not allowed", remoteHost))
+  }
+
+  return runtime.handle(msgType, request, remoteAddr, remoteHost, runtime.server.Secret(remoteHost))
+}
+
+// ListenAndServe starts UDP listeners on Server address for each RADIUS Message Type, that has
+// Handler set (and for AUTH & ACCT, so Status-Server is always answered), and blocks until
+// listeners are closed
+func (runtime *Runtime) ListenAndServe() error {
+  var wg sync.WaitGroup
+
+  msgTypes := []protocol.RadiusMsgType { protocol.AUTH, protocol.ACCT, protocol.COA }
+  errs     := make(chan error, len(msgTypes))
+
+  for _, msgType := range msgTypes {
+    _, hasHandler := runtime.handlers[msgType]
+    if !hasHandler && msgType == protocol.COA {
+      continue
+    }
+
+    port, ok := runtime.server.Port(msgTypeToTypeCode(msgType))
+    if !ok || port == 0 {
+      continue
+    }
+
+    conn, err := net.ListenPacket("udp", net.JoinHostPort(runtime.server.Server(), strconv.Itoa(int(port))))
+    if err != nil {
+      runtime.Close()
+      return err
+    }
+    runtime.trackConn(conn)
+
+    wg.Add(1)
+    go func(conn net.PacketConn, msgType protocol.RadiusMsgType) {
+      defer wg.Done()
+      errs <- runtime.ServePacketConn(conn, msgType)
+    }(conn, msgType)
+  }
+
+  wg.Wait()
+  close(errs)
+
+  for err := range errs {
+    if err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+// ServePacketConn reads RADIUS requests from given connection and replies to them, until
+// connection is closed
+func (runtime *Runtime) ServePacketConn(conn net.PacketConn, msgType protocol.RadiusMsgType) error {
+  runtime.trackConn(conn)
+
+  buffer := make([]uint8, 4096)
+
+  for {
+    n, addr, err := conn.ReadFrom(buffer)
+    if err != nil {
+      if errors.Is(err, net.ErrClosed) {
+        return nil
+      }
+      return err
+    }
+
+    request := make([]uint8, n)
+    copy(request, buffer[:n])
+
+    go func() {
+      reply, err := runtime.HandleRequest(msgType, request, addr)
+      if err != nil {
+        log.Println(fmt.Sprintf("WARNING: dropped request from %s: %s", addr.String(), err))
+        return
+      }
+
+      conn.WriteTo(reply, addr)
+    }()
+  }
+}
+
+// Close closes all connections & listeners served by Runtime
+func (runtime *Runtime) Close() error {
+  runtime.mutex.Lock()
+  defer runtime.mutex.Unlock()
+
+  var lastErr error
+  for _, conn := range runtime.conns {
+    if err := conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+      lastErr = err
+    }
+  }
+  runtime.conns = nil
+
+  return lastErr
+}
+
+// trackConn remembers connection or listener, so it is closed when Runtime is closed
+func (runtime *Runtime) trackConn(conn io.Closer) {
+  runtime.mutex.Lock()
+  defer runtime.mutex.Unlock()
+
+  for _, trackedConn := range runtime.conns {
+    if trackedConn == conn {
+      return
+    }
+  }
+  runtime.conns = append(runtime.conns, conn)
+}
+
+// untrackConn forgets connection, that is already closed
+func (runtime *Runtime) untrackConn(conn io.Closer) {
+  runtime.mutex.Lock()
+  defer runtime.mutex.Unlock()
+
+  for i, trackedConn := range runtime.conns {
+    if trackedConn == conn {
+      runtime.conns = append(runtime.conns[:i], runtime.conns[i+1:]...)
+      return
+    }
+  }
+}
+
+// handle verifies request received from allowed host, passes it to Handler and builds reply
+func (runtime *Runtime) handle(msgType protocol.RadiusMsgType, request []uint8, remoteAddr net.Addr, remoteHost, secret string) ([]uint8, error) {
+  packet, err := runtime.server.InitialisePacketFromBytes(&request)
+  if err != nil {
+    return nil, err
+  }
+
+  if !isCodeAllowed(msgType, packet.Code()) {
+    return nil, errors.New(fmt.Sprintf("request with code %d is not allowed on this socket", packet.Code()))
+  }
+
+  _, msgAuthErr := packet.MessageAuthenticator()
+  hasMsgAuth    := msgAuthErr == nil
+
+  if hasMsgAuth {
+    if err := runtime.server.VerifyRequestMessageAuthenticator(&request, secret); err != nil {
+      return nil, err
+    }
+  } else {
+    // RFC 3579: packets with EAP-Message, but without Message-Authenticator must be silently discarded
+    eapMessage := packet.AttributeByID(protocol.EAP_MESSAGE_ID)
+    if eapMessage.ID() == protocol.EAP_MESSAGE_ID {
+      return nil, errors.New("request with EAP-Message has no Message-Authenticator")
+    }
+  }
+
+  switch packet.Code() {
+    case protocol.AccountingRequest, protocol.CoARequest, protocol.DisconnectRequest:
+      if err := runtime.server.VerifyRequestAuthenticator(&request, secret); err != nil {
+        return nil, err
+      }
+  }
+
+  var replyCode       protocol.TypeCode
+  var replyAttributes []protocol.RadiusAttribute
+
+  if packet.Code() == protocol.StatusServer {
+    // RFC 5997: Status-Server packets without Message-Authenticator must be silently discarded
+    if !hasMsgAuth {
+      return nil, errors.New("Status-Server request has no Message-Authenticator")
+    }
+
+    if msgType == protocol.AUTH {
+      replyCode = protocol.AccessAccept
+    } else {
+      replyCode = protocol.AccountingResponse
+    }
+  } else {
+    handler, ok := runtime.handlers[msgType]
+    if !ok {
+      return nil, errors.New("no handler set for this RADIUS Message Type")
+    }
+
+    replyCode, replyAttributes, err = handler(&Request { packet, remoteAddr, remoteHost, secret, msgType })
+    if err != nil {
+      return nil, err
+    }
+  }
+
+  var reply protocol.RadiusPacket
+
+  if hasMsgAuth {
+    reply, err = runtime.server.CreateSignedReplyPacket(replyCode, replyAttributes, &request, secret)
+  } else {
+    reply, err = runtime.server.CreateReplyPacket(replyCode, replyAttributes, &request, secret)
+  }
+  if err != nil {
+    return nil, err
+  }
+
+  replyBytes, ok := reply.ToBytes()
+  if !ok {
+    return nil, errors.New("failed to convert reply RadiusPacket to bytes")
+  }
+  return replyBytes, nil
+}
+
+// isCodeAllowed checks if request with given TypeCode could be received on socket of given RADIUS
+// Message Type
+func isCodeAllowed(msgType protocol.RadiusMsgType, code protocol.TypeCode) bool {
+  switch msgType {
+    case protocol.AUTH:
+      return code == protocol.AccessRequest || code == protocol.StatusServer
+    case protocol.ACCT:
+      return code == protocol.AccountingRequest || code == protocol.StatusServer
+    case protocol.COA:
+      return code == protocol.CoARequest || code == protocol.DisconnectRequest
+    default:
+      return false
+  }
+}
+
+// msgTypeToTypeCode returns TypeCode of the request, that is sent to socket of given RADIUS
+// Message Type
+func msgTypeToTypeCode(msgType protocol.RadiusMsgType) protocol.TypeCode {
+  switch msgType {
+    case protocol.ACCT:
+      return protocol.AccountingRequest
+    case protocol.COA:
+      return protocol.CoARequest
+    default:
+      return protocol.AccessRequest
+  }
+}
+
+// hostFromAddr returns IP address of remote host without port
+func hostFromAddr(addr net.Addr) string {
+  switch addr := addr.(type) {
+    case *net.UDPAddr:
+      return addr.IP.String()
+    case *net.TCPAddr:
+      return addr.IP.String()
+    default:
+      host, _, err := net.SplitHostPort(addr.String())
+      if err != nil {
+        return addr.String()
+      }
+      return host
+  }
+}
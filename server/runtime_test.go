@@ -0,0 +1,114 @@
+package server
+
+import (
+  "net"
+  "testing"
+
+  "github.com/stretchr/testify/assert"
+
+  "github.com/MikhailMS/go-radius/client"
+  "github.com/MikhailMS/go-radius/protocol"
+)
+
+func startTestRuntime(t *testing.T, runtime *Runtime, msgType protocol.RadiusMsgType) uint16 {
+  conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  go runtime.ServePacketConn(conn, msgType)
+  t.Cleanup(func() { runtime.Close() })
+
+  return uint16(conn.LocalAddr().(*net.UDPAddr).Port)
+}
+
+func TestRuntimeAnswersStatusServer(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+  allowedHosts  := map[string]string { "127.0.0.1": "secret" }
+
+  server  := InitialiseServer(dictionary, allowedHosts, "127.0.0.1", 1, 2)
+  runtime := InitialiseRuntime(&server)
+
+  authPort := startTestRuntime(t, runtime, protocol.AUTH)
+  acctPort := startTestRuntime(t, runtime, protocol.ACCT)
+
+  radClient := client.InitialiseClient(dictionary, "127.0.0.1", "secret", 1, 2)
+  radClient.SetPort(protocol.AUTH, authPort)
+  radClient.SetPort(protocol.ACCT, acctPort)
+
+  _, err := radClient.Ping(protocol.AUTH)
+  assert.Equal(t, nil, err, "Status-Server is not answered on AUTH port!")
+
+  _, err = radClient.Ping(protocol.ACCT)
+  assert.Equal(t, nil, err, "Status-Server is not answered on ACCT port!")
+}
+
+func TestRuntimeDropsStatusServerWoMessageAuthenticator(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+  allowedHosts  := map[string]string { "127.0.0.1": "secret" }
+
+  server  := InitialiseServer(dictionary, allowedHosts, "127.0.0.1", 1, 2)
+  runtime := InitialiseRuntime(&server)
+
+  statusServer := protocol.InitialiseRadiusPacket(protocol.StatusServer)
+  request, _   := statusServer.ToBytes()
+  remoteAddr   := &net.UDPAddr { IP: net.ParseIP("127.0.0.1"), Port: 1812 }
+
+  _, err := runtime.HandleRequest(protocol.AUTH, request, remoteAddr)
+  assert.Equal(t, "Status-Server request has no Message-Authenticator", err.Error(), "Unsigned Status-Server is answered!")
+}
+
+func TestRuntimePassesRequestToHandler(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+  allowedHosts  := map[string]string { "127.0.0.1": "secret" }
+
+  server  := InitialiseServer(dictionary, allowedHosts, "127.0.0.1", 1, 2)
+  runtime := InitialiseRuntime(&server)
+  runtime.SetHandler(protocol.AUTH, func(request *Request) (protocol.TypeCode, []protocol.RadiusAttribute, error) {
+    return protocol.AccessReject, []protocol.RadiusAttribute{}, nil
+  })
+
+  authPort  := startTestRuntime(t, runtime, protocol.AUTH)
+  radClient := client.InitialiseClient(dictionary, "127.0.0.1", "secret", 1, 2)
+  radClient.SetPort(protocol.AUTH, authPort)
+
+  userName        := []uint8("testing")
+  userNameAttr, _ := radClient.CreateAttributeByName("User-Name", &userName)
+
+  radPacket := radClient.CreateAuthRadiusPacket()
+  radPacket.SetAttributes([]protocol.RadiusAttribute { userNameAttr })
+
+  reply, err := radClient.SendAndReceivePacket(&radPacket)
+  assert.Equal(t, nil, err, "Reply is not received!")
+
+  ok, _ := radClient.VerifyReply(&radPacket, &reply)
+  assert.Equal(t, true, ok, "Valid reply is not verified!")
+  assert.Equal(t, uint8(3), reply[0], "Reply is not Access-Reject!")
+}
+
+func TestRuntimeDropsEapMessageWoMessageAuthenticator(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+  allowedHosts  := map[string]string { "127.0.0.1": "secret" }
+
+  server  := InitialiseServer(dictionary, allowedHosts, "127.0.0.1", 1, 2)
+  runtime := InitialiseRuntime(&server)
+  runtime.SetHandler(protocol.AUTH, func(request *Request) (protocol.TypeCode, []protocol.RadiusAttribute, error) {
+    return protocol.AccessAccept, []protocol.RadiusAttribute{}, nil
+  })
+
+  eapMessage        := []uint8 { 2, 1, 0, 12, 1, 116, 101, 115, 116, 105, 110, 103 }
+  eapMessageAttr, _ := server.CreateAttributeByName("EAP-Message", &eapMessage)
+
+  radPacket := protocol.InitialiseRadiusPacket(protocol.AccessRequest)
+  radPacket.SetAttributes([]protocol.RadiusAttribute { eapMessageAttr })
+
+  request, _ := radPacket.ToBytes()
+  remoteAddr := &net.UDPAddr { IP: net.ParseIP("127.0.0.1"), Port: 1812 }
+
+  _, err := runtime.HandleRequest(protocol.AUTH, request, remoteAddr)
+  assert.Equal(t, "request with EAP-Message has no Message-Authenticator", err.Error(), "EAP-Message without Message-Authenticator is accepted!")
+}
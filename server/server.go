@@ -5,8 +5,10 @@ import (
   "errors"
 
   "crypto/md5"
+  "crypto/subtle"
 
   "github.com/MikhailMS/go-radius/protocol"
+  "github.com/MikhailMS/go-radius/tools"
 )
 
 type Server struct {
@@ -82,6 +84,55 @@ func (server *Server) CreateReplyPacket(replyCode protocol.TypeCode, attributes
   return replyPacket, nil
 }
 
+// CreateSignedReplyPacket creates RADIUS packet with any TypeCode, that carries Message-Authenticator
+//
+// Message-Authenticator is calculated over request authenticator as per RFC 3579, then reply
+// authenticator is calculated over the packet with Message-Authenticator set
+func (server *Server) CreateSignedReplyPacket(replyCode protocol.TypeCode, attributes []protocol.RadiusAttribute, request *[]uint8, secret string) (protocol.RadiusPacket, error) {
+  if len(*request) < 20 {
+    return protocol.RadiusPacket{}, errors.New("request is shorter than RADIUS header")
+  }
+
+  msgAuthBytes   := make([]uint8, 16)
+  msgAuthAttr, err := server.CreateAttributeByName("Message-Authenticator", &msgAuthBytes)
+  if err != nil {
+    return protocol.RadiusPacket{}, err
+  }
+
+  // Message-Authenticator, that is set by handler, is replaced, so reply has only one
+  replyAttributes := make([]protocol.RadiusAttribute, 0, len(attributes) + 1)
+  for _, attr := range attributes {
+    if attr.ID() != protocol.MESSAGE_AUTHENTICATOR_ID {
+      replyAttributes = append(replyAttributes, attr)
+    }
+  }
+  replyAttributes = append(replyAttributes, msgAuthAttr)
+
+  requestAuth := make([]uint8, 16)
+  copy(requestAuth, (*request)[4:20])
+
+  replyPacket := protocol.InitialiseRadiusPacket(replyCode)
+
+  replyPacket.SetAttributes(replyAttributes)
+  replyPacket.OverrideID((*request)[1])
+  replyPacket.OverrideAuthenticator(requestAuth)
+
+  err = replyPacket.GenerateMessageAuthenticator(secret)
+  if err != nil {
+    return protocol.RadiusPacket{}, err
+  }
+
+  replyBytes, ok := replyPacket.ToBytes()
+  if !ok {
+    return protocol.RadiusPacket{}, errors.New("failed to create reply RadiusPacket")
+  }
+
+  authenticator := createReplyAuthenticator(secret, &replyBytes, &requestAuth)
+
+  replyPacket.OverrideAuthenticator(authenticator)
+  return replyPacket, nil
+}
+
 // CreateAttributeByName creates RADIUS packet attribute by Name, that is defined in dictionary file
 func (server *Server) CreateAttributeByName(attrName string, value *[]uint8) (protocol.RadiusAttribute, error) {
   return server.host.CreateAttributeByName(attrName, value)
@@ -116,6 +167,71 @@ func (server *Server) InitialisePacketFromBytes(request *[]uint8) (protocol.Radi
   return server.host.InitialiseRadiusPacketFromBytes(request)
 }
 
+// VerifyRequestMessageAuthenticator verifies Message-Authenticator of incoming request
+//
+// Message-Authenticator of Accounting-Request, CoA-Request and Disconnect-Request is calculated
+// with Request Authenticator set to zeros, while for other requests it is calculated over random
+// Request Authenticator
+func (server *Server) VerifyRequestMessageAuthenticator(request *[]uint8, secret string) error {
+  if len(*request) < 20 {
+    return errors.New("request is shorter than RADIUS header")
+  }
+
+  switch (*request)[0] {
+    // Accounting-Request, Disconnect-Request & CoA-Request
+    case 4, 40, 43:
+      return server.host.VerifyReplyMessageAuthenticator(secret, request, make([]uint8, 16))
+    default:
+      return server.host.VerifyMessageAuthenticator(secret, request)
+  }
+}
+
+// VerifyRequestAuthenticator verifies Request Authenticator of Accounting-Request, CoA-Request or
+// Disconnect-Request
+func (server *Server) VerifyRequestAuthenticator(request *[]uint8, secret string) error {
+  return server.host.VerifyRequestAuthenticator(secret, request)
+}
+
+// VerifyChapPassword verifies CHAP-Password of Access-Request against cleartext password
+//
+// If request has no CHAP-Challenge attribute, Request Authenticator is used as the challenge, as
+// defined in RFC 2865
+func (server *Server) VerifyChapPassword(request *protocol.RadiusPacket, password []uint8) error {
+  chapPasswordAttr := request.AttributeByName("CHAP-Password")
+  chapPassword     := chapPasswordAttr.Value()
+
+  if len(chapPassword) != 17 {
+    return errors.New("CHAP-Password attribute is missing or malformed")
+  }
+
+  challengeAttr := request.AttributeByName("CHAP-Challenge")
+  challenge     := challengeAttr.Value()
+
+  if len(challenge) == 0 {
+    challenge = request.Authenticator()
+  }
+
+  expectedResponse := tools.ChapResponse(chapPassword[0], &password, &challenge)
+
+  if subtle.ConstantTimeCompare(expectedResponse, chapPassword[1:]) == 1 {
+    return nil
+  }
+  return errors.New("CHAP-Password mismatch")
+}
+
+// UserPassword returns cleartext value of User-Password attribute of Access-Request, that is
+// received from remoteHost
+//
+// Value of User-Password must be a multiple of 16 octets long and no longer than 128 octets
+// (RFC 2865, section 5.2); request is not modified
+func (server *Server) UserPassword(request *protocol.RadiusPacket, remoteHost string) ([]uint8, error) {
+  if !server.IsHostAllowed(remoteHost) {
+    return nil, errors.New("host " + remoteHost + " is not allowed")
+  }
+
+  return decryptUserPassword(request, server.Secret(remoteHost))
+}
+
 // IsHostAllowed checks if host from where Server received RADIUS request is allowed host,
 // meaning RADIUS Server can process such request
 func (server *Server) IsHostAllowed(remoteHost string) bool {
@@ -133,3 +249,24 @@ func createReplyAuthenticator(secret string, replyBytes *[]uint8, requestAuth *[
   return md5Hash.Sum(nil)
 }
 
+
+// decryptUserPassword returns cleartext value of User-Password attribute
+func decryptUserPassword(request *protocol.RadiusPacket, secret string) ([]uint8, error) {
+  userPassword := request.AttributeByID(userPasswordID)
+  encrypted    := append([]uint8{}, userPassword.Value()...)
+
+  if len(encrypted) == 0 {
+    return nil, errors.New("User-Password attribute is missing")
+  }
+  if len(encrypted) % 16 != 0 || len(encrypted) > 128 {
+    return nil, errors.New("User-Password attribute is malformed")
+  }
+
+  authenticator := request.Authenticator()
+  if len(authenticator) != 16 {
+    return nil, errors.New("request authenticator is malformed")
+  }
+  secretBytes := []uint8(secret)
+
+  return tools.DecryptData(&encrypted, &authenticator, &secretBytes), nil
+}
@@ -5,7 +5,9 @@ import (
 
   "github.com/stretchr/testify/assert"
 
+  "github.com/MikhailMS/go-radius/client"
   "github.com/MikhailMS/go-radius/protocol"
+  "github.com/MikhailMS/go-radius/tools"
 )
 
 func TestCreateReplyPacket(t *testing.T) {
@@ -23,9 +25,113 @@ func TestCreateReplyPacket(t *testing.T) {
   userNameAttr, _ := server.CreateAttributeByName("User-Name", &userName)
   attributes      := []protocol.RadiusAttribute { userNameAttr }
 
-  request := []uint8 { 4, 43, 0, 83, 215, 189, 213, 172, 57, 94, 141, 70, 134, 121, 101, 57, 187, 220, 227, 73, 4, 6, 192, 168, 1, 10, 5, 6, 0, 0, 0, 0, 32, 10, 116, 114, 105, 108, 108, 105, 97, 110, 30, 19, 48, 48, 45, 48, 52, 45, 53, 70, 45, 48, 48, 45, 48, 70, 45, 68, 49, 31, 19, 48, 48, 45, 48, 49, 45, 50, 52, 45, 56, 48, 45, 66, 51, 45, 57, 67, 8, 6, 10, 0, 0, 100 }
+  request := []uint8 { 4, 43, 0, 86, 215, 189, 213, 172, 57, 94, 141, 70, 134, 121, 101, 57, 187, 220, 227, 73, 4, 6, 192, 168, 1, 10, 5, 6, 0, 0, 0, 0, 32, 10, 116, 114, 105, 108, 108, 105, 97, 110, 30, 19, 48, 48, 45, 48, 52, 45, 53, 70, 45, 48, 48, 45, 48, 70, 45, 68, 49, 31, 19, 48, 48, 45, 48, 49, 45, 50, 52, 45, 56, 48, 45, 66, 51, 45, 57, 67, 8, 6, 10, 0, 0, 100 }
 
   replyPacket, _      := server.CreateReplyPacket(protocol.AccountingResponse, attributes, &request, server.Secret("123.123.123.123"))
   replyPacketBytes, _ := replyPacket.ToBytes()
   assert.Equal(t, expectedReplyBytes, replyPacketBytes, "Reply bytes do not match!")
 }
+
+func TestCreateSignedReplyPacketReplacesMessageAuthenticator(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+  server        := InitialiseServer(dictionary, map[string]string { "127.0.0.1": "secret" }, "127.0.0.1", 1, 2)
+
+  msgAuthBytes   := make([]uint8, 16)
+  msgAuthAttr, _ := server.CreateAttributeByName("Message-Authenticator", &msgAuthBytes)
+  request        := append([]uint8 { 1, 43, 0, 20 }, make([]uint8, 16)...)
+
+  replyPacket, err := server.CreateSignedReplyPacket(protocol.AccessAccept, []protocol.RadiusAttribute { msgAuthAttr }, &request, "secret")
+  assert.Equal(t, nil, err, "Signed reply is not created!")
+
+  count := 0
+  for _, attr := range replyPacket.Attributes() {
+    if attr.ID() == protocol.MESSAGE_AUTHENTICATOR_ID {
+      count++
+    }
+  }
+  assert.Equal(t, 1, count, "Message-Authenticator of handler is not replaced!")
+}
+
+func TestVerifyChapPassword(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+  allowedHosts  := map[string]string { "127.0.0.1": "secret" }
+
+  server    := InitialiseServer(dictionary, allowedHosts, "127.0.0.1", 1, 2)
+  radClient := client.InitialiseClient(dictionary, "127.0.0.1", "secret", 1, 2)
+
+  chapAttributes, _ := radClient.CreateChapAttributes([]uint8("password"))
+
+  radPacket := radClient.CreateAuthRadiusPacket()
+  radPacket.SetAttributes(chapAttributes)
+
+  assert.Equal(t, nil, server.VerifyChapPassword(&radPacket, []uint8("password")), "Valid CHAP-Password is not verified!")
+  assert.Equal(t, "CHAP-Password mismatch", server.VerifyChapPassword(&radPacket, []uint8("wrong")).Error(), "Invalid CHAP-Password is verified!")
+}
+
+func TestVerifyChapPasswordWoChallenge(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+  allowedHosts  := map[string]string { "127.0.0.1": "secret" }
+
+  server := InitialiseServer(dictionary, allowedHosts, "127.0.0.1", 1, 2)
+
+  password      := []uint8("password")
+  authenticator := []uint8 { 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15 }
+  chapPassword  := append([]uint8{ 7 }, tools.ChapResponse(7, &password, &authenticator)...)
+
+  chapPasswordAttr, _ := server.CreateAttributeByName("CHAP-Password", &chapPassword)
+
+  radPacket := protocol.InitialiseRadiusPacket(protocol.AccessRequest)
+  radPacket.SetAttributes([]protocol.RadiusAttribute { chapPasswordAttr })
+  radPacket.OverrideAuthenticator(authenticator)
+
+  assert.Equal(t, nil, server.VerifyChapPassword(&radPacket, password), "CHAP-Password with Request Authenticator as challenge is not verified!")
+}
+
+func TestUserPassword(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+  allowedHosts  := map[string]string { "127.0.0.1": "secret" }
+
+  server := InitialiseServer(dictionary, allowedHosts, "127.0.0.1", 1, 2)
+
+  authenticator := []uint8 { 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15 }
+  secret        := []uint8("secret")
+
+  for _, testCase := range []struct {
+    value         []uint8
+    remoteHost    string
+    expected      []uint8
+    expectedError string
+  } {
+    { tools.EncryptData(&[]uint8{ 'p', 'a', 's', 's' }, &authenticator, &secret), "127.0.0.1", []uint8("pass"), "" },
+    { tools.EncryptData(&[]uint8{ 'p', 'a', 's', 's' }, &authenticator, &secret), "10.0.0.1",  nil,             "host 10.0.0.1 is not allowed" },
+    { nil,                                                                       "127.0.0.1", nil,             "User-Password attribute is missing" },
+    { make([]uint8, 17),                                                         "127.0.0.1", nil,             "User-Password attribute is malformed" },
+    { make([]uint8, 144),                                                        "127.0.0.1", nil,             "User-Password attribute is malformed" },
+    // Value, that decrypts into zeros only, is an empty password
+    { tools.EncryptData(&[]uint8{}, &authenticator, &secret),                      "127.0.0.1", []uint8{},       "" },
+  } {
+    radPacket := protocol.InitialiseRadiusPacket(protocol.AccessRequest)
+    radPacket.OverrideAuthenticator(authenticator)
+
+    if testCase.value != nil {
+      passwordAttr, _ := server.CreateAttributeByID(userPasswordID, &testCase.value)
+      radPacket.SetAttributes([]protocol.RadiusAttribute { passwordAttr })
+    }
+    original := append([]uint8{}, testCase.value...)
+
+    password, err := server.UserPassword(&radPacket, testCase.remoteHost)
+    if testCase.expectedError != "" {
+      assert.Equal(t, testCase.expectedError, err.Error(), "User-Password error is not correct!")
+      continue
+    }
+
+    passwordAttr := radPacket.AttributeByID(userPasswordID)
+    assert.Equal(t, nil,               err,                  "User-Password is not decrypted!")
+    assert.Equal(t, testCase.expected, password,             "User-Password is not correct!")
+    assert.Equal(t, original,          passwordAttr.Value(), "Request is modified!")
+  }
+}
@@ -0,0 +1,113 @@
+// Serving of stream transports (RADIUS over TLS & TCP) by Runtime
+package server
+
+import (
+  "errors"
+  "fmt"
+  "io"
+  "log"
+  "net"
+  "sync"
+  "sync/atomic"
+  "time"
+
+  "github.com/MikhailMS/go-radius/protocol"
+)
+
+// STREAM_IDLE_TIMEOUT is the default time after which stream connection without requests is closed
+const STREAM_IDLE_TIMEOUT = 60 * time.Second
+
+// **Optional**
+//
+// SetIdleTimeout sets time after which stream connection (RadSec or TCP), that carries no requests,
+// is closed; zero disables idle timeout
+func (runtime *Runtime) SetIdleTimeout(timeout time.Duration) {
+  runtime.idleTimeout = timeout
+}
+
+// serveStream reads requests framed by their Length field from stream connection and writes
+// replies back, until connection is closed or becomes idle
+//
+// Requests are handled concurrently, so replies could be sent in different order; connection is
+// idle only once idle timeout passes after its last request is answered. As required by RFC 6613
+// (section 2.6.4), connection is closed once malformed packet is received, because framing of the
+// stream could not be trusted anymore; request, that is well-formed, but is not valid otherwise
+// (e.g. has attribute, that is not in dictionary), is dropped by itself
+func (runtime *Runtime) serveStream(conn net.Conn, msgTypeOf func(request []uint8) protocol.RadiusMsgType, remoteHost, secret string) {
+  var writeMutex sync.Mutex
+  var activity   streamActivity
+
+  for {
+    if runtime.idleTimeout > 0 {
+      conn.SetReadDeadline(time.Now().Add(runtime.idleTimeout))
+    }
+
+    request, err := protocol.ReadStreamPacket(conn)
+    if err == nil {
+      err = protocol.VerifyPacketStructure(request)
+    }
+    if err != nil {
+      var netErr net.Error
+      isIdle := errors.As(err, &netErr) && netErr.Timeout()
+
+      if isIdle && !activity.isIdle(runtime.idleTimeout) {
+        continue
+      }
+      if !isIdle && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+        log.Println(fmt.Sprintf("WARNING: closed connection from %s: %s", conn.RemoteAddr().String(), err))
+      }
+      return
+    }
+
+    activity.start()
+    go func() {
+      defer activity.done()
+
+      reply, err := runtime.handle(msgTypeOf(request), request, conn.RemoteAddr(), remoteHost, secret)
+      if err != nil {
+        log.Println(fmt.Sprintf("WARNING: dropped request from %s: %s", conn.RemoteAddr().String(), err))
+        return
+      }
+
+      writeMutex.Lock()
+      defer writeMutex.Unlock()
+      conn.Write(reply)
+    }()
+  }
+}
+
+// streamActivity tracks requests of connection, that are being handled, so connection is not idle
+// until idle timeout passes after the last of them is answered
+type streamActivity struct {
+  inFlight  atomic.Int64
+  lastReply atomic.Int64
+}
+
+// start registers request, that is being handled
+func (activity *streamActivity) start() {
+  activity.inFlight.Add(1)
+}
+
+// done registers request, that is answered or dropped
+func (activity *streamActivity) done() {
+  activity.lastReply.Store(time.Now().UnixNano())
+  activity.inFlight.Add(-1)
+}
+
+// isIdle reports if no request is being handled and none was answered within timeout
+func (activity *streamActivity) isIdle(timeout time.Duration) bool {
+  return activity.inFlight.Load() == 0 && time.Since(time.Unix(0, activity.lastReply.Load())) >= timeout
+}
+
+// streamMsgType returns RADIUS Message Type of request received over RadSec connection or DTLS
+// session, which carry requests of all types
+func streamMsgType(request []uint8) protocol.RadiusMsgType {
+  switch request[0] {
+    case 4:      // Accounting-Request
+      return protocol.ACCT
+    case 40, 43: // Disconnect-Request, CoA-Request
+      return protocol.COA
+    default:
+      return protocol.AUTH
+  }
+}
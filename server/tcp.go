@@ -0,0 +1,98 @@
+// RADIUS over TCP (RFC 6613) transport of Runtime
+package server
+
+import (
+  "errors"
+  "fmt"
+  "log"
+  "net"
+  "strconv"
+  "sync"
+
+  "github.com/MikhailMS/go-radius/protocol"
+)
+
+// ListenAndServeTCP starts TCP listeners on Server address for each RADIUS Message Type, that has
+// Handler set (and for AUTH & ACCT, so Status-Server is always answered), and blocks until
+// listeners are closed
+//
+// As defined in RFC 6613, TCP listeners use the same ports as UDP ones
+func (runtime *Runtime) ListenAndServeTCP() error {
+  var wg sync.WaitGroup
+
+  msgTypes := []protocol.RadiusMsgType { protocol.AUTH, protocol.ACCT, protocol.COA }
+  errs     := make(chan error, len(msgTypes))
+
+  for _, msgType := range msgTypes {
+    _, hasHandler := runtime.handlers[msgType]
+    if !hasHandler && msgType == protocol.COA {
+      continue
+    }
+
+    port, ok := runtime.server.Port(msgTypeToTypeCode(msgType))
+    if !ok || port == 0 {
+      continue
+    }
+
+    listener, err := net.Listen("tcp", net.JoinHostPort(runtime.server.Server(), strconv.Itoa(int(port))))
+    if err != nil {
+      runtime.Close()
+      return err
+    }
+    runtime.trackConn(listener)
+
+    wg.Add(1)
+    go func(listener net.Listener, msgType protocol.RadiusMsgType) {
+      defer wg.Done()
+      errs <- runtime.ServeTCP(listener, msgType)
+    }(listener, msgType)
+  }
+
+  wg.Wait()
+  close(errs)
+
+  for err := range errs {
+    if err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+// ServeTCP accepts TCP connections from given listener and serves requests of given RADIUS Message
+// Type received over them, until listener is closed
+//
+// Connections from hosts, that are not allowed, are closed straight away
+func (runtime *Runtime) ServeTCP(listener net.Listener, msgType protocol.RadiusMsgType) error {
+  runtime.trackConn(listener)
+
+  for {
+    conn, err := listener.Accept()
+    if err != nil {
+      if errors.Is(err, net.ErrClosed) {
+        return nil
+      }
+      return err
+    }
+
+    go runtime.serveTCPConn(conn, msgType)
+  }
+}
+
+// serveTCPConn serves requests of allowed host received over TCP connection
+func (runtime *Runtime) serveTCPConn(conn net.Conn, msgType protocol.RadiusMsgType) {
+  runtime.trackConn(conn)
+  defer runtime.untrackConn(conn)
+  defer conn.Close()
+
+  remoteHost := hostFromAddr(conn.RemoteAddr())
+  if !runtime.server.IsHostAllowed(remoteHost) {
+    log.Println(fmt.Sprintf("WARNING: closed TCP connection from %s: host is not allowed", conn.RemoteAddr().String()))
+    return
+  }
+
+  msgTypeOf := func(request []uint8) protocol.RadiusMsgType {
+    return msgType
+  }
+  runtime.serveStream(conn, msgTypeOf, remoteHost, runtime.server.Secret(remoteHost))
+}
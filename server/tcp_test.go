@@ -0,0 +1,180 @@
+package server
+
+import (
+  "context"
+  "errors"
+  "io"
+  "net"
+  "sync"
+  "testing"
+  "time"
+
+  "github.com/stretchr/testify/assert"
+
+  "github.com/MikhailMS/go-radius/client"
+  "github.com/MikhailMS/go-radius/protocol"
+)
+
+// startTestTCP starts TCP Runtime for AUTH requests, that accepts "testing" user with "password"
+func startTestTCP(t *testing.T, dictionary protocol.Dictionary, allowedHosts map[string]string, idleTimeout time.Duration) uint16 {
+  server  := InitialiseServer(dictionary, allowedHosts, "127.0.0.1", 1, 2)
+  runtime := InitialiseRuntime(&server)
+  runtime.SetIdleTimeout(idleTimeout)
+
+  runtime.SetHandler(protocol.AUTH, func(request *Request) (protocol.TypeCode, []protocol.RadiusAttribute, error) {
+    password, err := server.UserPassword(request.Packet(), request.RemoteHost())
+    if err != nil || string(password) != "password" {
+      return protocol.AccessReject, nil, nil
+    }
+    return protocol.AccessAccept, nil, nil
+  })
+
+  listener, err := net.Listen("tcp", "127.0.0.1:0")
+  if err != nil {
+    t.Fatal(err)
+  }
+  go runtime.ServeTCP(listener, protocol.AUTH)
+  t.Cleanup(func() { runtime.Close() })
+
+  return uint16(listener.Addr().(*net.TCPAddr).Port)
+}
+
+// waitTestConnClosed reports if connection is closed by the other side within a second
+func waitTestConnClosed(conn net.Conn) bool {
+  conn.SetReadDeadline(time.Now().Add(time.Second))
+  _, err := conn.Read(make([]uint8, 4096))
+  return err == io.EOF
+}
+
+func TestTCP(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+
+  port      := startTestTCP(t, dictionary, map[string]string { "127.0.0.1": "secret" }, STREAM_IDLE_TIMEOUT)
+  radClient := client.InitialiseTCPClient(dictionary, "127.0.0.1", "secret", 2)
+  radClient.SetPort(protocol.AUTH, port)
+  defer radClient.Close()
+
+  _, err := radClient.Ping(protocol.AUTH)
+  assert.Equal(t, nil, err, "Status-Server is not answered over TCP!")
+
+  // Requests are multiplexed over the same connection
+  var wg sync.WaitGroup
+  for _, password := range []string { "password", "wrong", "password", "wrong" } {
+    wg.Add(1)
+    go func(password string) {
+      defer wg.Done()
+
+      result, err := radClient.AuthenticatePAP(context.Background(), "testing", []uint8(password), nil)
+      assert.Equal(t, nil,                    err,               "Access-Request is not answered over TCP!")
+      assert.Equal(t, password == "password", result.Accepted(), "Access-Request is not handled correctly over TCP!")
+    }(password)
+  }
+  wg.Wait()
+}
+
+func TestTCPClosesConnection(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+
+  for _, testCase := range []struct {
+    name        string
+    idleTimeout time.Duration
+    request     []uint8
+  } {
+    { "malformed length",    time.Minute,            []uint8{ 1, 1, 0, 19 } },
+    { "malformed attribute", time.Minute,            append(append([]uint8{ 1, 1, 0, 22 }, make([]uint8, 16)...), 1, 0) },
+    { "idle connection",     100 * time.Millisecond, nil },
+  } {
+    port    := startTestTCP(t, dictionary, map[string]string { "127.0.0.1": "secret" }, testCase.idleTimeout)
+    address := (&net.TCPAddr { IP: net.ParseIP("127.0.0.1"), Port: int(port) }).String()
+
+    conn, err := net.Dial("tcp", address)
+    if err != nil {
+      t.Fatal(err)
+    }
+    conn.Write(testCase.request)
+
+    assert.Equal(t, true, waitTestConnClosed(conn), "Connection is not closed for " + testCase.name + "!")
+    conn.Close()
+  }
+}
+
+func TestTCPDropsRequestWithUnknownAttribute(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+
+  port      := startTestTCP(t, dictionary, map[string]string { "127.0.0.1": "secret" }, STREAM_IDLE_TIMEOUT)
+  radClient := client.InitialiseTCPClient(dictionary, "127.0.0.1", "secret", 2)
+  radClient.SetPort(protocol.AUTH, port)
+  defer radClient.Close()
+
+  // Attribute 200 is well-formed, but is not in dictionary
+  request := radClient.CreateAuthRadiusPacket()
+  request.SetAttributes(nil)
+  requestBytes, _ := request.ToBytes()
+  requestBytes     = append(requestBytes, 200, 3, 1)
+  requestBytes[3] += 3
+
+  conn, err := net.Dial("tcp", (&net.TCPAddr { IP: net.ParseIP("127.0.0.1"), Port: int(port) }).String())
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer conn.Close()
+  conn.Write(requestBytes)
+
+  conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+  _, err = conn.Read(make([]uint8, 4096))
+
+  var netErr net.Error
+  assert.Equal(t, true, errors.As(err, &netErr) && netErr.Timeout(), "Connection is closed for request with unknown attribute!")
+
+  // Requests over other connection are still answered
+  result, err := radClient.AuthenticatePAP(context.Background(), "testing", []uint8("password"), nil)
+  assert.Equal(t, nil,  err,               "Access-Request is not answered over TCP!")
+  assert.Equal(t, true, result.Accepted(), "Access-Request is not accepted over TCP!")
+}
+
+func TestTCPHostNotAllowed(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+
+  port := startTestTCP(t, dictionary, map[string]string { "10.0.0.1": "secret" }, STREAM_IDLE_TIMEOUT)
+
+  conn, err := net.Dial("tcp", (&net.TCPAddr { IP: net.ParseIP("127.0.0.1"), Port: int(port) }).String())
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer conn.Close()
+
+  assert.Equal(t, true, waitTestConnClosed(conn), "Connection from not allowed host is not closed!")
+}
+
+func TestTCPIdleTimeoutWaitsForHandler(t *testing.T) {
+  dictPath      := "../dict_examples/integration_dict"
+  dictionary, _ := protocol.DictionaryFromFile(dictPath)
+
+  // Request is handled for longer than idle timeout of the connection
+  server  := InitialiseServer(dictionary, map[string]string { "127.0.0.1": "secret" }, "127.0.0.1", 1, 2)
+  runtime := InitialiseRuntime(&server)
+  runtime.SetIdleTimeout(100 * time.Millisecond)
+  runtime.SetHandler(protocol.AUTH, func(request *Request) (protocol.TypeCode, []protocol.RadiusAttribute, error) {
+    time.Sleep(300 * time.Millisecond)
+    return protocol.AccessAccept, nil, nil
+  })
+
+  listener, err := net.Listen("tcp", "127.0.0.1:0")
+  if err != nil {
+    t.Fatal(err)
+  }
+  go runtime.ServeTCP(listener, protocol.AUTH)
+  t.Cleanup(func() { runtime.Close() })
+
+  radClient := client.InitialiseTCPClient(dictionary, "127.0.0.1", "secret", 2)
+  radClient.SetPort(protocol.AUTH, uint16(listener.Addr().(*net.TCPAddr).Port))
+  defer radClient.Close()
+
+  result, err := radClient.AuthenticatePAP(context.Background(), "testing", []uint8("password"), nil)
+  assert.Equal(t, nil,  err,               "Reply is lost, when connection becomes idle while request is handled!")
+  assert.Equal(t, true, result.Accepted(), "Reply is lost, when connection becomes idle while request is handled!")
+}
@@ -0,0 +1,244 @@
+// Helper functions to calculate MS-CHAPv1 (RFC 2433) & MS-CHAPv2 (RFC 2759) responses and to derive
+// MPPE keys (RFC 3079), which are sent back in Microsoft Vendor-Specific attributes (RFC 2548)
+package tools
+
+import (
+  "crypto/des"
+  "crypto/rand"
+  "crypto/sha1"
+  "encoding/binary"
+  "encoding/hex"
+  "errors"
+  "strings"
+  "unicode/utf16"
+
+  "golang.org/x/crypto/md4"
+)
+
+// Microsoft Vendor-Id and vendor types of Microsoft Vendor-Specific attributes, as defined in
+// RFC 2548
+const (
+  MICROSOFT_VENDOR_ID = 311
+
+  MS_CHAP_RESPONSE          = 1
+  MS_CHAP_ERROR             = 2
+  MS_MPPE_ENCRYPTION_POLICY = 7
+  MS_MPPE_ENCRYPTION_TYPES  = 8
+  MS_CHAP_CHALLENGE         = 11
+  MS_CHAP_MPPE_KEYS         = 12
+  MS_MPPE_SEND_KEY          = 16
+  MS_MPPE_RECV_KEY          = 17
+  MS_CHAP2_RESPONSE         = 25
+  MS_CHAP2_SUCCESS          = 26
+)
+
+var (
+  // Magic constants used to derive MPPE keys, as defined in RFC 3079
+  mppeMagic1 = []uint8("This is the MPPE Master Key")
+  mppeMagic2 = []uint8("On the client side, this is the send key; on the server side, it is the receive key.")
+  mppeMagic3 = []uint8("On the client side, this is the receive key; on the server side, it is the send key.")
+
+  mppeSHSpad1 = make([]uint8, 40)
+  mppeSHSpad2 = []uint8 {
+    0xf2, 0xf2, 0xf2, 0xf2, 0xf2, 0xf2, 0xf2, 0xf2, 0xf2, 0xf2,
+    0xf2, 0xf2, 0xf2, 0xf2, 0xf2, 0xf2, 0xf2, 0xf2, 0xf2, 0xf2,
+    0xf2, 0xf2, 0xf2, 0xf2, 0xf2, 0xf2, 0xf2, 0xf2, 0xf2, 0xf2,
+    0xf2, 0xf2, 0xf2, 0xf2, 0xf2, 0xf2, 0xf2, 0xf2, 0xf2, 0xf2,
+  }
+
+  // Magic constants used to generate MS-CHAPv2 authenticator response, as defined in RFC 2759
+  authResponseMagic1 = []uint8("Magic server to client signing constant")
+  authResponseMagic2 = []uint8("Pad to make it do more than one iteration")
+)
+
+// NTPasswordHash calculates MD4 hash of UTF-16LE encoded password
+func NTPasswordHash(password *[]uint8) []uint8 {
+  md4Hash := md4.New()
+
+  md4Hash.Write(utf16LittleEndian(string(*password)))
+  return md4Hash.Sum(nil)
+}
+
+// HashNTPasswordHash calculates MD4 hash of NT password hash
+func HashNTPasswordHash(passwordHash *[]uint8) []uint8 {
+  md4Hash := md4.New()
+
+  md4Hash.Write(*passwordHash)
+  return md4Hash.Sum(nil)
+}
+
+// MSChapV1Response calculates 24 octets long MS-CHAPv1 NT-Response for 8 octets long challenge
+func MSChapV1Response(challenge, password *[]uint8) []uint8 {
+  passwordHash := NTPasswordHash(password)
+  return challengeResponse(*challenge, passwordHash)
+}
+
+// MSChapV2ChallengeHash calculates 8 octets long challenge, that is used by MS-CHAPv2 to generate
+// NT-Response
+//
+// Domain part of username (if any) is ignored, as required by RFC 2759
+func MSChapV2ChallengeHash(peerChallenge, authChallenge, username *[]uint8) []uint8 {
+  sha1Hash := sha1.New()
+
+  sha1Hash.Write(*peerChallenge)
+  sha1Hash.Write(*authChallenge)
+  sha1Hash.Write(stripDomain(*username))
+
+  return sha1Hash.Sum(nil)[:8]
+}
+
+// MSChapV2NTResponse calculates 24 octets long MS-CHAPv2 NT-Response
+func MSChapV2NTResponse(authChallenge, peerChallenge, username, password *[]uint8) []uint8 {
+  challenge    := MSChapV2ChallengeHash(peerChallenge, authChallenge, username)
+  passwordHash := NTPasswordHash(password)
+
+  return challengeResponse(challenge, passwordHash)
+}
+
+// MSChapV2AuthenticatorResponse calculates MS-CHAPv2 authenticator response, that server sends back
+// to prove it knows the password too
+//
+// Result is 42 characters long string in "S=<hex>" format
+func MSChapV2AuthenticatorResponse(password, ntResponse, peerChallenge, authChallenge, username *[]uint8) string {
+  passwordHash     := NTPasswordHash(password)
+  passwordHashHash := HashNTPasswordHash(&passwordHash)
+
+  sha1Hash := sha1.New()
+
+  sha1Hash.Write(passwordHashHash)
+  sha1Hash.Write(*ntResponse)
+  sha1Hash.Write(authResponseMagic1)
+  digest := sha1Hash.Sum(nil)
+
+  challenge := MSChapV2ChallengeHash(peerChallenge, authChallenge, username)
+
+  sha1Hash.Reset()
+  sha1Hash.Write(digest)
+  sha1Hash.Write(challenge)
+  sha1Hash.Write(authResponseMagic2)
+
+  return "S=" + strings.ToUpper(hex.EncodeToString(sha1Hash.Sum(nil)))
+}
+
+// MSChapV1MPPEKeys returns 24 octets long value of MS-CHAP-MPPE-Keys attribute before encryption
+//
+// LM-Key is always zeroed as LAN Manager hash is not supported, NT-Key is hash of NT password hash
+func MSChapV1MPPEKeys(password *[]uint8) []uint8 {
+  passwordHash := NTPasswordHash(password)
+
+  output := make([]uint8, 8)
+  output  = append(output, HashNTPasswordHash(&passwordHash)...)
+
+  return output
+}
+
+// MSChapV2MPPEKeys derives 16 octets long MPPE send & receive keys from server's point of view, as
+// defined in RFC 3079
+//
+// Send key should be sent in MS-MPPE-Send-Key attribute, receive key - in MS-MPPE-Recv-Key
+func MSChapV2MPPEKeys(password, ntResponse *[]uint8) ([]uint8, []uint8) {
+  passwordHash     := NTPasswordHash(password)
+  passwordHashHash := HashNTPasswordHash(&passwordHash)
+
+  sha1Hash := sha1.New()
+
+  sha1Hash.Write(passwordHashHash)
+  sha1Hash.Write(*ntResponse)
+  sha1Hash.Write(mppeMagic1)
+  masterKey := sha1Hash.Sum(nil)[:16]
+
+  sendKey := asymmetricStartKey(masterKey, mppeMagic3)
+  recvKey := asymmetricStartKey(masterKey, mppeMagic2)
+
+  return sendKey, recvKey
+}
+
+// EncryptMPPEKey encrypts MPPE key as defined in RFC 2548 for MS-MPPE-Send-Key & MS-MPPE-Recv-Key
+// attributes
+//
+// Encryption is the same as for Tunnel-Password (see *SaltEncryptData*), with salt being random
+// and having its most significant bit set
+func EncryptMPPEKey(key, authenticator, secret *[]uint8) ([]uint8, error) {
+  salt := make([]uint8, 2)
+
+  if _, err := rand.Read(salt); err != nil {
+    return []uint8{}, err
+  }
+  salt[0] |= 0x80
+
+  return SaltEncryptData(key, authenticator, &salt, secret), nil
+}
+
+// DecryptMPPEKey decrypts MPPE key, that was encrypted as defined in RFC 2548
+func DecryptMPPEKey(data, authenticator, secret *[]uint8) ([]uint8, error) {
+  if len(*data) < 2 || (*data)[0] & 0x80 == 0 {
+    return []uint8{}, errors.New("MPPE key salt is malformed")
+  }
+
+  encryptedData := make([]uint8, len(*data))
+  copy(encryptedData, *data)
+
+  return SaltDecryptData(&encryptedData, authenticator, secret)
+}
+
+// challengeResponse encrypts 8 octets long challenge with 3 DES keys derived from password hash
+func challengeResponse(challenge, passwordHash []uint8) []uint8 {
+  zPasswordHash := make([]uint8, 21)
+  copy(zPasswordHash, passwordHash)
+
+  response := make([]uint8, 24)
+  for i := 0; i < 3; i++ {
+    desEncrypt(challenge, zPasswordHash[i * 7:(i + 1) * 7], response[i * 8:(i + 1) * 8])
+  }
+
+  return response
+}
+
+// desEncrypt encrypts 8 octets of clear text with DES key built from 7 octets of key material
+func desEncrypt(clear, keyMaterial, output []uint8) {
+  key := make([]uint8, 8)
+
+  key[0] = keyMaterial[0]
+  key[1] = keyMaterial[0] << 7 | keyMaterial[1] >> 1
+  key[2] = keyMaterial[1] << 6 | keyMaterial[2] >> 2
+  key[3] = keyMaterial[2] << 5 | keyMaterial[3] >> 3
+  key[4] = keyMaterial[3] << 4 | keyMaterial[4] >> 4
+  key[5] = keyMaterial[4] << 3 | keyMaterial[5] >> 5
+  key[6] = keyMaterial[5] << 2 | keyMaterial[6] >> 6
+  key[7] = keyMaterial[6] << 1
+
+  // Parity bits are ignored by DES, so key of correct length is always accepted
+  cipher, _ := des.NewCipher(key)
+  cipher.Encrypt(output, clear)
+}
+
+// asymmetricStartKey derives MPPE key from master key, as defined in RFC 3079
+func asymmetricStartKey(masterKey, magic []uint8) []uint8 {
+  sha1Hash := sha1.New()
+
+  sha1Hash.Write(masterKey)
+  sha1Hash.Write(mppeSHSpad1)
+  sha1Hash.Write(magic)
+  sha1Hash.Write(mppeSHSpad2)
+
+  return sha1Hash.Sum(nil)[:16]
+}
+
+// utf16LittleEndian encodes string in UTF-16LE
+func utf16LittleEndian(value string) []uint8 {
+  encoded := utf16.Encode([]rune(value))
+  output  := make([]uint8, len(encoded) * 2)
+
+  for i, char := range encoded {
+    binary.LittleEndian.PutUint16(output[i * 2:], char)
+  }
+  return output
+}
+
+// stripDomain removes "DOMAIN\" prefix from username
+func stripDomain(username []uint8) []uint8 {
+  if index := strings.LastIndexByte(string(username), '\\'); index >= 0 {
+    return username[index + 1:]
+  }
+  return username
+}
@@ -0,0 +1,67 @@
+package tools
+
+import (
+  "testing"
+
+  "github.com/stretchr/testify/assert"
+)
+
+// Test vectors are taken from RFC 2759 (section 9.2) & RFC 3079 (section 3.5.3)
+var (
+  rfcUserName      = []uint8("User")
+  rfcPassword      = []uint8("clientPass")
+  rfcAuthChallenge = []uint8{ 0x5B, 0x5D, 0x7C, 0x7D, 0x7B, 0x3F, 0x2F, 0x3E, 0x3C, 0x2C, 0x60, 0x21, 0x32, 0x26, 0x26, 0x28 }
+  rfcPeerChallenge = []uint8{ 0x21, 0x40, 0x23, 0x24, 0x25, 0x5E, 0x26, 0x2A, 0x28, 0x29, 0x5F, 0x2B, 0x3A, 0x33, 0x7C, 0x7E }
+  rfcNTResponse    = []uint8{ 0x82, 0x30, 0x9E, 0xCD, 0x8D, 0x70, 0x8B, 0x5E, 0xA0, 0x8F, 0xAA, 0x39, 0x81, 0xCD, 0x83, 0x54, 0x42, 0x33, 0x11, 0x4A, 0x3D, 0x85, 0xD6, 0xDF }
+)
+
+func TestNTPasswordHash(t *testing.T) {
+  expectedHash := []uint8{ 0x44, 0xEB, 0xBA, 0x8D, 0x53, 0x12, 0xB8, 0xD6, 0x11, 0x47, 0x44, 0x11, 0xF5, 0x69, 0x89, 0xAE }
+
+  assert.Equal(t, expectedHash, NTPasswordHash(&rfcPassword), "NT password hash is not correct!")
+}
+
+func TestMSChapV2ChallengeHash(t *testing.T) {
+  expectedChallenge := []uint8{ 0xD0, 0x2E, 0x43, 0x86, 0xBC, 0xE9, 0x12, 0x26 }
+
+  assert.Equal(t, expectedChallenge, MSChapV2ChallengeHash(&rfcPeerChallenge, &rfcAuthChallenge, &rfcUserName), "MS-CHAPv2 challenge hash is not correct!")
+}
+
+func TestMSChapV2NTResponse(t *testing.T) {
+  assert.Equal(t, rfcNTResponse, MSChapV2NTResponse(&rfcAuthChallenge, &rfcPeerChallenge, &rfcUserName, &rfcPassword), "MS-CHAPv2 NT-Response is not correct!")
+}
+
+func TestMSChapV2NTResponseWithDomain(t *testing.T) {
+  userName := []uint8("DOMAIN\\User")
+
+  assert.Equal(t, rfcNTResponse, MSChapV2NTResponse(&rfcAuthChallenge, &rfcPeerChallenge, &userName, &rfcPassword), "MS-CHAPv2 NT-Response is not correct!")
+}
+
+func TestMSChapV2AuthenticatorResponse(t *testing.T) {
+  expectedResponse := "S=407A5589115FD0D6209F510FE9C04566932CDA56"
+
+  assert.Equal(t, expectedResponse, MSChapV2AuthenticatorResponse(&rfcPassword, &rfcNTResponse, &rfcPeerChallenge, &rfcAuthChallenge, &rfcUserName), "MS-CHAPv2 authenticator response is not correct!")
+}
+
+func TestMSChapV2MPPEKeys(t *testing.T) {
+  password   := []uint8("clientPass")
+  ntResponse := []uint8{ 0x82, 0x30, 0x9E, 0xCD, 0x8D, 0x70, 0x8B, 0x5E, 0xA0, 0x8F, 0xAA, 0x39, 0x81, 0xCD, 0x83, 0x54, 0x42, 0x33, 0x11, 0x4A, 0x3D, 0x85, 0xD6, 0xDF }
+
+  expectedSendKey := []uint8{ 0x8B, 0x7C, 0xDC, 0x14, 0x9B, 0x99, 0x3A, 0x1B, 0xA1, 0x18, 0xCB, 0x15, 0x3F, 0x56, 0xDC, 0xCB }
+
+  sendKey, _ := MSChapV2MPPEKeys(&password, &ntResponse)
+  assert.Equal(t, expectedSendKey, sendKey, "MPPE send key is not correct!")
+}
+
+func TestEncryptMPPEKey(t *testing.T) {
+  key           := []uint8{ 0x8B, 0x7C, 0xDC, 0x14, 0x9B, 0x99, 0x3A, 0x1B, 0xA1, 0x18, 0xCB, 0x15, 0x3F, 0x56, 0xDC, 0xCB }
+  secret        := []uint8("secret")
+  authenticator := []uint8{ 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15 }
+
+  encryptedKey, _ := EncryptMPPEKey(&key, &authenticator, &secret)
+  assert.Equal(t, 34, len(encryptedKey), "Encrypted MPPE key has invalid length!")
+  assert.Equal(t, uint8(0x80), encryptedKey[0] & 0x80, "Salt most significant bit is not set!")
+
+  decryptedKey, _ := DecryptMPPEKey(&encryptedKey, &authenticator, &secret)
+  assert.Equal(t, key, decryptedKey, "MPPE key is not restored!")
+}
@@ -0,0 +1,64 @@
+// One-time password computations used as second authentication factor: HOTP (RFC 4226) & TOTP
+// (RFC 6238), both based on HMAC-SHA1
+package tools
+
+import (
+  "crypto/hmac"
+  "crypto/sha1"
+  "encoding/binary"
+  "errors"
+  "fmt"
+  "time"
+)
+
+// Number of decimal digits of one-time password: RFC 4226 requires at least 6, while truncated
+// 31 bit code has no more than 9 significant ones
+const (
+  OTP_MIN_DIGITS = 6
+  OTP_MAX_DIGITS = 9
+)
+
+// HOTP calculates HMAC-based one-time password (RFC 4226) for given counter, which is truncated to
+// given number of decimal digits (6 to 9)
+func HOTP(key *[]uint8, counter uint64, digits int) (string, error) {
+  if digits < OTP_MIN_DIGITS || digits > OTP_MAX_DIGITS {
+    return "", errors.New(fmt.Sprintf("one-time password should have %d to %d digits, got %d", OTP_MIN_DIGITS, OTP_MAX_DIGITS, digits))
+  }
+
+  counterBytes := make([]uint8, 8)
+  binary.BigEndian.PutUint64(counterBytes, counter)
+
+  mac := hmac.New(sha1.New, *key)
+  mac.Write(counterBytes)
+  hash := mac.Sum(nil)
+
+  // Dynamic truncation (RFC 4226, section 5.3)
+  offset := hash[len(hash) - 1] & 0x0F
+  code   := binary.BigEndian.Uint32(hash[offset:offset + 4]) & 0x7FFFFFFF
+
+  modulo := uint32(1)
+  for i := 0; i < digits; i++ {
+    modulo *= 10
+  }
+
+  return fmt.Sprintf("%0*d", digits, code % modulo), nil
+}
+
+// TOTPStep returns number of time steps of given period between Unix epoch and timestamp
+// (RFC 6238, section 4); period should be at least one second
+func TOTPStep(timestamp time.Time, period time.Duration) (uint64, error) {
+  if period < time.Second {
+    return 0, errors.New("TOTP period should be at least one second")
+  }
+  return uint64(timestamp.Unix()) / uint64(period / time.Second), nil
+}
+
+// TOTP calculates time-based one-time password (RFC 6238) for timestamp, which is HOTP of time
+// step of given period
+func TOTP(key *[]uint8, timestamp time.Time, period time.Duration, digits int) (string, error) {
+  step, err := TOTPStep(timestamp, period)
+  if err != nil {
+    return "", err
+  }
+  return HOTP(key, step, digits)
+}
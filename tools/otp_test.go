@@ -0,0 +1,47 @@
+package tools
+
+import (
+  "testing"
+  "time"
+
+  "github.com/stretchr/testify/assert"
+)
+
+// Test vectors are taken from RFC 4226 (Appendix D) & RFC 6238 (Appendix B)
+var rfcOTPKey = []uint8("12345678901234567890")
+
+func TestHOTP(t *testing.T) {
+  expected := []string{ "755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489" }
+
+  for counter, code := range expected {
+    actual, err := HOTP(&rfcOTPKey, uint64(counter), 6)
+    assert.Equal(t, nil,  err,    "HOTP is not calculated!")
+    assert.Equal(t, code, actual, "HOTP is not correct!")
+  }
+
+  for _, digits := range []int{ 5, 10 } {
+    _, err := HOTP(&rfcOTPKey, 0, digits)
+    assert.NotEqual(t, nil, err, "HOTP is calculated with unsupported number of digits!")
+  }
+}
+
+func TestTOTP(t *testing.T) {
+  for _, testCase := range []struct {
+    timestamp int64
+    expected  string
+  } {
+    { 59,          "94287082" },
+    { 1111111109,  "07081804" },
+    { 1111111111,  "14050471" },
+    { 1234567890,  "89005924" },
+    { 2000000000,  "69279037" },
+    { 20000000000, "65353130" },
+  } {
+    actual, err := TOTP(&rfcOTPKey, time.Unix(testCase.timestamp, 0), 30 * time.Second, 8)
+    assert.Equal(t, nil,               err,    "TOTP is not calculated!")
+    assert.Equal(t, testCase.expected, actual, "TOTP is not correct!")
+  }
+
+  _, err := TOTPStep(time.Unix(59, 0), 500 * time.Millisecond)
+  assert.Equal(t, "TOTP period should be at least one second", err.Error(), "Sub-second TOTP period is accepted!")
+}
@@ -161,6 +161,41 @@ func BytesToTimestamp(timestamp []uint8) (uint32, bool) {
 	return _tmp, true
 }
 
+// VendorSpecificToBytes converts Vendor-Specific sub-attribute into bytes, that could be used as
+// value of Vendor-Specific attribute (RFC 2865)
+func VendorSpecificToBytes(vendorID uint32, vendorType uint8, value *[]uint8) []uint8 {
+  output := make([]uint8, 4)
+
+  binary.BigEndian.PutUint32(output, vendorID)
+  output = append(output, vendorType, uint8(2 + len(*value)))
+  output = append(output, (*value)...)
+
+  return output
+}
+
+// BytesToVendorSpecific returns value of Vendor-Specific sub-attribute with given vendor type
+// from value of Vendor-Specific attribute (RFC 2865)
+func BytesToVendorSpecific(vsa []uint8, vendorID uint32, vendorType uint8) ([]uint8, bool) {
+  if len(vsa) < 4 || binary.BigEndian.Uint32(vsa[0:4]) != vendorID {
+    return []uint8{}, false
+  }
+
+  lastIndex := 4
+  for lastIndex + 2 <= len(vsa) {
+    subLength := int(vsa[lastIndex + 1])
+    if subLength < 2 || lastIndex + subLength > len(vsa) {
+      return []uint8{}, false
+    }
+
+    if vsa[lastIndex] == vendorType {
+      return vsa[lastIndex + 2:lastIndex + subLength], true
+    }
+    lastIndex += subLength
+  }
+
+  return []uint8{}, false
+}
+
 
 // EncryptData encrypts data since RADIUS packet is sent in plain text
 //
@@ -206,16 +241,18 @@ func DecryptData(data, authenticator, secret *[]uint8) []uint8 {
   *   3. execute bitwise XOR between each of 16 elements of MD5 hash and data buffer and record it in results vector
   *
   *  Once final result is generated, we need to pop all 0's from the end of the result slice
-  *  It is safe to assume that data is always padded so it could be processed in the chunks of size 16
+  *  Data is expected to be padded so it could be processed in the chunks of size 16, incomplete
+  *  chunk at the end is ignored; data itself is not modified
   */
   var result []uint8
 
   prevResult := make([]uint8, 16)
   hash       := make([]uint8, 16)
+  remaining  := *data
 
   copy(prevResult[:], (*authenticator)[:])
 
-  for {
+  for len(remaining) >= 16 {
     md5Hash := md5.New()
 
     md5Hash.Write(*secret)
@@ -224,21 +261,17 @@ func DecryptData(data, authenticator, secret *[]uint8) []uint8 {
     copy(hash, md5Hash.Sum(nil))
     
     for i := 0; i < len(hash); i++ {
-        hash[i] ^= (*data)[i]
+        hash[i] ^= remaining[i]
     }
 
     result = append(result, hash...)
 
-    copy(prevResult, (*data)[:16])
-    *data = (*data)[16:]
-
-    if len(*data) == 0 { break }
+    copy(prevResult, remaining[:16])
+    remaining = remaining[16:]
   }
 
-  for {
-    if result[len(result) - 1] == 0 {
-      result = result[:len(result) - 1]
-    } else { break }
+  for len(result) > 0 && result[len(result) - 1] == 0 {
+    result = result[:len(result) - 1]
   }
   
   return result
@@ -335,6 +368,21 @@ func SaltDecryptData(data, authenticator, secret *[]uint8) ([]uint8, error) {
   return result[:targetLen], nil
 }
 
+// ChapResponse calculates CHAP response as per RFC 1994: MD5 hash of CHAP identifier, password and
+// challenge
+//
+// Should be used to build value of **CHAP-Password** attribute, which is CHAP identifier followed by
+// the response
+func ChapResponse(chapID uint8, password, challenge *[]uint8) []uint8 {
+  md5Hash := md5.New()
+
+  md5Hash.Write([]uint8{ chapID })
+  md5Hash.Write(*password)
+  md5Hash.Write(*challenge)
+
+  return md5Hash.Sum(nil)
+}
+
 
 func encryptHelper(output, data, authenticator, hash, secret *[]uint8) {
   tmp       := make([]uint8, 16)
@@ -243,3 +243,39 @@ func TestSaltDecryptDataLongData(t *testing.T) {
   decryptedData, _ := SaltDecryptData(&encryptedData, &authenticator, &secret)
   assert.Equal(t, plaintext, decryptedData, "SaltDecryptData data is not correct!")
 }
+
+func TestChapResponse(t *testing.T) {
+  expectedResponse := []uint8{ 35, 237, 232, 50, 49, 192, 188, 123, 127, 0, 195, 15, 197, 120, 202, 220 }
+
+  password  := []uint8("password")
+  challenge := []uint8{ 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15 }
+
+  assert.Equal(t, expectedResponse, ChapResponse(7, &password, &challenge), "CHAP response is not correct!")
+}
+
+func TestVendorSpecificBytes(t *testing.T) {
+  expectedBytes := []uint8{ 0, 0, 1, 55, 11, 5, 1, 2, 3 }
+
+  value    := []uint8{ 1, 2, 3 }
+  vsaBytes := VendorSpecificToBytes(311, 11, &value)
+  assert.Equal(t, expectedBytes, vsaBytes, "Vendor-Specific bytes are not correct!")
+
+  restoredValue, ok := BytesToVendorSpecific(vsaBytes, 311, 11)
+  assert.Equal(t, true,  ok,            "Vendor-Specific value is not found!")
+  assert.Equal(t, value, restoredValue, "Vendor-Specific value is not restored!")
+
+  _, ok = BytesToVendorSpecific(vsaBytes, 9, 11)
+  assert.Equal(t, false, ok, "Vendor-Specific value of another vendor is found!")
+}
+
+func TestDecryptDataDoesNotModifyInput(t *testing.T) {
+  secret        := []uint8("secret")
+  authenticator := []uint8{ 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16 }
+  empty         := []uint8{}
+  encryptedData := EncryptData(&empty, &authenticator, &secret)
+  original      := append([]uint8{}, encryptedData...)
+
+  decryptedData := DecryptData(&encryptedData, &authenticator, &secret)
+  assert.Equal(t, 0,        len(decryptedData), "Zero padding is not removed!")
+  assert.Equal(t, original, encryptedData,      "Input data is modified!")
+}